@@ -0,0 +1,170 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// engineCmd represents the engine command
+var engineCmd = &cobra.Command{
+	Use:   "engine",
+	Short: "Manages Cryptography Engine(s) of a Bhojpur Crypto server",
+}
+
+var engineListOpts struct {
+	Start int32
+	Limit int32
+}
+
+// engineListCmd represents the engine list command
+var engineListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists engines known to the server",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		conn := dial()
+		defer conn.Close()
+		client := v1.NewCryptoServiceClient(conn)
+
+		resp, err := client.ListEngines(context.Background(), &v1.ListEnginesRequest{
+			Start: engineListOpts.Start,
+			Limit: engineListOpts.Limit,
+		})
+		if err != nil {
+			log.WithError(err).Fatal("cannot list engines")
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 2, 4, 1, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tOWNER\tPHASE\tSUCCESS\tCREATED")
+		for _, st := range resp.Result {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%s\n",
+				st.Name,
+				st.GetMetadata().GetOwner(),
+				st.Phase,
+				st.GetConditions().GetSuccess(),
+				st.GetMetadata().GetCreated().AsTime().Local().Format(time.RFC3339),
+			)
+		}
+		tw.Flush()
+		if int(resp.Total) > len(resp.Result) {
+			fmt.Printf("showing %d of %d engines\n", len(resp.Result), resp.Total)
+		}
+	},
+}
+
+// engineGetCmd represents the engine get command
+var engineGetCmd = &cobra.Command{
+	Use:   "get <name>",
+	Short: "Prints the status of an engine",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conn := dial()
+		defer conn.Close()
+		client := v1.NewCryptoServiceClient(conn)
+
+		resp, err := client.GetEngine(context.Background(), &v1.GetEngineRequest{Name: args[0]})
+		if err != nil {
+			log.WithError(err).Fatal("cannot get engine")
+		}
+		out, err := protojson.MarshalOptions{Multiline: true}.Marshal(resp.Result)
+		if err != nil {
+			log.WithError(err).Fatal("cannot print engine")
+		}
+		fmt.Println(string(out))
+	},
+}
+
+// engineStopCmd represents the engine stop command
+var engineStopCmd = &cobra.Command{
+	Use:   "stop <name>",
+	Short: "Stops a running engine",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conn := dial()
+		defer conn.Close()
+		client := v1.NewCryptoServiceClient(conn)
+
+		if _, err := client.StopEngine(context.Background(), &v1.StopEngineRequest{Name: args[0]}); err != nil {
+			log.WithError(err).Fatal("cannot stop engine")
+		}
+	},
+}
+
+var engineReplayOpts struct {
+	GitOpsToken string
+	WaitUntil   string
+	Follow      bool
+}
+
+// engineReplayCmd represents the engine replay command
+var engineReplayCmd = &cobra.Command{
+	Use:   "replay <name>",
+	Short: "Starts a new engine with the inputs of a previous one",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		waitUntil, err := parseWaitUntil(engineReplayOpts.WaitUntil)
+		if err != nil {
+			log.WithError(err).Fatal("invalid --wait-until")
+		}
+
+		conn := dial()
+		defer conn.Close()
+		client := v1.NewCryptoServiceClient(conn)
+
+		resp, err := client.StartFromPreviousEngine(context.Background(), &v1.StartFromPreviousEngineRequest{
+			PreviousEngine: args[0],
+			GitopsToken:    engineReplayOpts.GitOpsToken,
+			WaitUntil:      waitUntil,
+		})
+		if err != nil {
+			log.WithError(err).Fatal("cannot replay engine")
+		}
+		fmt.Println(resp.Status.Name)
+
+		if engineReplayOpts.Follow {
+			if err := followEngine(client, resp.Status.Name); err != nil {
+				log.WithError(err).Fatal("cannot follow engine")
+			}
+		}
+	},
+}
+
+func init() {
+	engineListCmd.Flags().Int32Var(&engineListOpts.Start, "start", 0, "number of engines to skip")
+	engineListCmd.Flags().Int32Var(&engineListOpts.Limit, "limit", 50, "maximum number of engines to list")
+
+	engineReplayCmd.Flags().StringVar(&engineReplayOpts.GitOpsToken, "gitops-token", os.Getenv("CRYPTO_GITOPS_TOKEN"), "token required to replay engines of repositories (defaults to CRYPTO_GITOPS_TOKEN env var)")
+	engineReplayCmd.Flags().StringVar(&engineReplayOpts.WaitUntil, "wait-until", "", "delay the start of the engine until a time (RFC 3339) or for a duration")
+	engineReplayCmd.Flags().BoolVarP(&engineReplayOpts.Follow, "follow", "f", false, "follow the log output of the engine")
+
+	engineCmd.AddCommand(engineListCmd, engineGetCmd, engineReplayCmd, engineStopCmd)
+	rootCmd.AddCommand(engineCmd)
+}
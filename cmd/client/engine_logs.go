@@ -0,0 +1,93 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// engineLogsCmd represents the engine logs command
+var engineLogsCmd = &cobra.Command{
+	Use:   "logs <name>",
+	Short: "Prints the log output of an engine, following it until the engine is done",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conn := dial()
+		defer conn.Close()
+		client := v1.NewCryptoServiceClient(conn)
+
+		if err := followEngine(client, args[0]); err != nil {
+			log.WithError(err).Fatal("cannot listen to engine")
+		}
+	},
+}
+
+// followEngine prints the log output of an engine until it is done, and
+// reports its outcome.
+func followEngine(client v1.CryptoServiceClient, name string) error {
+	stream, err := client.Listen(context.Background(), &v1.ListenRequest{
+		Name:    name,
+		Updates: true,
+		Logs:    v1.ListenRequestLogs_LOGS_UNSLICED,
+	})
+	if err != nil {
+		return err
+	}
+
+	var last *v1.EngineStatus
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch content := resp.Content.(type) {
+		case *v1.ListenResponse_Slice:
+			fmt.Println(content.Slice.Payload)
+		case *v1.ListenResponse_Update:
+			last = content.Update
+		}
+	}
+
+	if last != nil && last.Phase == v1.EnginePhase_PHASE_DONE {
+		outcome := "succeeded"
+		if !last.GetConditions().GetSuccess() {
+			outcome = "failed"
+		}
+		if last.Details != "" {
+			outcome += ": " + last.Details
+		}
+		fmt.Printf("engine %s %s\n", name, outcome)
+	}
+	return nil
+}
+
+func init() {
+	engineCmd.AddCommand(engineLogsCmd)
+}
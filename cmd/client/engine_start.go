@@ -0,0 +1,120 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/user"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var engineStartOpts struct {
+	Application string
+	NameSuffix  string
+	WaitUntil   string
+	Follow      bool
+}
+
+// engineStartCmd represents the engine start command
+var engineStartCmd = &cobra.Command{
+	Use:   "start <engine.yaml>",
+	Short: "Starts an engine from an engine YAML file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		engineYAML, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			log.WithError(err).Fatal("cannot read engine spec")
+		}
+		var sideload []byte
+		if engineStartOpts.Application != "" {
+			sideload, err = ioutil.ReadFile(engineStartOpts.Application)
+			if err != nil {
+				log.WithError(err).Fatal("cannot read application")
+			}
+		}
+
+		waitUntil, err := parseWaitUntil(engineStartOpts.WaitUntil)
+		if err != nil {
+			log.WithError(err).Fatal("invalid --wait-until")
+		}
+
+		owner := "unknown"
+		if u, err := user.Current(); err == nil {
+			owner = u.Username
+		}
+
+		conn := dial()
+		defer conn.Close()
+		client := v1.NewCryptoServiceClient(conn)
+
+		resp, err := client.StartEngine(context.Background(), &v1.StartEngineRequest{
+			Metadata: &v1.EngineMetadata{
+				Owner:   owner,
+				Trigger: v1.EngineTrigger_TRIGGER_MANUAL,
+			},
+			EngineYaml: engineYAML,
+			Sideload:   sideload,
+			NameSuffix: engineStartOpts.NameSuffix,
+			WaitUntil:  waitUntil,
+		})
+		if err != nil {
+			log.WithError(err).Fatal("cannot start engine")
+		}
+		fmt.Println(resp.Status.Name)
+
+		if engineStartOpts.Follow {
+			if err := followEngine(client, resp.Status.Name); err != nil {
+				log.WithError(err).Fatal("cannot follow engine")
+			}
+		}
+	},
+}
+
+// parseWaitUntil parses a start time given either as RFC 3339 timestamp
+// or as duration from now. An empty value means no delay.
+func parseWaitUntil(s string) (*timestamppb.Timestamp, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return timestamppb.New(time.Now().Add(d)), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a duration nor an RFC 3339 timestamp", s)
+	}
+	return timestamppb.New(t), nil
+}
+
+func init() {
+	engineStartCmd.Flags().StringVar(&engineStartOpts.Application, "application", "", "gzipped application tar stream to start the engine with")
+	engineStartCmd.Flags().StringVar(&engineStartOpts.NameSuffix, "name-suffix", "", "suffix added to the name of the engine")
+	engineStartCmd.Flags().StringVar(&engineStartOpts.WaitUntil, "wait-until", "", "delay the start of the engine until a time (RFC 3339) or for a duration")
+	engineStartCmd.Flags().BoolVarP(&engineStartOpts.Follow, "follow", "f", false, "follow the log output of the engine")
+	engineCmd.AddCommand(engineStartCmd)
+}
@@ -0,0 +1,205 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/cas"
+	"github.com/bhojpur/crypto/pkg/executor"
+	"github.com/bhojpur/crypto/pkg/hub"
+	"github.com/bhojpur/crypto/pkg/server"
+	"github.com/bhojpur/crypto/pkg/store"
+	"github.com/bhojpur/crypto/pkg/store/postgres"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var serveCmdOpts struct {
+	Listen       string
+	DataDir      string
+	Storage      string
+	DB           string
+	Executor     string
+	KeepWorkdirs bool
+	Kubeconfig   string
+	Namespace    string
+	Image        string
+	GitOpsToken  string
+	StopGrace    time.Duration
+}
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Starts the Bhojpur Crypto server",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		cfg, err := newServiceConfig(ctx)
+		if err != nil {
+			return err
+		}
+
+		lis, err := net.Listen("tcp", serveCmdOpts.Listen)
+		if err != nil {
+			return fmt.Errorf("cannot listen on %s: %w", serveCmdOpts.Listen, err)
+		}
+		service := server.NewService(*cfg)
+		if err := service.Resume(ctx); err != nil {
+			return err
+		}
+		srv := grpc.NewServer()
+		v1.RegisterCryptoServiceServer(srv, service)
+
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-sigs
+			log.WithField("signal", sig).Info("shutting down")
+			srv.GracefulStop()
+		}()
+
+		log.WithField("addr", lis.Addr().String()).Info("serving Bhojpur Crypto API")
+		return srv.Serve(lis)
+	},
+}
+
+// newServiceConfig sets up the storage and executor of the service. Work
+// started in the background stops once ctx is done.
+func newServiceConfig(ctx context.Context) (*server.Config, error) {
+	cfg := server.Config{
+		Hub:             hub.New(),
+		GitOpsToken:     serveCmdOpts.GitOpsToken,
+		StopGracePeriod: serveCmdOpts.StopGrace,
+	}
+	fs, err := store.NewFileStore(serveCmdOpts.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open storage in %s: %w", serveCmdOpts.DataDir, err)
+	}
+	// Logs are kept in the data directory regardless of the storage.
+	cfg.Logs = fs
+	switch serveCmdOpts.Storage {
+	case "file":
+		cfg.Engines, cfg.Inputs, cfg.Numbers = fs, fs, fs
+	case "postgres":
+		if serveCmdOpts.DB == "" {
+			return nil, fmt.Errorf("postgres storage requires --db")
+		}
+		db, err := postgres.Open(ctx, serveCmdOpts.DB)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open database: %w", err)
+		}
+		cfg.Engines, cfg.Inputs, cfg.Numbers = db, db, db
+		// Learn of the updates of the other replicas sharing the database.
+		go func() {
+			err := db.Relay(ctx, serveCmdOpts.DB, cfg.Hub.Publish)
+			if err != nil && ctx.Err() == nil {
+				log.WithError(err).Error("cannot relay engine status updates between replicas")
+			}
+		}()
+	default:
+		return nil, fmt.Errorf("unknown storage: %s", serveCmdOpts.Storage)
+	}
+
+	content, err := cas.NewFileStore(filepath.Join(serveCmdOpts.DataDir, "content"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open content store: %w", err)
+	}
+	cfg.Content = content
+
+	switch serveCmdOpts.Executor {
+	case "noop":
+		cfg.Executor = executor.Noop{}
+	case "local":
+		local, err := executor.NewLocal(filepath.Join(serveCmdOpts.DataDir, "work"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot set up local executor: %w", err)
+		}
+		local.Keep = serveCmdOpts.KeepWorkdirs
+		cfg.Executor = local
+	case "kubernetes":
+		// An empty kubeconfig selects the in-cluster configuration.
+		restCfg, err := clientcmd.BuildConfigFromFlags("", serveCmdOpts.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load kubernetes configuration: %w", err)
+		}
+		client, err := kubernetes.NewForConfig(restCfg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to kubernetes: %w", err)
+		}
+		kube, err := executor.NewKubernetes(ctx, client, serveCmdOpts.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("cannot set up kubernetes executor: %w", err)
+		}
+		if serveCmdOpts.Image != "" {
+			kube.Image = serveCmdOpts.Image
+		}
+		go func() {
+			<-ctx.Done()
+			kube.Close()
+		}()
+		cfg.Executor = kube
+	default:
+		return nil, fmt.Errorf("unknown executor: %s", serveCmdOpts.Executor)
+	}
+	return &cfg, nil
+}
+
+func init() {
+	dataDir := os.Getenv("CRYPTO_DATA_DIR")
+	if dataDir == "" {
+		dataDir = "/var/lib/crypto"
+		if home, err := os.UserHomeDir(); err == nil && os.Geteuid() != 0 {
+			dataDir = filepath.Join(home, ".crypto")
+		}
+	}
+
+	namespace := os.Getenv("CRYPTO_NAMESPACE")
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	serveCmd.Flags().StringVar(&serveCmdOpts.Listen, "listen", ":7777", "address the API is served on")
+	serveCmd.Flags().StringVar(&serveCmdOpts.DataDir, "data-dir", dataDir, "directory holding engine logs, content and file storage (defaults to CRYPTO_DATA_DIR env var)")
+	serveCmd.Flags().StringVar(&serveCmdOpts.Storage, "storage", "file", "where engine status is stored. Valid values are \"file\" or \"postgres\"")
+	serveCmd.Flags().StringVar(&serveCmdOpts.DB, "db", os.Getenv("CRYPTO_DB"), "[postgres storage] connection string of the database (defaults to CRYPTO_DB env var)")
+	serveCmd.Flags().StringVar(&serveCmdOpts.GitOpsToken, "gitops-token", os.Getenv("CRYPTO_GITOPS_TOKEN"), "token required to replay engines of repositories (defaults to CRYPTO_GITOPS_TOKEN env var)")
+	serveCmd.Flags().StringVar(&serveCmdOpts.Executor, "executor", "noop", "how engines are executed. Valid values are \"noop\", \"local\" or \"kubernetes\"")
+	serveCmd.Flags().DurationVar(&serveCmdOpts.StopGrace, "stop-grace-period", server.DefaultStopGracePeriod, "time stopped engines get to terminate before they are killed")
+	serveCmd.Flags().BoolVar(&serveCmdOpts.KeepWorkdirs, "keep-workdirs", false, "[local executor] keep the working directories of engines once they are done")
+	serveCmd.Flags().StringVar(&serveCmdOpts.Kubeconfig, "kubeconfig", os.Getenv("KUBECONFIG"), "[kubernetes executor] path to the kubeconfig file, the in-cluster configuration is used if empty (defaults to KUBECONFIG env var)")
+	serveCmd.Flags().StringVar(&serveCmdOpts.Namespace, "namespace", namespace, "[kubernetes executor] namespace engine pods are created in (defaults to CRYPTO_NAMESPACE env var)")
+	serveCmd.Flags().StringVar(&serveCmdOpts.Image, "image", "", "[kubernetes executor] image the steps of engines run in (defaults to "+executor.DefaultKubernetesImage+")")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,112 @@
+package cas
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package cas implements a content addressable store for blobs, such as
+// engine results and application tar streams. Blobs are identified by their
+// digest, which is verified whenever content enters or leaves a store.
+//
+// On disk, blobs are laid out by algorithm and the first byte of their
+// encoded digest:
+//
+// 	blobs/<alg>/<xx>/<hex>
+//
+// A Store may be wrapped with NewIndex to resolve short digest prefixes
+// through a digestset.Set.
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/bhojpur/crypto/pkg/digest"
+)
+
+var (
+	// ErrNotFound is returned when a blob does not exist in a store.
+	ErrNotFound = errors.New("blob not found")
+
+	// ErrDigestMismatch is returned when content does not match the digest
+	// it is stored or requested under.
+	ErrDigestMismatch = errors.New("content does not match digest")
+)
+
+// Info describes a blob held by a store.
+type Info struct {
+	Digest  digest.Digest
+	Size    int64
+	ModTime time.Time
+}
+
+// WalkFunc is called by Store.Walk for each blob. Returning an error stops
+// the walk and the error is returned from Walk.
+type WalkFunc func(Info) error
+
+// Store is a content addressable blob store.
+type Store interface {
+	// Put stores the content read from r under the digest d. The content is
+	// verified against d before it becomes visible, ErrDigestMismatch is
+	// returned if it does not match. If the blob already exists, r is not
+	// read.
+	Put(d digest.Digest, r io.Reader) error
+
+	// Ingest stores the content read from r, computing its digest with the
+	// given algorithm.
+	Ingest(alg digest.Algorithm, r io.Reader) (digest.Digest, error)
+
+	// Get returns a reader for the blob. The content is verified while it
+	// is read, and instead of io.EOF ErrDigestMismatch is returned at the
+	// end of the content if it does not match the digest.
+	Get(d digest.Digest) (io.ReadCloser, error)
+
+	// Stat returns information about the blob.
+	Stat(d digest.Digest) (Info, error)
+
+	// Delete removes the blob.
+	Delete(d digest.Digest) error
+
+	// Walk calls fn for each blob in the store.
+	Walk(fn WalkFunc) error
+}
+
+// verifyingReader verifies the content read through it against a digest.
+type verifyingReader struct {
+	rc       io.ReadCloser
+	verifier digest.Verifier
+}
+
+// newVerifyingReader returns a reader verifying rc against d.
+func newVerifyingReader(rc io.ReadCloser, d digest.Digest) io.ReadCloser {
+	return &verifyingReader{rc: rc, verifier: d.Verifier()}
+}
+
+func (vr *verifyingReader) Read(p []byte) (int, error) {
+	n, err := vr.rc.Read(p)
+	vr.verifier.Write(p[:n])
+	if err == io.EOF && !vr.verifier.Verified() {
+		err = ErrDigestMismatch
+	}
+	return n, err
+}
+
+func (vr *verifyingReader) Close() error {
+	return vr.rc.Close()
+}
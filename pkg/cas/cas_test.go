@@ -0,0 +1,210 @@
+package cas
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/bhojpur/crypto/pkg/digest"
+)
+
+func newStores(t *testing.T) map[string]Store {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	return map[string]Store{
+		"file":   fs,
+		"memory": NewMemoryStore(),
+	}
+}
+
+func readBlob(t *testing.T, s Store, d digest.Digest) ([]byte, error) {
+	rc, err := s.Get(d)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func TestStore(t *testing.T) {
+	for name, s := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			content := []byte("bhojpur")
+			d := digest.FromBytes(content)
+
+			if _, err := s.Stat(d); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound before put, got %v", err)
+			}
+			if err := s.Put(d, bytes.NewReader(content)); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			// A second put of an existing blob is a no-op.
+			if err := s.Put(d, strings.NewReader("other")); err != nil {
+				t.Fatalf("Put existing: %v", err)
+			}
+
+			info, err := s.Stat(d)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if info.Digest != d || info.Size != int64(len(content)) {
+				t.Fatalf("unexpected info: %+v", info)
+			}
+			got, err := readBlob(t, s, d)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("unexpected content: %q", got)
+			}
+
+			d512, err := s.Ingest(digest.SHA512, strings.NewReader("crypto"))
+			if err != nil {
+				t.Fatalf("Ingest: %v", err)
+			}
+			if d512 != digest.SHA512.FromString("crypto") {
+				t.Fatalf("unexpected ingested digest: %v", d512)
+			}
+
+			var walked []digest.Digest
+			if err := s.Walk(func(info Info) error {
+				walked = append(walked, info.Digest)
+				return nil
+			}); err != nil {
+				t.Fatalf("Walk: %v", err)
+			}
+			if len(walked) != 2 {
+				t.Fatalf("expected 2 blobs, walked %v", walked)
+			}
+
+			if err := s.Delete(d); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if err := s.Delete(d); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound on second delete, got %v", err)
+			}
+			if _, err := s.Get(d); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound after delete, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStorePutMismatch(t *testing.T) {
+	for name, s := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			d := digest.FromString("bhojpur")
+			if err := s.Put(d, strings.NewReader("tampered")); !errors.Is(err, ErrDigestMismatch) {
+				t.Fatalf("expected ErrDigestMismatch, got %v", err)
+			}
+			if _, err := s.Stat(d); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("mismatched blob must not be committed, got %v", err)
+			}
+			if err := s.Put("sha256:../../etc", strings.NewReader("")); err == nil {
+				t.Fatalf("expected invalid digest to be rejected")
+			}
+		})
+	}
+}
+
+func TestFileStoreLayout(t *testing.T) {
+	root := t.TempDir()
+	s, err := NewFileStore(root)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	d, err := s.Ingest(digest.SHA256, strings.NewReader("bhojpur"))
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	enc := d.Encoded()
+	path := filepath.Join(root, "blobs", "sha256", enc[:2], enc)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("blob not at expected path: %v", err)
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(root, "ingest"))
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("temporary files left behind: %d", len(entries))
+	}
+
+	// Stray files are not reported by Walk.
+	if err := ioutil.WriteFile(filepath.Join(root, "blobs", "sha256", enc[:2], "junk"), nil, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	var n int
+	if err := s.Walk(func(Info) error { n++; return nil }); err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 blob, walked %d", n)
+	}
+
+	// Corrupt content is detected on read.
+	if err := ioutil.WriteFile(path, []byte("bhojpuR"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := readBlob(t, s, d); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected ErrDigestMismatch on read, got %v", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	s := NewMemoryStore()
+	d1, err := s.Ingest(digest.SHA256, strings.NewReader("bhojpur"))
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	idx, err := NewIndex(s)
+	if err != nil {
+		t.Fatalf("NewIndex: %v", err)
+	}
+	if got, err := idx.Lookup(d1.Encoded()[:8]); err != nil || got != d1 {
+		t.Fatalf("Lookup existing: %v, %v", got, err)
+	}
+
+	d2, err := idx.Ingest(digest.SHA256, strings.NewReader("crypto"))
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	if !idx.Set().Contains(d2) {
+		t.Fatalf("ingested digest not indexed")
+	}
+	if err := idx.Delete(d1); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if idx.Set().Contains(d1) || idx.Set().Len() != 1 {
+		t.Fatalf("deleted digest still indexed")
+	}
+}
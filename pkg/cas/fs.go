@@ -0,0 +1,246 @@
+package cas
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/bhojpur/crypto/pkg/digest"
+)
+
+const (
+	blobsDir      = "blobs"
+	ingestDir     = "ingest"
+	quarantineDir = "quarantine"
+)
+
+// FileStore is a Store keeping blobs in a directory tree. Content is written
+// to a temporary file in the same tree first, and only renamed into place
+// once it has been synced and verified, so that a blob is either complete or
+// absent.
+type FileStore struct {
+	root string
+}
+
+var _ Store = &FileStore{}
+
+// NewFileStore returns a FileStore rooted at the given directory, creating
+// it if needed.
+func NewFileStore(root string) (*FileStore, error) {
+	for _, dir := range []string{blobsDir, ingestDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &FileStore{root: root}, nil
+}
+
+// Root returns the directory the store is rooted at.
+func (s *FileStore) Root() string {
+	return s.root
+}
+
+// BlobPath returns the path a blob with the digest d is stored at. The
+// digest is validated first, so that it cannot escape the store.
+func (s *FileStore) BlobPath(d digest.Digest) (string, error) {
+	if err := d.Validate(); err != nil {
+		return "", err
+	}
+	enc := d.Encoded()
+	return filepath.Join(s.root, blobsDir, string(d.Algorithm()), enc[:2], enc), nil
+}
+
+// Put implements Store.
+func (s *FileStore) Put(d digest.Digest, r io.Reader) error {
+	path, err := s.BlobPath(d)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	verifier := d.Verifier()
+	tmp, err := s.writeTemp(r, verifier)
+	if err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		os.Remove(tmp)
+		return fmt.Errorf("%w: %s", ErrDigestMismatch, d)
+	}
+	return s.commit(tmp, path)
+}
+
+// Ingest implements Store.
+func (s *FileStore) Ingest(alg digest.Algorithm, r io.Reader) (digest.Digest, error) {
+	if !alg.Available() {
+		return "", digest.ErrDigestUnsupported
+	}
+	digester := alg.Digester()
+	tmp, err := s.writeTemp(r, digester.Hash())
+	if err != nil {
+		return "", err
+	}
+	d := digester.Digest()
+	path, err := s.BlobPath(d)
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		os.Remove(tmp)
+		return d, nil
+	}
+	return d, s.commit(tmp, path)
+}
+
+// writeTemp copies r to a temporary file, feeding w along the way, and
+// returns the path of the synced file.
+func (s *FileStore) writeTemp(r io.Reader, w io.Writer) (string, error) {
+	f, err := os.CreateTemp(filepath.Join(s.root, ingestDir), "blob-")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(io.MultiWriter(f, w), r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// commit moves the temporary file tmp into place at path.
+func (s *FileStore) commit(tmp, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Get implements Store.
+func (s *FileStore) Get(d digest.Digest) (io.ReadCloser, error) {
+	path, err := s.BlobPath(d)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, notFound(err, d)
+	}
+	return newVerifyingReader(f, d), nil
+}
+
+// Stat implements Store.
+func (s *FileStore) Stat(d digest.Digest) (Info, error) {
+	path, err := s.BlobPath(d)
+	if err != nil {
+		return Info{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Info{}, notFound(err, d)
+	}
+	return Info{Digest: d, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(d digest.Digest) error {
+	path, err := s.BlobPath(d)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return notFound(err, d)
+	}
+	return nil
+}
+
+// Walk implements Store. Files in the tree that do not name a valid digest
+// at the location it would be stored at are skipped.
+func (s *FileStore) Walk(fn WalkFunc) error {
+	base := filepath.Join(s.root, blobsDir)
+	return filepath.WalkDir(base, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		d, ok := digestOfPath(".", filepath.ToSlash(rel))
+		if !ok {
+			return nil
+		}
+		fi, err := de.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return fn(Info{Digest: d, Size: fi.Size(), ModTime: fi.ModTime()})
+	})
+}
+
+// Quarantine moves a blob out of the store, into the quarantine directory
+// next to the blobs, where it is kept for inspection. Stores wrapped by an
+// Index must be re-indexed afterwards.
+func (s *FileStore) Quarantine(d digest.Digest) error {
+	path, err := s.BlobPath(d)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(s.root, quarantineDir, string(d.Algorithm()), d.Encoded())
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(path, dst); err != nil {
+		return notFound(err, d)
+	}
+	return nil
+}
+
+// notFound maps a not-exist error to ErrNotFound.
+func notFound(err error, d digest.Digest) error {
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrNotFound, d)
+	}
+	return err
+}
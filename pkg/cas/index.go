@@ -0,0 +1,85 @@
+package cas
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"io"
+
+	"github.com/bhojpur/crypto/pkg/digest"
+	"github.com/bhojpur/crypto/pkg/digestset"
+)
+
+// Index wraps a Store and keeps a digestset.Set of the blobs it holds, so
+// that blobs can be looked up by short digest strings. Changes must go
+// through the Index to be reflected in it.
+type Index struct {
+	Store
+	set *digestset.Set
+}
+
+// NewIndex returns an Index over s, populated by walking the store.
+func NewIndex(s Store) (*Index, error) {
+	set := digestset.NewSet()
+	err := s.Walk(func(info Info) error {
+		return set.Add(info.Digest)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Index{Store: s, set: set}, nil
+}
+
+// Set returns the set of digests held by the store. It must not be
+// modified by the caller.
+func (i *Index) Set() *digestset.Set {
+	return i.set
+}
+
+// Lookup resolves a full or short digest string to a blob digest, as
+// described by digestset.Set.Lookup.
+func (i *Index) Lookup(short string) (digest.Digest, error) {
+	return i.set.Lookup(short)
+}
+
+// Put implements Store.
+func (i *Index) Put(d digest.Digest, r io.Reader) error {
+	if err := i.Store.Put(d, r); err != nil {
+		return err
+	}
+	return i.set.Add(d)
+}
+
+// Ingest implements Store.
+func (i *Index) Ingest(alg digest.Algorithm, r io.Reader) (digest.Digest, error) {
+	d, err := i.Store.Ingest(alg, r)
+	if err != nil {
+		return "", err
+	}
+	return d, i.set.Add(d)
+}
+
+// Delete implements Store.
+func (i *Index) Delete(d digest.Digest) error {
+	if err := i.Store.Delete(d); err != nil {
+		return err
+	}
+	return i.set.Remove(d)
+}
@@ -0,0 +1,147 @@
+package cas
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bhojpur/crypto/pkg/digest"
+)
+
+// MemoryStore is a Store keeping blobs in memory. It is mostly useful for
+// tests.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	blobs map[digest.Digest]memoryBlob
+}
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+var _ Store = &MemoryStore{}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{blobs: map[digest.Digest]memoryBlob{}}
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(d digest.Digest, r io.Reader) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	if _, err := s.Stat(d); err == nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	verifier := d.Verifier()
+	verifier.Write(data)
+	if !verifier.Verified() {
+		return fmt.Errorf("%w: %s", ErrDigestMismatch, d)
+	}
+	s.put(d, data)
+	return nil
+}
+
+// Ingest implements Store.
+func (s *MemoryStore) Ingest(alg digest.Algorithm, r io.Reader) (digest.Digest, error) {
+	if !alg.Available() {
+		return "", digest.ErrDigestUnsupported
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	d := alg.FromBytes(data)
+	s.put(d, data)
+	return d, nil
+}
+
+func (s *MemoryStore) put(d digest.Digest, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blobs[d]; !ok {
+		s.blobs[d] = memoryBlob{data: data, modTime: time.Now()}
+	}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(d digest.Digest) (io.ReadCloser, error) {
+	s.mu.RLock()
+	blob, ok := s.blobs[d]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, d)
+	}
+	return newVerifyingReader(ioutil.NopCloser(bytes.NewReader(blob.data)), d), nil
+}
+
+// Stat implements Store.
+func (s *MemoryStore) Stat(d digest.Digest) (Info, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	blob, ok := s.blobs[d]
+	if !ok {
+		return Info{}, fmt.Errorf("%w: %s", ErrNotFound, d)
+	}
+	return Info{Digest: d, Size: int64(len(blob.data)), ModTime: blob.modTime}, nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(d digest.Digest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blobs[d]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, d)
+	}
+	delete(s.blobs, d)
+	return nil
+}
+
+// Walk implements Store. Blobs are visited in digest order, and fn may
+// modify the store.
+func (s *MemoryStore) Walk(fn WalkFunc) error {
+	s.mu.RLock()
+	infos := make([]Info, 0, len(s.blobs))
+	for d, blob := range s.blobs {
+		infos = append(infos, Info{Digest: d, Size: int64(len(blob.data)), ModTime: blob.modTime})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Digest < infos[j].Digest })
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
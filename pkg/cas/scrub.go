@@ -0,0 +1,366 @@
+package cas
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/crypto/pkg/digest"
+	"github.com/bhojpur/crypto/pkg/digestset"
+	"github.com/bhojpur/crypto/pkg/resumable"
+)
+
+// DefaultCheckpointInterval is the checkpoint interval used by a Scrubber
+// if none is set.
+const DefaultCheckpointInterval = 64 << 20
+
+const scrubBufferSize = 32 << 10
+
+// Scrubber walks a tree laid out as <alg>/<xx>/<hex>, such as the blobs
+// directory of a FileStore, re-hashing each blob with its declared algorithm
+// to detect content that no longer matches its digest.
+type Scrubber struct {
+	// FS is the file system holding the blobs.
+	FS fs.FS
+
+	// Root is the directory in FS the layout starts at, "." if empty.
+	Root string
+
+	// Expected is the set of digests the tree is supposed to hold. If set,
+	// the report lists the missing and orphaned blobs.
+	Expected *digestset.Set
+
+	// Quarantine is called for each corrupt blob, if set. Its error is
+	// recorded in the report and does not stop the scrub.
+	Quarantine func(d digest.Digest) error
+
+	// BytesPerSecond limits the rate at which blobs are read. Zero means
+	// no limit.
+	BytesPerSecond int64
+
+	// CheckpointInterval is the number of bytes between checkpoints of the
+	// hash state of a blob, if its algorithm supports resumable hashing.
+	// DefaultCheckpointInterval is used if zero. Resumable hashing requires
+	// the program to import a resumable implementation of the algorithm,
+	// such as pkg/resumable/sha256, otherwise blobs are hashed without
+	// checkpoints and interrupted ones are hashed again from the start.
+	CheckpointInterval int64
+
+	// Resume continues an interrupted scrub from the position recorded in
+	// ScrubReport.Resume.
+	Resume *ScrubCheckpoint
+}
+
+// ScrubCheckpoint records how far a scrub got before it was interrupted.
+type ScrubCheckpoint struct {
+	// Path is the blob the scrub was interrupted in, relative to FS.
+	Path string
+
+	// Checkpoint is the latest hash state of the blob, if any was taken.
+	// A zero Offset means the blob has to be hashed from the start.
+	Checkpoint resumable.Checkpoint
+}
+
+// ScrubEntry describes a corrupt blob.
+type ScrubEntry struct {
+	Path   string
+	Digest digest.Digest
+
+	// Actual is the digest of the content found.
+	Actual digest.Digest
+
+	// QuarantineErr is the error returned by Scrubber.Quarantine, if any.
+	QuarantineErr error
+}
+
+// ScrubReport is the outcome of a scrub.
+type ScrubReport struct {
+	// Scanned is the number of blobs hashed, and Bytes their size.
+	Scanned int
+	Bytes   int64
+
+	// Corrupt lists the blobs whose content does not match their digest.
+	Corrupt []ScrubEntry
+
+	// Missing lists the expected digests that were not found.
+	Missing []digest.Digest
+
+	// Orphaned lists the blobs found that were not expected.
+	Orphaned []digest.Digest
+
+	// Stray lists the files that do not name a digest that can be
+	// verified, at the location it would be stored at.
+	Stray []string
+
+	// Resume is set if the scrub was interrupted. It can be passed to a
+	// new Scrubber to continue from where this one stopped.
+	Resume *ScrubCheckpoint
+}
+
+// NewScrubber returns a Scrubber for the store, quarantining corrupt blobs
+// through FileStore.Quarantine.
+func (s *FileStore) NewScrubber() *Scrubber {
+	return &Scrubber{
+		FS:         os.DirFS(s.root),
+		Root:       blobsDir,
+		Quarantine: s.Quarantine,
+	}
+}
+
+// Scrub walks the tree and returns a report of its findings. If ctx is
+// cancelled, the partial report is returned with Resume set, along with the
+// context error. When resuming, the report only covers the blobs hashed by
+// this scrub, except that Missing and Orphaned take the whole tree into
+// account.
+func (s *Scrubber) Scrub(ctx context.Context) (*ScrubReport, error) {
+	root := s.Root
+	if root == "" {
+		root = "."
+	}
+	sc := &scrub{
+		Scrubber: s,
+		report:   &ScrubReport{},
+		seen:     digestset.NewSet(),
+		limiter:  newLimiter(s.BytesPerSecond),
+		buf:      make([]byte, scrubBufferSize),
+	}
+	err := fs.WalkDir(s.FS, root, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		return sc.visit(ctx, root, p)
+	})
+	if err != nil {
+		return sc.report, err
+	}
+
+	if s.Expected != nil {
+		s.Expected.Walk(func(d digest.Digest) bool {
+			if !sc.seen.Contains(d) {
+				sc.report.Missing = append(sc.report.Missing, d)
+			}
+			return true
+		})
+		sc.report.Orphaned = sc.seen.Difference(s.Expected).All()
+		sort.Slice(sc.report.Orphaned, func(i, j int) bool {
+			return sc.report.Orphaned[i] < sc.report.Orphaned[j]
+		})
+	}
+	return sc.report, nil
+}
+
+// scrub holds the state of a single run of a Scrubber.
+type scrub struct {
+	*Scrubber
+	report  *ScrubReport
+	seen    *digestset.Set
+	limiter *limiter
+	buf     []byte
+}
+
+func (sc *scrub) visit(ctx context.Context, root, p string) error {
+	d, ok := digestOfPath(root, p)
+	if !ok {
+		sc.report.Stray = append(sc.report.Stray, p)
+		return nil
+	}
+	sc.seen.Add(d)
+
+	var resume *resumable.Checkpoint
+	if sc.Resume != nil {
+		if walkBefore(p, sc.Resume.Path) {
+			return nil
+		}
+		if p == sc.Resume.Path {
+			resume = &sc.Resume.Checkpoint
+		}
+	}
+
+	actual, n, err := sc.hash(ctx, p, d.Algorithm(), resume)
+	sc.report.Bytes += n
+	if err != nil {
+		return err
+	}
+	sc.report.Scanned++
+	if actual == d {
+		return nil
+	}
+
+	entry := ScrubEntry{Path: p, Digest: d, Actual: actual}
+	if sc.Quarantine != nil {
+		entry.QuarantineErr = sc.Quarantine(d)
+	}
+	sc.report.Corrupt = append(sc.report.Corrupt, entry)
+	return nil
+}
+
+// hash computes the digest of the file at p, starting from resume if set.
+// It returns the number of bytes read.
+func (sc *scrub) hash(ctx context.Context, p string, alg digest.Algorithm, resume *resumable.Checkpoint) (digest.Digest, int64, error) {
+	f, err := sc.FS.Open(p)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	interval := sc.CheckpointInterval
+	if interval <= 0 {
+		interval = DefaultCheckpointInterval
+	}
+
+	var (
+		h  hash.Hash
+		w  io.Writer
+		cp *resumable.Checkpointer
+	)
+	if rd, err := alg.ResumableDigester(); err == nil {
+		rh := rd.Hash().(resumable.Hash)
+		if resume != nil && resume.Offset > 0 {
+			if err := rh.Restore(resume.State); err != nil {
+				return "", 0, err
+			}
+			if err := skip(f, resume.Offset); err != nil {
+				return "", 0, err
+			}
+		}
+		if cp, err = resumable.NewCheckpointer(rh, interval); err != nil {
+			return "", 0, err
+		}
+		h, w = rh, cp
+	} else {
+		h = alg.Hash()
+		w = h
+	}
+
+	var read int64
+	for {
+		if err := ctx.Err(); err != nil {
+			sc.report.Resume = &ScrubCheckpoint{Path: p}
+			if cp != nil {
+				cps := cp.Checkpoints()
+				sc.report.Resume.Checkpoint = cps[len(cps)-1]
+			}
+			return "", read, err
+		}
+		n, err := f.Read(sc.buf)
+		if n > 0 {
+			read += int64(n)
+			w.Write(sc.buf[:n])
+			// A cancelled wait is picked up at the top of the loop.
+			sc.limiter.wait(ctx, n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", read, err
+		}
+	}
+	return digest.NewDigest(alg, h), read, nil
+}
+
+// skip advances f by n bytes, seeking if possible.
+func skip(f fs.File, n int64) error {
+	if s, ok := f.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekStart)
+		return err
+	}
+	m, err := io.CopyN(io.Discard, f, n)
+	if err == io.EOF && m < n {
+		// The blob shrank, the hash will not match.
+		return nil
+	}
+	return err
+}
+
+// digestOfPath returns the digest named by a path below root, if the path
+// is where that digest is stored and the digest can be verified.
+func digestOfPath(root, p string) (digest.Digest, bool) {
+	rel := p
+	if root != "." {
+		rel = strings.TrimPrefix(p, root+"/")
+	}
+	parts := strings.Split(rel, "/")
+	if len(parts) != 3 || len(parts[1]) != 2 || !strings.HasPrefix(parts[2], parts[1]) {
+		return "", false
+	}
+	d := digest.NewDigestFromEncoded(digest.Algorithm(parts[0]), parts[2])
+	if d.Validate() != nil {
+		return "", false
+	}
+	return d, true
+}
+
+// walkBefore reports whether fs.WalkDir visits a before b. WalkDir visits
+// the entries of a directory in lexical order, which differs from the
+// lexical order of full paths when names contain bytes below '/'.
+func walkBefore(a, b string) bool {
+	as, bs := strings.Split(path.Clean(a), "/"), strings.Split(path.Clean(b), "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// limiter paces reads to a number of bytes per second.
+type limiter struct {
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+func newLimiter(rate int64) *limiter {
+	return &limiter{rate: rate, start: time.Now()}
+}
+
+// wait blocks until n more bytes may be read, or ctx is done.
+func (l *limiter) wait(ctx context.Context, n int) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	l.n += int64(n)
+	due := time.Duration(float64(l.n) / float64(l.rate) * float64(time.Second))
+	delay := due - time.Since(l.start)
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,242 @@
+package cas
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/bhojpur/crypto/pkg/digest"
+	"github.com/bhojpur/crypto/pkg/digestset"
+	_ "github.com/bhojpur/crypto/pkg/resumable/sha256"
+)
+
+func TestScrubFileStore(t *testing.T) {
+	root := t.TempDir()
+	s, err := NewFileStore(root)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	good, err := s.Ingest(digest.SHA256, strings.NewReader("good"))
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	bad, err := s.Ingest(digest.SHA512, strings.NewReader("bad"))
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	orphan, err := s.Ingest(digest.SHA256, strings.NewReader("orphan"))
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	missing := digest.FromString("missing")
+
+	badPath, _ := s.BlobPath(bad)
+	if err := ioutil.WriteFile(badPath, []byte("rotten"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "blobs", "sha256", "stray"), nil, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	expected := digestset.NewSet()
+	for _, d := range []digest.Digest{good, bad, missing} {
+		expected.Add(d)
+	}
+	scrubber := s.NewScrubber()
+	scrubber.Expected = expected
+	report, err := scrubber.Scrub(context.Background())
+	if err != nil {
+		t.Fatalf("Scrub: %v", err)
+	}
+
+	if report.Scanned != 3 {
+		t.Fatalf("expected 3 blobs scanned, got %d", report.Scanned)
+	}
+	if len(report.Corrupt) != 1 || report.Corrupt[0].Digest != bad ||
+		report.Corrupt[0].Actual != digest.SHA512.FromString("rotten") || report.Corrupt[0].QuarantineErr != nil {
+		t.Fatalf("unexpected corrupt entries: %+v", report.Corrupt)
+	}
+	if len(report.Missing) != 1 || report.Missing[0] != missing {
+		t.Fatalf("unexpected missing entries: %v", report.Missing)
+	}
+	if len(report.Orphaned) != 1 || report.Orphaned[0] != orphan {
+		t.Fatalf("unexpected orphaned entries: %v", report.Orphaned)
+	}
+	if len(report.Stray) != 1 || report.Stray[0] != "blobs/sha256/stray" {
+		t.Fatalf("unexpected stray entries: %v", report.Stray)
+	}
+	if report.Resume != nil {
+		t.Fatalf("unexpected resume point: %+v", report.Resume)
+	}
+
+	if _, err := s.Stat(bad); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("corrupt blob not quarantined: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "quarantine", "sha512", bad.Encoded())); err != nil {
+		t.Fatalf("quarantined blob not kept: %v", err)
+	}
+}
+
+// cancellingFS cancels a context once a number of bytes have been read.
+type cancellingFS struct {
+	fs.FS
+	after  int
+	cancel context.CancelFunc
+}
+
+func (c *cancellingFS) Open(name string) (fs.File, error) {
+	f, err := c.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &cancellingFile{File: f, fs: c}, nil
+}
+
+func (c *cancellingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(c.FS, name)
+}
+
+type cancellingFile struct {
+	fs.File
+	fs *cancellingFS
+}
+
+func (f *cancellingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if f.fs.after -= n; f.fs.after <= 0 {
+		f.fs.cancel()
+	}
+	return n, err
+}
+
+func TestScrubResume(t *testing.T) {
+	content := bytes.Repeat([]byte("bhojpur "), 128<<10)
+	d := digest.FromBytes(content)
+	p := "sha256/" + d.Encoded()[:2] + "/" + d.Encoded()
+	mapfs := fstest.MapFS{p: &fstest.MapFile{Data: content}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scrubber := &Scrubber{
+		FS:                 &cancellingFS{FS: mapfs, after: 300 << 10, cancel: cancel},
+		CheckpointInterval: 64 << 10,
+	}
+	report, err := scrubber.Scrub(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if report.Resume == nil || report.Resume.Path != p {
+		t.Fatalf("unexpected resume point: %+v", report.Resume)
+	}
+	offset := report.Resume.Checkpoint.Offset
+	if offset == 0 || offset%(64<<10) != 0 {
+		t.Fatalf("unexpected checkpoint offset %d", offset)
+	}
+
+	resumed := &Scrubber{FS: mapfs, Resume: report.Resume}
+	report, err = resumed.Scrub(context.Background())
+	if err != nil {
+		t.Fatalf("Scrub: %v", err)
+	}
+	if report.Scanned != 1 || len(report.Corrupt) != 0 {
+		t.Fatalf("unexpected report after resume: %+v", report)
+	}
+	if report.Bytes != int64(len(content))-offset {
+		t.Fatalf("expected %d bytes read after resume, got %d", int64(len(content))-offset, report.Bytes)
+	}
+}
+
+func TestScrubResumeWithoutCheckpoints(t *testing.T) {
+	// No resumable implementation of SHA-512 is imported, so the blob is
+	// hashed without checkpoints.
+	content := bytes.Repeat([]byte("bhojpur "), 128<<10)
+	d := digest.SHA512.FromBytes(content)
+	p := "sha512/" + d.Encoded()[:2] + "/" + d.Encoded()
+	mapfs := fstest.MapFS{p: &fstest.MapFile{Data: content}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scrubber := &Scrubber{
+		FS:                 &cancellingFS{FS: mapfs, after: 300 << 10, cancel: cancel},
+		CheckpointInterval: 64 << 10,
+	}
+	report, err := scrubber.Scrub(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if report.Resume == nil || report.Resume.Path != p || report.Resume.Checkpoint.Offset != 0 {
+		t.Fatalf("unexpected resume point: %+v", report.Resume)
+	}
+
+	resumed := &Scrubber{FS: mapfs, Resume: report.Resume}
+	report, err = resumed.Scrub(context.Background())
+	if err != nil {
+		t.Fatalf("Scrub: %v", err)
+	}
+	if report.Scanned != 1 || len(report.Corrupt) != 0 || report.Bytes != int64(len(content)) {
+		t.Fatalf("unexpected report after resume: %+v", report)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1 << 20)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.wait(context.Background(), 64<<10); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("limiter did not pace reads: %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx, 1<<20); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWalkBefore(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"sha256/ab/ab01", "sha256/ab/ab02", true},
+		{"sha256/ab/ab02", "sha256/ab/ab01", false},
+		{"sha256/ff/ff00", "sha256.x/00/0000", true},
+		{"sha256/ab/ab01", "sha256/ab/ab01", false},
+	} {
+		if got := walkBefore(tc.a, tc.b); got != tc.want {
+			t.Fatalf("walkBefore(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -21,11 +21,16 @@ package digest
 // THE SOFTWARE.
 
 import (
+	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
 	"regexp"
+	"runtime"
 	"sync"
+	"sync/atomic"
+
+	"github.com/bhojpur/crypto/pkg/resumable"
 )
 
 // Algorithm identifies and implementation of a digester by an identifier.
@@ -70,7 +75,14 @@ var (
 	// Note that /A-F/ disallowed.
 	anchoredEncodedRegexps = map[Algorithm]*regexp.Regexp{}
 
-	// algorithmsLock protects algorithms, and anchoredEncodedRegexps
+	// resumables maps values to constructors of resumable hash
+	// implementations. An algorithm does not need to be listed here to be
+	// resumable, see Algorithm.ResumableDigester.
+	//
+	// See: RegisterResumable
+	resumables = map[Algorithm]func() resumable.Hash{}
+
+	// algorithmsLock protects algorithms, anchoredEncodedRegexps and resumables
 	algorithmsLock sync.RWMutex
 )
 
@@ -102,6 +114,32 @@ func RegisterAlgorithm(algorithm Algorithm, implementation CryptoHash) bool {
 	return true
 }
 
+// RegisterResumable may be called to dynamically register a resumable
+// implementation of an algorithm. The constructor is preferred over the
+// CryptoHash registered with RegisterAlgorithm when calling
+// Algorithm.ResumableDigester, so resumable hashes can be provided without
+// replacing the implementations in the global crypto registry. If a resumable
+// implementation is already registered for the algorithm, the return value is
+// false, otherwise if registration was successful the return value is true.
+//
+// The algorithm name must be conformant to the BNF specification in the OCI
+// image-spec, otherwise the function will panic.
+func RegisterResumable(algorithm Algorithm, newHash func() resumable.Hash) bool {
+	algorithmsLock.Lock()
+	defer algorithmsLock.Unlock()
+
+	if !algorithmRegexp.MatchString(string(algorithm)) {
+		panic(fmt.Sprintf("Algorithm %s has a name which does not fit within the allowed grammar", algorithm))
+	}
+
+	if _, ok := resumables[algorithm]; ok {
+		return false
+	}
+
+	resumables[algorithm] = newHash
+	return true
+}
+
 // hexDigestRegex can be used to generate a regex for RegisterAlgorithm.
 func hexDigestRegex(cryptoHash CryptoHash) *regexp.Regexp {
 	hexdigestbytes := cryptoHash.Size() * 2
@@ -165,6 +203,31 @@ func (a Algorithm) Digester() Digester {
 	}
 }
 
+// ResumableDigester returns a new digester for the specified algorithm whose
+// state can be snapshotted and restored. An implementation registered with
+// RegisterResumable is used if present, otherwise the hash returned by
+// Algorithm.Hash is used if it implements resumable.Hash. If the algorithm is
+// not available ErrDigestUnsupported is returned, and if no resumable
+// implementation can be found ErrDigestNotResumable is returned.
+func (a Algorithm) ResumableDigester() (ResumableDigester, error) {
+	algorithmsLock.RLock()
+	newHash, ok := resumables[a]
+	algorithmsLock.RUnlock()
+	if ok {
+		return &resumableDigester{alg: a, hash: newHash()}, nil
+	}
+
+	if !a.Available() {
+		return nil, ErrDigestUnsupported
+	}
+
+	h, ok := a.Hash().(resumable.Hash)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDigestNotResumable, a)
+	}
+	return &resumableDigester{alg: a, hash: h}, nil
+}
+
 // Hash returns a new hash as used by the algorithm. If not available, the
 // method will panic. Check Algorithm.Available() before calling.
 func (a Algorithm) Hash() hash.Hash {
@@ -227,6 +290,69 @@ func (a Algorithm) FromBytes(p []byte) Digest {
 	return digester.Digest()
 }
 
+// batchChunk is the number of inputs a FromBytesBatch worker claims at once.
+const batchChunk = 64
+
+// FromBytesBatch digests each input and returns the digests in the same
+// order. The inputs are spread over a pool of GOMAXPROCS workers, each of
+// which reuses a single hash and encoding buffer, which makes this
+// considerably cheaper than calling FromBytes for many small inputs.
+func (a Algorithm) FromBytesBatch(ps [][]byte) []Digest {
+	dgsts := make([]Digest, len(ps))
+	if len(ps) == 0 {
+		return dgsts
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if chunks := (len(ps) + batchChunk - 1) / batchChunk; chunks < workers {
+		workers = chunks
+	}
+
+	var (
+		next int64
+		wg   sync.WaitGroup
+	)
+	work := func(h hash.Hash) {
+		defer wg.Done()
+
+		prefix := len(a) + 1
+		encoded := make([]byte, prefix+2*h.Size())
+		copy(encoded, a)
+		encoded[len(a)] = ':'
+		sum := make([]byte, 0, h.Size())
+		for {
+			start := int(atomic.AddInt64(&next, batchChunk)) - batchChunk
+			if start >= len(ps) {
+				return
+			}
+			end := start + batchChunk
+			if end > len(ps) {
+				end = len(ps)
+			}
+			for i := start; i < end; i++ {
+				h.Reset()
+				if _, err := h.Write(ps[i]); err != nil {
+					// See FromBytes for why this panics.
+					panic("write to hash function returned error: " + err.Error())
+				}
+				sum = h.Sum(sum[:0])
+				hex.Encode(encoded[prefix:], sum)
+				dgsts[i] = Digest(encoded)
+			}
+		}
+	}
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		// Create the hashes up front so that an unavailable algorithm
+		// panics in the caller's goroutine.
+		go work(a.Hash())
+	}
+	wg.Wait()
+
+	return dgsts
+}
+
 // FromString digests the string input and returns a Digest.
 func (a Algorithm) FromString(s string) Digest {
 	return a.FromBytes([]byte(s))
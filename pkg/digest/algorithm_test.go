@@ -24,10 +24,15 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/rand"
+	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"strings"
 	"testing"
+
+	"github.com/bhojpur/crypto/pkg/resumable"
 )
 
 func TestFlagInterface(t *testing.T) {
@@ -153,3 +158,157 @@ func TestGoodAlgorithmNameRegistration(t *testing.T) {
 	expectNoPanic("sha256-test")
 	expectNoPanic("sha256_384")
 }
+
+// bufferedHash is a trivially resumable hash that keeps everything written
+// to it, used to exercise RegisterResumable.
+type bufferedHash struct {
+	buf bytes.Buffer
+}
+
+func (h *bufferedHash) Write(p []byte) (int, error) { return h.buf.Write(p) }
+func (h *bufferedHash) Sum(b []byte) []byte {
+	sum := sha256.Sum256(h.buf.Bytes())
+	return append(b, sum[:]...)
+}
+func (h *bufferedHash) Reset()                 { h.buf.Reset() }
+func (h *bufferedHash) Size() int              { return sha256.Size }
+func (h *bufferedHash) BlockSize() int         { return sha256.BlockSize }
+func (h *bufferedHash) Len() int64             { return int64(h.buf.Len()) }
+func (h *bufferedHash) State() ([]byte, error) { return append([]byte(nil), h.buf.Bytes()...), nil }
+func (h *bufferedHash) Restore(state []byte) error {
+	h.buf.Reset()
+	h.buf.Write(state)
+	return nil
+}
+
+func TestResumableDigester(t *testing.T) {
+	if _, err := Algorithm("bean").ResumableDigester(); err != ErrDigestUnsupported {
+		t.Fatalf("unexpected error for unsupported algorithm: %v", err)
+	}
+
+	// the stdlib implementation registered by this package is not resumable
+	if _, err := SHA256.ResumableDigester(); !errors.Is(err, ErrDigestNotResumable) {
+		t.Fatalf("unexpected error for non-resumable algorithm: %v", err)
+	}
+
+	alg := Algorithm("sha256-buffered")
+	if !RegisterResumable(alg, func() resumable.Hash { return &bufferedHash{} }) {
+		t.Fatal("expected registration to succeed")
+	}
+	if RegisterResumable(alg, func() resumable.Hash { return &bufferedHash{} }) {
+		t.Fatal("expected duplicate registration to fail")
+	}
+
+	p := []byte("resumable content")
+	first, err := alg.ResumableDigester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Hash().Write(p[:8])
+	state, err := first.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := alg.ResumableDigester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Restore(state); err != nil {
+		t.Fatal(err)
+	}
+	if second.Len() != 8 {
+		t.Fatalf("unexpected length after restore: %d != 8", second.Len())
+	}
+	second.Hash().Write(p[8:])
+
+	expected := NewDigestFromEncoded(alg, SHA256.FromBytes(p).Encoded())
+	if dgst := second.Digest(); dgst != expected {
+		t.Fatalf("unexpected digest %v != %v", dgst, expected)
+	}
+}
+
+func TestFromBytesBatch(t *testing.T) {
+	for _, n := range []int{0, 1, batchChunk - 1, batchChunk*10 + 3} {
+		ps := make([][]byte, n)
+		for i := range ps {
+			ps[i] = make([]byte, i%200)
+			rand.Read(ps[i])
+		}
+
+		for alg := range algorithms {
+			dgsts := alg.FromBytesBatch(ps)
+			if len(dgsts) != n {
+				t.Fatalf("unexpected number of digests: %d != %d", len(dgsts), n)
+			}
+			for i, p := range ps {
+				if expected := alg.FromBytes(p); dgsts[i] != expected {
+					t.Fatalf("unexpected digest at %d: %v != %v", i, dgsts[i], expected)
+				}
+			}
+		}
+	}
+}
+
+func smallInputs(n, size int) [][]byte {
+	ps := make([][]byte, n)
+	for i := range ps {
+		ps[i] = make([]byte, size)
+		rand.Read(ps[i])
+	}
+	return ps
+}
+
+func BenchmarkFromBytes4096x64(b *testing.B) {
+	ps := smallInputs(4096, 64)
+	b.SetBytes(4096 * 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range ps {
+			Canonical.FromBytes(p)
+		}
+	}
+}
+
+func BenchmarkFromBytesBatch4096x64(b *testing.B) {
+	ps := smallInputs(4096, 64)
+	b.SetBytes(4096 * 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Canonical.FromBytesBatch(ps)
+	}
+}
+
+func TestPrefixDigester(t *testing.T) {
+	parts := []string{"first layer", "", "second layer", "third layer"}
+
+	d := SHA256.PrefixDigester()
+	var written string
+	for _, part := range parts {
+		io.WriteString(d.Hash(), part)
+		written += part
+		if dgst := d.Mark(); dgst != SHA256.FromString(written) {
+			t.Fatalf("unexpected digest for prefix %q: %v", written, dgst)
+		}
+	}
+	if d.Digest() != SHA256.FromString(written) {
+		t.Fatalf("marking prefixes disturbed the running hash")
+	}
+
+	prefixes := d.Prefixes()
+	if len(prefixes) != len(parts) {
+		t.Fatalf("unexpected number of prefixes: %d != %d", len(prefixes), len(parts))
+	}
+	var offset int64
+	for i, part := range parts {
+		offset += int64(len(part))
+		if prefixes[i].Offset != offset {
+			t.Fatalf("unexpected offset for prefix %d: %d != %d", i, prefixes[i].Offset, offset)
+		}
+	}
+
+	// the stdlib implementation registered by this package cannot be forked
+	if _, err := d.Fork(); !errors.Is(err, ErrDigestNotResumable) {
+		t.Fatalf("unexpected error forking non-resumable digester: %v", err)
+	}
+}
@@ -79,6 +79,10 @@ var (
 
 	// ErrDigestUnsupported returned when the digest algorithm is unsupported.
 	ErrDigestUnsupported = fmt.Errorf("unsupported digest algorithm")
+
+	// ErrDigestNotResumable returned when the digest algorithm has no
+	// resumable implementation.
+	ErrDigestNotResumable = fmt.Errorf("digest algorithm is not resumable")
 )
 
 // Parse parses s and returns the validated digest object. An error will
@@ -20,7 +20,12 @@ package digest
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-import "hash"
+import (
+	"fmt"
+	"hash"
+
+	"github.com/bhojpur/crypto/pkg/resumable"
+)
 
 // Digester calculates the digest of written data. Writes should go directly
 // to the return value of Hash, while calling Digest will return the current
@@ -43,3 +48,153 @@ func (d *digester) Hash() hash.Hash {
 func (d *digester) Digest() Digest {
 	return NewDigest(d.alg, d.hash)
 }
+
+// ResumableDigester is a Digester whose state can be snapshotted and later
+// restored, for example to continue digesting an interrupted upload.
+type ResumableDigester interface {
+	Digester
+
+	// Len returns the number of bytes written to the digester so far.
+	Len() int64
+
+	// State returns a snapshot of the state of the digester.
+	State() ([]byte, error)
+
+	// Restore resets the digester to the given state.
+	Restore(state []byte) error
+}
+
+// resumableDigester embeds a resumable hasher.
+type resumableDigester struct {
+	alg  Algorithm
+	hash resumable.Hash
+}
+
+func (d *resumableDigester) Hash() hash.Hash {
+	return d.hash
+}
+
+func (d *resumableDigester) Digest() Digest {
+	return NewDigest(d.alg, d.hash)
+}
+
+func (d *resumableDigester) Len() int64 {
+	return d.hash.Len()
+}
+
+func (d *resumableDigester) State() ([]byte, error) {
+	return d.hash.State()
+}
+
+func (d *resumableDigester) Restore(state []byte) error {
+	return d.hash.Restore(state)
+}
+
+// Prefix is the digest of the first Offset bytes written to a PrefixDigester.
+type Prefix struct {
+	Offset int64
+	Digest Digest
+}
+
+// PrefixDigester is a Digester that records the digest of the content written
+// so far at boundaries marked by the caller, for example at the end of each
+// layer of a concatenated archive. Marking a boundary does not disturb the
+// running hash.
+type PrefixDigester struct {
+	alg      Algorithm
+	hash     *countingHash
+	prefixes []Prefix
+}
+
+// PrefixDigester returns a new prefix digester for the specified algorithm.
+// A resumable implementation is used if one is available, which allows the
+// digester to be forked. If the algorithm is not available, the method will
+// panic.
+func (a Algorithm) PrefixDigester() *PrefixDigester {
+	var h hash.Hash
+	if rd, err := a.ResumableDigester(); err == nil {
+		h = rd.Hash()
+	} else {
+		h = a.Hash()
+	}
+	return &PrefixDigester{
+		alg:  a,
+		hash: &countingHash{Hash: h},
+	}
+}
+
+// Hash provides direct access to the underlying hash instance. Writes to it
+// are accounted for in the offsets of marked prefixes.
+func (d *PrefixDigester) Hash() hash.Hash {
+	return d.hash
+}
+
+// Digest returns the digest of all content written so far.
+func (d *PrefixDigester) Digest() Digest {
+	return NewDigest(d.alg, d.hash)
+}
+
+// Len returns the number of bytes written so far.
+func (d *PrefixDigester) Len() int64 {
+	return d.hash.n
+}
+
+// Mark records and returns the digest of all content written so far.
+func (d *PrefixDigester) Mark() Digest {
+	dgst := d.Digest()
+	d.prefixes = append(d.prefixes, Prefix{Offset: d.hash.n, Digest: dgst})
+	return dgst
+}
+
+// Prefixes returns the digests recorded by Mark, in the order they were
+// marked.
+func (d *PrefixDigester) Prefixes() []Prefix {
+	prefixes := make([]Prefix, len(d.prefixes))
+	copy(prefixes, d.prefixes)
+	return prefixes
+}
+
+// Fork returns a new Digester continuing from the current state of the
+// running hash. Writes to the fork do not affect this digester. Forking uses
+// resumable.Cloner if the hash implements it and falls back to a State and
+// Restore round-trip otherwise. ErrDigestNotResumable is returned if the
+// hash is not resumable.
+func (d *PrefixDigester) Fork() (Digester, error) {
+	if c, ok := d.hash.Hash.(resumable.Cloner); ok {
+		return &resumableDigester{alg: d.alg, hash: c.Clone()}, nil
+	}
+
+	rh, ok := d.hash.Hash.(resumable.Hash)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDigestNotResumable, d.alg)
+	}
+	state, err := rh.State()
+	if err != nil {
+		return nil, err
+	}
+	fork, err := d.alg.ResumableDigester()
+	if err != nil {
+		return nil, err
+	}
+	if err := fork.Restore(state); err != nil {
+		return nil, err
+	}
+	return fork, nil
+}
+
+// countingHash counts the bytes written to a hash.
+type countingHash struct {
+	hash.Hash
+	n int64
+}
+
+func (h *countingHash) Write(p []byte) (int, error) {
+	n, err := h.Hash.Write(p)
+	h.n += int64(n)
+	return n, err
+}
+
+func (h *countingHash) Reset() {
+	h.Hash.Reset()
+	h.n = 0
+}
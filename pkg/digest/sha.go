@@ -23,21 +23,28 @@ package digest
 import (
 	"crypto"
 
-	// make sure crypto.SHA256 is registered
+	// make sure crypto.SHA224 and crypto.SHA256 are registered
 	_ "crypto/sha256"
 
-	// make sure crypto.sha512 and crypto.SHA384 are registered
+	// make sure crypto.SHA384, crypto.SHA512, crypto.SHA512_224 and
+	// crypto.SHA512_256 are registered
 	_ "crypto/sha512"
 )
 
 const (
-	SHA256 Algorithm = "sha256" // sha256 with hex encoding (lower case only)
-	SHA384 Algorithm = "sha384" // sha384 with hex encoding (lower case only)
-	SHA512 Algorithm = "sha512" // sha512 with hex encoding (lower case only)
+	SHA224     Algorithm = "sha224"     // sha224 with hex encoding (lower case only)
+	SHA256     Algorithm = "sha256"     // sha256 with hex encoding (lower case only)
+	SHA384     Algorithm = "sha384"     // sha384 with hex encoding (lower case only)
+	SHA512     Algorithm = "sha512"     // sha512 with hex encoding (lower case only)
+	SHA512_224 Algorithm = "sha512_224" // sha512/224 with hex encoding (lower case only)
+	SHA512_256 Algorithm = "sha512_256" // sha512/256 with hex encoding (lower case only)
 )
 
 func init() {
+	RegisterAlgorithm(SHA224, crypto.SHA224)
 	RegisterAlgorithm(SHA256, crypto.SHA256)
 	RegisterAlgorithm(SHA384, crypto.SHA384)
 	RegisterAlgorithm(SHA512, crypto.SHA512)
+	RegisterAlgorithm(SHA512_224, crypto.SHA512_224)
+	RegisterAlgorithm(SHA512_256, crypto.SHA512_256)
 }
@@ -0,0 +1,453 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"strings"
+	"sync"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+var (
+	// ErrFilterFull is returned when a digest cannot be inserted into a
+	// CuckooFilter because it has reached its capacity.
+	ErrFilterFull = errors.New("cuckoo filter is full")
+
+	// ErrBadFilter is returned when a serialized CuckooFilter is malformed or
+	// fails checksum verification.
+	ErrBadFilter = errors.New("bad cuckoo filter")
+
+	// errShortDigest is returned for digests whose encoded value is too
+	// short to derive a bucket index and fingerprint from.
+	errShortDigest = errors.New("digest too short for cuckoo filter")
+
+	filterMagic = [8]byte{'d', 'g', 's', 't', 'c', 'k', 'o', 1}
+)
+
+const (
+	// bucketSize is the number of fingerprints held by each bucket.
+	bucketSize = 4
+	// maxKicks bounds the number of relocations attempted by Insert.
+	maxKicks = 500
+	// loadFactor is the occupancy a cuckoo filter with buckets of four
+	// fingerprints reliably reaches before insertions start to fail.
+	loadFactor = 0.95
+	// minEncodedLen is the number of hex characters taken from a digest:
+	// 16 for the bucket index and 8 for the fingerprint.
+	minEncodedLen = 24
+)
+
+// CuckooFilter is a probabilistic set of digests. It answers whether a
+// digest might be in the set, with a configurable false positive rate but
+// no false negatives, in a fraction of the memory a Set needs. Unlike a
+// Bloom filter it supports deletion.
+//
+// Digests are uniformly distributed already, so bucket indexes and
+// fingerprints are taken directly from the encoded value of a digest rather
+// than from rehashing it. Only the algorithm-independent encoded value is
+// considered, and it must be hex encoded and at least 24 characters long.
+type CuckooFilter struct {
+	mutex   sync.RWMutex
+	bits    uint   // bits per fingerprint
+	buckets uint64 // number of buckets, a power of two
+	table   []uint64
+	count   uint64
+	rng     uint64
+
+	// victim holds a fingerprint evicted by a failed insertion, so that
+	// no inserted digest is ever lost.
+	hasVictim   bool
+	victimIndex uint64
+	victimFP    uint32
+}
+
+// NewCuckooFilter returns an empty filter sized to hold capacity digests
+// with the given false positive rate, which must be between 0 and 1.
+func NewCuckooFilter(capacity uint64, falsePositiveRate float64) *CuckooFilter {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		panic("cuckoo filter false positive rate must be between 0 and 1")
+	}
+	// A lookup compares against two buckets, so it has 2*bucketSize
+	// chances to match a fingerprint of the given size.
+	bits := uint(math.Ceil(math.Log2(2 * bucketSize / falsePositiveRate)))
+	if bits > 32 {
+		bits = 32
+	}
+
+	buckets := uint64(1)
+	for float64(buckets*bucketSize)*loadFactor < float64(capacity) {
+		buckets <<= 1
+	}
+	return &CuckooFilter{
+		bits:    bits,
+		buckets: buckets,
+		table:   make([]uint64, (buckets*bucketSize*uint64(bits)+63)/64),
+		rng:     0x9e3779b97f4a7c15,
+	}
+}
+
+// Insert adds the digest to the filter. ErrFilterFull is returned if the
+// filter has reached its capacity.
+func (f *CuckooFilter) Insert(d digest.Digest) error {
+	i1, fp, err := f.locate(d)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.hasVictim {
+		return ErrFilterFull
+	}
+
+	f.place(i1, fp)
+	f.count++
+	return nil
+}
+
+// place stores the fingerprint in bucket i or its alternate. If both are
+// full, existing fingerprints are relocated to their alternate buckets until
+// a free slot is found. Should that fail, the last evicted fingerprint is
+// kept aside as the victim, so that the filter has no false negatives, and
+// no further insertions are accepted.
+func (f *CuckooFilter) place(i uint64, fp uint32) {
+	if f.insertInto(i, fp) {
+		return
+	}
+	i = f.altIndex(i, fp)
+	if f.insertInto(i, fp) {
+		return
+	}
+	for n := 0; n < maxKicks; n++ {
+		slot := i*bucketSize + f.random()%bucketSize
+		evicted := f.get(slot)
+		f.set(slot, fp)
+		fp = evicted
+		i = f.altIndex(i, fp)
+		if f.insertInto(i, fp) {
+			return
+		}
+	}
+	f.hasVictim = true
+	f.victimIndex = i
+	f.victimFP = fp
+}
+
+// Contains reports whether the digest might be in the filter. False
+// positives occur at about the configured rate, false negatives never.
+func (f *CuckooFilter) Contains(d digest.Digest) bool {
+	i1, fp, err := f.locate(d)
+	if err != nil {
+		return false
+	}
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	i2 := f.altIndex(i1, fp)
+	if f.hasVictim && f.victimFP == fp && (f.victimIndex == i1 || f.victimIndex == i2) {
+		return true
+	}
+	return f.find(i1, fp) >= 0 || f.find(i2, fp) >= 0
+}
+
+// Delete removes the digest from the filter, returning false if it was not
+// found. Only digests known to have been inserted should be deleted, as
+// deleting a false positive removes a digest sharing its fingerprint.
+func (f *CuckooFilter) Delete(d digest.Digest) bool {
+	i1, fp, err := f.locate(d)
+	if err != nil {
+		return false
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	i2 := f.altIndex(i1, fp)
+	if f.hasVictim && f.victimFP == fp && (f.victimIndex == i1 || f.victimIndex == i2) {
+		f.hasVictim = false
+		f.count--
+		return true
+	}
+	for _, i := range []uint64{i1, i2} {
+		if slot := f.find(i, fp); slot >= 0 {
+			f.set(uint64(slot), 0)
+			f.count--
+			f.reinsertVictim()
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of digests in the filter.
+func (f *CuckooFilter) Len() uint64 {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.count
+}
+
+// reinsertVictim tries to move the victim back into the table once a
+// deletion made room.
+func (f *CuckooFilter) reinsertVictim() {
+	if !f.hasVictim {
+		return
+	}
+	f.hasVictim = false
+	f.place(f.victimIndex, f.victimFP)
+}
+
+// locate returns the primary bucket index and the fingerprint of d.
+func (f *CuckooFilter) locate(d digest.Digest) (uint64, uint32, error) {
+	i := strings.Index(string(d), ":")
+	if i < 0 {
+		return 0, 0, digest.ErrDigestInvalidFormat
+	}
+	encoded := string(d[i+1:])
+	if len(encoded) < minEncodedLen {
+		return 0, 0, errShortDigest
+	}
+	index, ok := parseHex(encoded[:16])
+	if !ok {
+		return 0, 0, digest.ErrDigestInvalidFormat
+	}
+	fp, ok := parseHex(encoded[16:minEncodedLen])
+	if !ok {
+		return 0, 0, digest.ErrDigestInvalidFormat
+	}
+	return index & (f.buckets - 1), f.fingerprint(fp), nil
+}
+
+// fingerprint truncates v to the fingerprint size. Zero marks an empty
+// slot, so it is mapped to one.
+func (f *CuckooFilter) fingerprint(v uint64) uint32 {
+	fp := uint32(v & (1<<f.bits - 1))
+	if fp == 0 {
+		fp = 1
+	}
+	return fp
+}
+
+// altIndex returns the other bucket a fingerprint may live in. Only the
+// fingerprint is available when relocating, so the alternate bucket is
+// derived from it. Applying altIndex twice yields the original bucket.
+func (f *CuckooFilter) altIndex(i uint64, fp uint32) uint64 {
+	return (i ^ (uint64(fp) * 0x5bd1e9955bd1e995)) & (f.buckets - 1)
+}
+
+func (f *CuckooFilter) insertInto(i uint64, fp uint32) bool {
+	for slot := i * bucketSize; slot < (i+1)*bucketSize; slot++ {
+		if f.get(slot) == 0 {
+			f.set(slot, fp)
+			return true
+		}
+	}
+	return false
+}
+
+func (f *CuckooFilter) find(i uint64, fp uint32) int64 {
+	for slot := i * bucketSize; slot < (i+1)*bucketSize; slot++ {
+		if f.get(slot) == fp {
+			return int64(slot)
+		}
+	}
+	return -1
+}
+
+// get returns the fingerprint held in slot. Fingerprints are packed into the
+// table and may straddle two words.
+func (f *CuckooFilter) get(slot uint64) uint32 {
+	bit := slot * uint64(f.bits)
+	word, off := bit/64, bit%64
+	v := f.table[word] >> off
+	if off+uint64(f.bits) > 64 {
+		v |= f.table[word+1] << (64 - off)
+	}
+	return uint32(v & (1<<f.bits - 1))
+}
+
+func (f *CuckooFilter) set(slot uint64, fp uint32) {
+	bit := slot * uint64(f.bits)
+	word, off := bit/64, bit%64
+	mask := uint64(1)<<f.bits - 1
+	f.table[word] = f.table[word]&^(mask<<off) | uint64(fp)<<off
+	if off+uint64(f.bits) > 64 {
+		shift := 64 - off
+		f.table[word+1] = f.table[word+1]&^(mask>>shift) | uint64(fp)>>shift
+	}
+}
+
+// random returns the next value of a xorshift generator, used to pick
+// fingerprints for eviction.
+func (f *CuckooFilter) random() uint64 {
+	f.rng ^= f.rng << 13
+	f.rng ^= f.rng >> 7
+	f.rng ^= f.rng << 17
+	return f.rng
+}
+
+// parseHex parses up to 16 lower case hex characters.
+func parseHex(s string) (uint64, bool) {
+	var v uint64
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		default:
+			return 0, false
+		}
+		v = v<<4 | uint64(c)
+	}
+	return v, true
+}
+
+// WriteTo serializes the filter to w. The encoding consists of a header,
+// the filter parameters, the packed fingerprint table and a trailing
+// CRC-32C checksum of all preceding bytes.
+func (f *CuckooFilter) WriteTo(w io.Writer) (int64, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	crc := crc32.New(crcTable)
+	mw := io.MultiWriter(bw, crc)
+
+	var hasVictim uint8
+	if f.hasVictim {
+		hasVictim = 1
+	}
+	mw.Write(filterMagic[:])
+	binary.Write(mw, binary.BigEndian, filterHeader{
+		Bits:        uint8(f.bits),
+		Buckets:     f.buckets,
+		Count:       f.count,
+		HasVictim:   hasVictim,
+		VictimIndex: f.victimIndex,
+		VictimFP:    f.victimFP,
+	})
+	binary.Write(mw, binary.BigEndian, f.table)
+	binary.Write(bw, binary.BigEndian, crc.Sum32())
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ReadFrom replaces the filter with one serialized by WriteTo read from r.
+// The filter is left unchanged if the serialized filter is malformed. r is
+// read no further than the end of the serialized filter.
+func (f *CuckooFilter) ReadFrom(r io.Reader) (int64, error) {
+	// All parts have a known size and are read in one piece each, so r
+	// needs no buffering, which would read past the end.
+	cr := &countingReader{r: r}
+	crc := crc32.New(crcTable)
+	tr := io.TeeReader(cr, crc)
+
+	var magic [len(filterMagic)]byte
+	if _, err := io.ReadFull(tr, magic[:]); err != nil {
+		return cr.n, unexpected(err)
+	}
+	if magic != filterMagic {
+		return cr.n, ErrBadFilter
+	}
+	var hdr filterHeader
+	if err := binary.Read(tr, binary.BigEndian, &hdr); err != nil {
+		return cr.n, unexpected(err)
+	}
+	if hdr.Bits == 0 || hdr.Bits > 32 || hdr.Buckets == 0 || hdr.Buckets&(hdr.Buckets-1) != 0 ||
+		hdr.Buckets > maxFilterBuckets || hdr.Count > hdr.Buckets*bucketSize {
+		return cr.n, ErrBadFilter
+	}
+	table, err := readTable(tr, (hdr.Buckets*bucketSize*uint64(hdr.Bits)+63)/64)
+	if err != nil {
+		return cr.n, err
+	}
+	expected := crc.Sum32()
+	var sum uint32
+	if err := binary.Read(tr, binary.BigEndian, &sum); err != nil {
+		return cr.n, unexpected(err)
+	}
+	if sum != expected {
+		return cr.n, ErrBadFilter
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.bits = uint(hdr.Bits)
+	f.buckets = hdr.Buckets
+	f.table = table
+	f.count = hdr.Count
+	f.hasVictim = hdr.HasVictim == 1
+	f.victimIndex = hdr.VictimIndex
+	f.victimFP = hdr.VictimFP
+	if f.rng == 0 {
+		f.rng = 0x9e3779b97f4a7c15
+	}
+	return cr.n, nil
+}
+
+// maxFilterBuckets bounds the size of serialized filters ReadFrom accepts.
+const maxFilterBuckets = 1 << 32
+
+// tableChunk is the number of table words read at once. The table grows
+// as it is read, so that a header announcing more data than follows does
+// not allocate the whole table up front.
+const tableChunk = 1 << 16
+
+// readTable reads a fingerprint table of n words. ErrBadFilter is returned
+// if the input ends before.
+func readTable(r io.Reader, n uint64) ([]uint64, error) {
+	var table []uint64
+	buf := make([]byte, 8*tableChunk)
+	for remaining := n; remaining > 0; {
+		words := remaining
+		if words > tableChunk {
+			words = tableChunk
+		}
+		chunk := buf[:8*words]
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, ErrBadFilter
+			}
+			return nil, err
+		}
+		for i := uint64(0); i < words; i++ {
+			table = append(table, binary.BigEndian.Uint64(chunk[8*i:]))
+		}
+		remaining -= words
+	}
+	return table, nil
+}
+
+// filterHeader is the fixed size part of a serialized CuckooFilter.
+type filterHeader struct {
+	Bits        uint8
+	Buckets     uint64
+	Count       uint64
+	HasVictim   uint8
+	VictimIndex uint64
+	VictimFP    uint32
+}
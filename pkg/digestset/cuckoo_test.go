@@ -0,0 +1,212 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestCuckooFilter(t *testing.T) {
+	digests, err := createDigests(20000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inserted, absent := digests[:10000], digests[10000:]
+
+	const rate = 0.01
+	f := NewCuckooFilter(uint64(len(inserted)), rate)
+	for _, d := range inserted {
+		if err := f.Insert(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.Len() != uint64(len(inserted)) {
+		t.Fatalf("Unexpected length:\n\tExpected: %d\n\tActual: %d", len(inserted), f.Len())
+	}
+	for _, d := range inserted {
+		if !f.Contains(d) {
+			t.Fatalf("False negative for %s", d)
+		}
+	}
+
+	falsePositives := 0
+	for _, d := range absent {
+		if f.Contains(d) {
+			falsePositives++
+		}
+	}
+	if actual := float64(falsePositives) / float64(len(absent)); actual > 2*rate {
+		t.Fatalf("False positive rate too high:\n\tExpected: %f\n\tActual: %f", rate, actual)
+	}
+
+	for _, d := range inserted[:5000] {
+		if !f.Delete(d) {
+			t.Fatalf("Unable to delete %s", d)
+		}
+	}
+	if f.Len() != 5000 {
+		t.Fatalf("Unexpected length after delete: %d", f.Len())
+	}
+	for _, d := range inserted[5000:] {
+		if !f.Contains(d) {
+			t.Fatalf("False negative after delete for %s", d)
+		}
+	}
+
+	if err := f.Insert("sha256:1234"); err == nil {
+		t.Fatal("Expected error inserting short digest")
+	}
+}
+
+func TestCuckooFilterFull(t *testing.T) {
+	digests, err := createDigests(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewCuckooFilter(16, 0.01)
+	var added int
+	for _, d := range digests {
+		if err := f.Insert(d); err == ErrFilterFull {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		added++
+	}
+	if added == len(digests) {
+		t.Fatal("Expected filter to fill up")
+	}
+	// Everything inserted before the filter filled up must be found,
+	// including the fingerprint evicted by the last insertion.
+	for _, d := range digests[:added] {
+		if !f.Contains(d) {
+			t.Fatalf("False negative for %s", d)
+		}
+	}
+
+	// Deleting makes room again.
+	for _, d := range digests[:added/2] {
+		if !f.Delete(d) {
+			t.Fatalf("Unable to delete %s", d)
+		}
+	}
+	if err := f.Insert(digests[added]); err != nil {
+		t.Fatalf("Unable to insert after delete: %v", err)
+	}
+}
+
+func TestCuckooFilterSerialization(t *testing.T) {
+	digests, err := createDigests(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewCuckooFilter(1000, 0.001)
+	for _, d := range digests {
+		if err := f.Insert(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("Unexpected number of bytes written: %d != %d", n, buf.Len())
+	}
+	serialized := buf.Bytes()
+
+	// Data following the filter is left to be read.
+	stream := bytes.NewReader(append(append([]byte(nil), serialized...), "trailer"...))
+	var restored CuckooFilter
+	if n, err := restored.ReadFrom(stream); err != nil || n != int64(len(serialized)) {
+		t.Fatalf("Unexpected read: %d bytes, %v", n, err)
+	}
+	if stream.Len() != len("trailer") {
+		t.Fatalf("Filter read past its end, %d bytes left", stream.Len())
+	}
+	if restored.Len() != f.Len() {
+		t.Fatalf("Unexpected length:\n\tExpected: %d\n\tActual: %d", f.Len(), restored.Len())
+	}
+	for _, d := range digests {
+		if !restored.Contains(d) {
+			t.Fatalf("False negative for %s", d)
+		}
+	}
+
+	corrupt := append([]byte(nil), serialized...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	if _, err := restored.ReadFrom(bytes.NewReader(corrupt)); err != ErrBadFilter {
+		t.Fatalf("Expected %v, got %v", ErrBadFilter, err)
+	}
+}
+
+func TestCuckooFilterMalformedHeader(t *testing.T) {
+	header := func(bits uint8, buckets, count uint64) []byte {
+		var buf bytes.Buffer
+		buf.Write(filterMagic[:])
+		binary.Write(&buf, binary.BigEndian, filterHeader{Bits: bits, Buckets: buckets, Count: count})
+		return buf.Bytes()
+	}
+	tests := []struct {
+		Name  string
+		Input []byte
+	}{
+		{"huge table", header(32, 1<<36, 0)},
+		{"table larger than input", header(32, 1<<20, 0)},
+		{"too many buckets", header(8, 1<<41, 0)},
+		{"count exceeds capacity", header(8, 4, 17)},
+		{"no bits", header(0, 4, 0)},
+		{"too many bits", header(33, 4, 0)},
+		{"buckets not a power of two", header(8, 3, 0)},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			f := NewCuckooFilter(10, 0.01)
+			if _, err := f.ReadFrom(bytes.NewReader(test.Input)); err != ErrBadFilter {
+				t.Fatalf("Expected %v, got %v", ErrBadFilter, err)
+			}
+			if f.buckets != NewCuckooFilter(10, 0.01).buckets {
+				t.Fatalf("Filter changed by malformed input")
+			}
+		})
+	}
+}
+
+func BenchmarkCuckooFilterContains(b *testing.B) {
+	digests, err := createDigests(10000)
+	if err != nil {
+		b.Fatal(err)
+	}
+	f := NewCuckooFilter(uint64(len(digests)), 0.01)
+	for _, d := range digests {
+		if err := f.Insert(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Contains(digests[i%len(digests)])
+	}
+}
@@ -0,0 +1,435 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+// ErrCorruptJournal is returned by Open when a journal record other than the
+// last one is malformed or fails checksum verification.
+var ErrCorruptJournal = errors.New("corrupt digest set journal")
+
+// DefaultCompactThreshold is the number of journal records after which a
+// PersistentSet is compacted unless configured otherwise.
+const DefaultCompactThreshold = 10000
+
+const (
+	journalSuffix  = ".journal"
+	snapshotSuffix = ".tmp"
+
+	opAdd    byte = '+'
+	opRemove byte = '-'
+)
+
+// PersistentSet is a Set that is persisted to disk as a snapshot written by
+// Set.WriteTo plus an append-only journal of the Add and Remove operations
+// since the snapshot was taken. Each journal record carries a CRC-32C
+// checksum. Once the journal holds a configurable number of records the set
+// is compacted into a new snapshot and the journal is truncated.
+//
+// Journal records are written to the file as they happen, but not synced to
+// stable storage. Call Sync where durability is required.
+//
+// A record that cannot be written completely is removed from the journal
+// again. Should that fail too, the set refuses further modifications, as
+// the journal would no longer replay. A failed automatic compaction does
+// not fail the operation that triggered it, it is reported by the next
+// call to Sync or Compact instead.
+type PersistentSet struct {
+	mutex      sync.Mutex
+	set        *Set
+	path       string
+	journal    journalFile
+	records    int
+	threshold  int
+	broken     error
+	compactErr error
+}
+
+// journalFile is the file a journal is written to.
+type journalFile interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// Option configures a PersistentSet.
+type Option func(*PersistentSet)
+
+// WithCompactThreshold sets the number of journal records after which the set
+// is compacted. A threshold of zero or less disables automatic compaction.
+func WithCompactThreshold(records int) Option {
+	return func(ps *PersistentSet) {
+		ps.threshold = records
+	}
+}
+
+// Open opens the persistent set stored at path, creating it if it does not
+// exist. The snapshot at path is read and the journal next to it is
+// replayed. A torn final journal record, as left behind by a crash during a
+// write, is discarded.
+func Open(path string, opts ...Option) (*PersistentSet, error) {
+	ps := &PersistentSet{
+		set:       NewSet(),
+		path:      path,
+		threshold: DefaultCompactThreshold,
+	}
+	for _, opt := range opts {
+		opt(ps)
+	}
+
+	f, err := os.Open(path)
+	if err == nil {
+		_, err = ps.set.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read snapshot %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	journal, err := os.OpenFile(path+journalSuffix, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	records, valid, err := replayJournal(journal, ps.set)
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("cannot replay journal %s: %w", journal.Name(), err)
+	}
+	// Drop a torn final record, if any, so that new records are appended
+	// after the last good one.
+	if err := journal.Truncate(valid); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	if _, err := journal.Seek(valid, io.SeekStart); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	ps.journal = journal
+	ps.records = records
+
+	if ps.threshold > 0 && ps.records >= ps.threshold {
+		if err := ps.compact(); err != nil {
+			journal.Close()
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+// Set returns the underlying set for lookups. Modifications must go through
+// the PersistentSet, otherwise they are not persisted.
+func (ps *PersistentSet) Set() *Set {
+	return ps.set
+}
+
+// Add adds the given digest to the set and records the operation in the
+// journal.
+func (ps *PersistentSet) Add(d digest.Digest) error {
+	return ps.apply(opAdd, d)
+}
+
+// Remove removes the given digest from the set and records the operation in
+// the journal.
+func (ps *PersistentSet) Remove(d digest.Digest) error {
+	return ps.apply(opRemove, d)
+}
+
+func (ps *PersistentSet) apply(op byte, d digest.Digest) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if ps.broken != nil {
+		return ps.broken
+	}
+
+	// The record is written before the set is modified, so the set never
+	// holds state the journal does not know about.
+	offset, err := ps.journal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := ps.journal.Write(appendRecord(nil, op, d)); err != nil {
+		// Remove what was written of the record, as a good record
+		// following it would make the journal corrupt.
+		if terr := ps.rewind(offset); terr != nil {
+			ps.broken = fmt.Errorf("cannot remove incomplete journal record: %w", terr)
+		}
+		return err
+	}
+	ps.records++
+
+	if op == opAdd {
+		err = ps.set.Add(d)
+	} else {
+		err = ps.set.Remove(d)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The operation is done at this point, so a failed compaction is not
+	// retried until the next Sync or Compact reports it.
+	if ps.compactErr == nil && ps.threshold > 0 && ps.records >= ps.threshold {
+		ps.compactErr = ps.compact()
+	}
+	return nil
+}
+
+// rewind truncates the journal to offset and continues writing there.
+func (ps *PersistentSet) rewind(offset int64) error {
+	if err := ps.journal.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := ps.journal.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Compact writes a new snapshot of the set and truncates the journal.
+func (ps *PersistentSet) Compact() error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if ps.broken != nil {
+		return ps.broken
+	}
+	ps.compactErr = ps.compact()
+	return ps.compactErr
+}
+
+func (ps *PersistentSet) compact() error {
+	tmp := ps.path + snapshotSuffix
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := ps.set.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, ps.path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(ps.path))
+
+	// Should we crash before the journal is truncated, replaying it on top
+	// of the new snapshot yields the same set, as the last operation on each
+	// digest wins.
+	if err := ps.journal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := ps.journal.Seek(0, io.SeekStart); err != nil {
+		// Records written after the end of the truncated journal would
+		// follow a gap, which does not replay.
+		ps.broken = fmt.Errorf("cannot rewind truncated journal: %w", err)
+		return err
+	}
+	ps.records = 0
+	return nil
+}
+
+// Sync commits the journal to stable storage. It retries a failed automatic
+// compaction and returns its error.
+func (ps *PersistentSet) Sync() error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if err := ps.journal.Sync(); err != nil {
+		return err
+	}
+	if ps.broken != nil {
+		return ps.broken
+	}
+	if ps.compactErr != nil {
+		ps.compactErr = ps.compact()
+	}
+	return ps.compactErr
+}
+
+// Close closes the journal. The set must not be modified afterwards.
+func (ps *PersistentSet) Close() error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	return ps.journal.Close()
+}
+
+// syncDir makes a rename in dir durable. Errors are ignored, as not all
+// platforms support syncing directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// appendRecord appends a journal record for op on d to buf. A record is the
+// operation, the length prefixed digest and a CRC-32C checksum of both.
+func appendRecord(buf []byte, op byte, d digest.Digest) []byte {
+	start := len(buf)
+	buf = append(buf, op)
+	var l [binary.MaxVarintLen64]byte
+	buf = append(buf, l[:binary.PutUvarint(l[:], uint64(len(d)))]...)
+	buf = append(buf, d...)
+	var sum [crc32.Size]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(buf[start:], crcTable))
+	return append(buf, sum[:]...)
+}
+
+// replayJournal applies the records in f to dst. It returns the number of
+// records applied and the offset after the last good record. A final record
+// that is incomplete or fails verification is considered torn and ignored,
+// along with any data after it, such as the zeros left behind when a crash
+// extended the file without writing the record. A bad record followed by a
+// good one results in ErrCorruptJournal.
+func replayJournal(f *os.File, dst *Set) (records int, valid int64, err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := fi.Size()
+
+	br := bufio.NewReader(f)
+	for {
+		rec, err := readRecord(br)
+		switch {
+		case err == io.EOF:
+			return records, valid, nil
+		case err == io.ErrUnexpectedEOF || err == errBadRecord:
+			follows, err := recordFollows(f, valid+1, size)
+			if err != nil {
+				return records, valid, err
+			}
+			if follows {
+				return records, valid, ErrCorruptJournal
+			}
+			return records, valid, nil
+		case err != nil:
+			return records, valid, err
+		}
+
+		if rec.op == opAdd {
+			err = dst.Add(rec.digest)
+		} else {
+			err = dst.Remove(rec.digest)
+		}
+		if err != nil {
+			return records, valid, err
+		}
+		records++
+		valid += int64(rec.size)
+	}
+}
+
+// recordFollows returns true if a good record starts anywhere in f between
+// the offsets start and end.
+func recordFollows(f *os.File, start, end int64) (bool, error) {
+	if start >= end {
+		return false, nil
+	}
+	tail, err := ioutil.ReadAll(io.NewSectionReader(f, start, end-start))
+	if err != nil {
+		return false, err
+	}
+	for i := range tail {
+		if tail[i] != opAdd && tail[i] != opRemove {
+			continue
+		}
+		if _, err := readRecord(bufio.NewReader(bytes.NewReader(tail[i:]))); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+var errBadRecord = errors.New("bad journal record")
+
+type record struct {
+	op     byte
+	digest digest.Digest
+	size   int
+}
+
+// readRecord reads the next journal record. io.EOF is returned if there are
+// no more records, io.ErrUnexpectedEOF if the record is incomplete and
+// errBadRecord if it is malformed.
+func readRecord(br *bufio.Reader) (record, error) {
+	var rec record
+	op, err := br.ReadByte()
+	if err != nil {
+		return rec, err
+	}
+	rec.size = 1
+	if op != opAdd && op != opRemove {
+		return rec, errBadRecord
+	}
+	l, err := binary.ReadUvarint(br)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return rec, io.ErrUnexpectedEOF
+	} else if err != nil || l > maxDigestLen {
+		return rec, errBadRecord
+	}
+	var lbuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lbuf[:], l)
+	rec.size += n + int(l) + crc32.Size
+
+	p := make([]byte, 1+n+int(l)+crc32.Size)
+	p[0] = op
+	copy(p[1:], lbuf[:n])
+	if _, err := io.ReadFull(br, p[1+n:]); err != nil {
+		return rec, unexpected(err)
+	}
+	body, sum := p[:len(p)-crc32.Size], p[len(p)-crc32.Size:]
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(sum) {
+		return rec, errBadRecord
+	}
+	rec.op = op
+	rec.digest = digest.Digest(body[1+n:])
+	if err := rec.digest.Validate(); err != nil {
+		return rec, errBadRecord
+	}
+	return rec, nil
+}
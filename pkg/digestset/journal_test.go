@@ -0,0 +1,375 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+func assertSetContents(t *testing.T, dset *Set, expected []digest.Digest) {
+	all := dset.All()
+	if len(all) != len(expected) {
+		t.Fatalf("Unexpected number of digests:\n\tExpected: %d\n\tActual: %d", len(expected), len(all))
+	}
+	for _, d := range expected {
+		if _, err := dset.Lookup(d.String()); err != nil {
+			t.Fatalf("Missing digest %s: %v", d, err)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	digests, err := createDigests(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dset := NewSet()
+	for i := range digests {
+		if err := dset.Add(digests[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := dset.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("Unexpected number of bytes written: %d != %d", n, buf.Len())
+	}
+	snapshot := buf.Bytes()
+
+	restored := NewSet()
+	if _, err := restored.ReadFrom(bytes.NewReader(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+	assertSetContents(t, restored, digests)
+
+	// A snapshot embedded in a stream leaves the data following it.
+	stream := bytes.NewReader(append(append([]byte(nil), snapshot...), "trailer"...))
+	if n, err := NewSet().ReadFrom(stream); err != nil || n != int64(len(snapshot)) {
+		t.Fatalf("Unexpected read of embedded snapshot: %d bytes, %v", n, err)
+	}
+	if rest, err := ioutil.ReadAll(stream); err != nil || string(rest) != "trailer" {
+		t.Fatalf("Unexpected data after snapshot: %q, %v", rest, err)
+	}
+
+	corrupt := append([]byte(nil), snapshot...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	if _, err := NewSet().ReadFrom(bytes.NewReader(corrupt)); err == nil {
+		t.Fatal("Expected error reading corrupt snapshot")
+	}
+	if _, err := NewSet().ReadFrom(bytes.NewReader(snapshot[:len(snapshot)-1])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected %v reading truncated snapshot, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestPersistentSet(t *testing.T) {
+	digests, err := createDigests(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "digests")
+
+	ps, err := Open(path, WithCompactThreshold(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range digests {
+		if err := ps.Add(digests[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if err := ps.Remove(digests[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything lives in the journal until the set is compacted.
+	ps, err = Open(path, WithCompactThreshold(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSetContents(t, ps.Set(), digests[5:])
+	if err := ps.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Remove(digests[5]); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err = Open(path, WithCompactThreshold(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSetContents(t, ps.Set(), digests[6:])
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPersistentSetTornRecord(t *testing.T) {
+	digests, err := createDigests(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "digests")
+
+	ps, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := ps.Add(digests[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	journal, err := os.ReadFile(path + journalSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash halfway through writing the third record.
+	record := appendRecord(nil, opAdd, digests[2])
+	torn := append(append([]byte(nil), journal...), record[:len(record)/2]...)
+	if err := os.WriteFile(path+journalSuffix, torn, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ps, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSetContents(t, ps.Set(), digests[:2])
+
+	// New records must follow the last good record.
+	if err := ps.Add(digests[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ps, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSetContents(t, ps.Set(), digests)
+	ps.Close()
+
+	// A bad checksum on the final record is tolerated as well.
+	bad := append(append([]byte(nil), journal...), record...)
+	bad[len(bad)-1] ^= 0xff
+	if err := os.WriteFile(path+journalSuffix, bad, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ps, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSetContents(t, ps.Set(), digests[:2])
+	ps.Close()
+
+	// A bad record followed by good ones is corruption.
+	corrupt := append([]byte(nil), journal...)
+	corrupt[len(record)/2] ^= 0xff
+	if err := os.WriteFile(path+journalSuffix, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Fatal("Expected error opening set with corrupt journal")
+	}
+
+	// Zeros after the last good record, as left behind by a crash after the
+	// file was extended but before the record was written, are torn too.
+	zeroed := append(append([]byte(nil), journal...), make([]byte, 80)...)
+	if err := os.WriteFile(path+journalSuffix, zeroed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ps, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSetContents(t, ps.Set(), digests[:2])
+	ps.Close()
+	fi, err := os.Stat(path + journalSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != int64(len(journal)) {
+		t.Fatalf("Expected journal truncated to %d bytes, got %d", len(journal), fi.Size())
+	}
+
+	// Garbage followed by a good record is still corruption.
+	garbage := append(append([]byte(nil), journal...), make([]byte, 80)...)
+	garbage = append(garbage, record...)
+	if err := os.WriteFile(path+journalSuffix, garbage, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Fatal("Expected error opening set with corrupt journal")
+	}
+}
+
+func TestPersistentSetCompaction(t *testing.T) {
+	digests, err := createDigests(25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "digests")
+
+	ps, err := Open(path, WithCompactThreshold(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range digests {
+		if err := ps.Add(digests[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path + journalSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := int64(5 * len(appendRecord(nil, opAdd, digests[0]))); fi.Size() != expected {
+		t.Fatalf("Unexpected journal size after compaction: %d != %d", fi.Size(), expected)
+	}
+
+	ps, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSetContents(t, ps.Set(), digests)
+	ps.Close()
+}
+
+// shortJournal writes only half of the next record and fails.
+type shortJournal struct {
+	journalFile
+	fail bool
+}
+
+func (j *shortJournal) Write(p []byte) (int, error) {
+	if j.fail {
+		j.fail = false
+		n, _ := j.journalFile.Write(p[:len(p)/2])
+		return n, io.ErrShortWrite
+	}
+	return j.journalFile.Write(p)
+}
+
+func TestPersistentSetShortWrite(t *testing.T) {
+	digests, err := createDigests(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "digests")
+
+	ps, err := Open(path, WithCompactThreshold(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Add(digests[0]); err != nil {
+		t.Fatal(err)
+	}
+	journal := &shortJournal{journalFile: ps.journal, fail: true}
+	ps.journal = journal
+	if err := ps.Add(digests[1]); err != io.ErrShortWrite {
+		t.Fatalf("Expected short write, got %v", err)
+	}
+	if err := ps.Add(digests[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The partial record must not keep the set from opening.
+	ps, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSetContents(t, ps.Set(), []digest.Digest{digests[0], digests[2]})
+	ps.Close()
+}
+
+func TestPersistentSetCompactionFailure(t *testing.T) {
+	digests, err := createDigests(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "digests")
+
+	ps, err := Open(path, WithCompactThreshold(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	// The snapshot cannot be written while a directory is in the way.
+	if err := os.Mkdir(path+snapshotSuffix, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range digests {
+		if err := ps.Add(d); err != nil {
+			t.Fatalf("Add failed because of compaction: %v", err)
+		}
+	}
+	assertSetContents(t, ps.Set(), digests)
+	if err := ps.Sync(); err == nil {
+		t.Fatal("Expected Sync to report the failed compaction")
+	}
+
+	if err := os.Remove(path + snapshotSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path + journalSuffix); err != nil || fi.Size() != 0 {
+		t.Fatalf("Expected compacted journal: %v, %v", fi, err)
+	}
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSetContents(t, reopened.Set(), digests)
+	reopened.Close()
+}
@@ -0,0 +1,240 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sort"
+	"sync"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+// RefSet tracks content digests together with the number of references held
+// on each and a set of named roots, such as engines or manifests, pointing
+// at digests. Collect determines which digests are no longer reachable and
+// may therefore have their content deleted.
+type RefSet struct {
+	mutex sync.Mutex
+	refs  map[digest.Digest]int
+	roots map[string][]digest.Digest
+
+	// collecting is set while Collect runs. Digests which are added, gain a
+	// reference or become a root during that time are recorded in touched,
+	// so that the collection does not sweep them.
+	collecting bool
+	touched    map[digest.Digest]struct{}
+
+	// collectMutex serializes collections.
+	collectMutex sync.Mutex
+}
+
+// NewRefSet creates an empty reference counted set.
+func NewRefSet() *RefSet {
+	return &RefSet{
+		refs:  map[digest.Digest]int{},
+		roots: map[string][]digest.Digest{},
+	}
+}
+
+// Add adds the given digest to the set without a reference. If the digest
+// already exists in the set, this operation will be a no-op.
+func (rs *RefSet) Add(d digest.Digest) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if _, ok := rs.refs[d]; !ok {
+		rs.refs[d] = 0
+	}
+	rs.touch(d)
+	return nil
+}
+
+// Ref adds a reference to the given digest, adding it to the set if
+// necessary.
+func (rs *RefSet) Ref(d digest.Digest) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.refs[d]++
+	rs.touch(d)
+	return nil
+}
+
+// Unref drops a reference to the given digest. ErrDigestNotFound is returned
+// if the digest is not in the set. The digest remains in the set until it is
+// collected.
+func (rs *RefSet) Unref(d digest.Digest) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	n, ok := rs.refs[d]
+	if !ok {
+		return ErrDigestNotFound
+	}
+	if n > 0 {
+		rs.refs[d] = n - 1
+	}
+	return nil
+}
+
+// Refs returns the number of references held on the given digest.
+func (rs *RefSet) Refs(d digest.Digest) int {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.refs[d]
+}
+
+// Len returns the number of digests in the set.
+func (rs *RefSet) Len() int {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return len(rs.refs)
+}
+
+// SetRoot points the named root at the given digests, replacing any digests
+// it pointed at before. The digests are added to the set if necessary.
+// Setting a root without digests removes it.
+func (rs *RefSet) SetRoot(name string, ds ...digest.Digest) error {
+	for _, d := range ds {
+		if err := d.Validate(); err != nil {
+			return err
+		}
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if len(ds) == 0 {
+		delete(rs.roots, name)
+		return nil
+	}
+	rs.roots[name] = append([]digest.Digest(nil), ds...)
+	for _, d := range ds {
+		if _, ok := rs.refs[d]; !ok {
+			rs.refs[d] = 0
+		}
+		rs.touch(d)
+	}
+	return nil
+}
+
+// RemoveRoot removes the named root.
+func (rs *RefSet) RemoveRoot(name string) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	delete(rs.roots, name)
+}
+
+// Roots returns the names of all roots in lexical order.
+func (rs *RefSet) Roots() []string {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	names := make([]string, 0, len(rs.roots))
+	for name := range rs.roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// touch records a digest which was added, referenced or made a root during
+// a collection. The caller must hold the mutex.
+func (rs *RefSet) touch(d digest.Digest) {
+	if rs.collecting {
+		rs.touched[d] = struct{}{}
+	}
+}
+
+// Collect performs a mark and sweep collection of the set. Starting from the
+// given roots, the named roots and all digests holding a reference, every
+// digest reachable through edges is marked. The digests of the set which
+// were not marked are removed from the set and returned.
+//
+// The edges function, which may for example read a manifest to find the
+// digests it refers to, is called without holding the lock of the set, so
+// references may change while the collection runs. Digests that are added,
+// gain a reference or become a root meanwhile are marked, together with
+// everything reachable from them, before sweeping.
+func (rs *RefSet) Collect(roots []digest.Digest, edges func(digest.Digest) []digest.Digest) []digest.Digest {
+	rs.collectMutex.Lock()
+	defer rs.collectMutex.Unlock()
+
+	rs.mutex.Lock()
+	rs.collecting = true
+	rs.touched = map[digest.Digest]struct{}{}
+	pending := append([]digest.Digest(nil), roots...)
+	for _, ds := range rs.roots {
+		pending = append(pending, ds...)
+	}
+	for d, n := range rs.refs {
+		if n > 0 {
+			pending = append(pending, d)
+		}
+	}
+	rs.mutex.Unlock()
+
+	marked := map[digest.Digest]struct{}{}
+	mark := func(pending []digest.Digest) {
+		for len(pending) > 0 {
+			d := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			if _, ok := marked[d]; ok {
+				continue
+			}
+			marked[d] = struct{}{}
+			if edges != nil {
+				pending = append(pending, edges(d)...)
+			}
+		}
+	}
+	mark(pending)
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	for {
+		pending = pending[:0]
+		for d := range rs.touched {
+			if _, ok := marked[d]; !ok {
+				pending = append(pending, d)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		rs.touched = map[digest.Digest]struct{}{}
+		rs.mutex.Unlock()
+		mark(pending)
+		rs.mutex.Lock()
+	}
+	rs.collecting = false
+	rs.touched = nil
+
+	var unreferenced []digest.Digest
+	for d := range rs.refs {
+		if _, ok := marked[d]; !ok {
+			unreferenced = append(unreferenced, d)
+			delete(rs.refs, d)
+		}
+	}
+	sort.Slice(unreferenced, func(i, j int) bool { return unreferenced[i] < unreferenced[j] })
+	return unreferenced
+}
@@ -0,0 +1,153 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sync"
+	"testing"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+func assertCollected(t *testing.T, actual, expected []digest.Digest) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("Unexpected collected digests:\n\tExpected: %v\n\tActual: %v", expected, actual)
+	}
+	want := map[digest.Digest]struct{}{}
+	for _, d := range expected {
+		want[d] = struct{}{}
+	}
+	for _, d := range actual {
+		if _, ok := want[d]; !ok {
+			t.Fatalf("Unexpected collected digest %s", d)
+		}
+	}
+}
+
+func TestRefSetCollect(t *testing.T) {
+	digests, err := createDigests(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// digests[0] is a manifest referring to digests[1] and digests[2],
+	// digests[2] in turn refers to digests[3].
+	graph := map[digest.Digest][]digest.Digest{
+		digests[0]: {digests[1], digests[2]},
+		digests[2]: {digests[3]},
+		digests[5]: {digests[6]},
+	}
+	edges := func(d digest.Digest) []digest.Digest { return graph[d] }
+
+	rs := NewRefSet()
+	for _, d := range digests {
+		if err := rs.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.SetRoot("engine/build-1", digests[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Ref(digests[5]); err != nil {
+		t.Fatal(err)
+	}
+
+	assertCollected(t, rs.Collect([]digest.Digest{digests[7]}, edges), []digest.Digest{digests[4]})
+	if rs.Len() != 7 {
+		t.Fatalf("Unexpected length after collection: %d", rs.Len())
+	}
+
+	rs.RemoveRoot("engine/build-1")
+	if err := rs.Unref(digests[5]); err != nil {
+		t.Fatal(err)
+	}
+	assertCollected(t, rs.Collect(nil, edges), append(digests[:4:4], digests[5:]...))
+	if rs.Len() != 0 {
+		t.Fatalf("Unexpected length after collection: %d", rs.Len())
+	}
+	if err := rs.Unref(digests[0]); err != ErrDigestNotFound {
+		t.Fatalf("Expected %v, got %v", ErrDigestNotFound, err)
+	}
+}
+
+func TestRefSetCollectConcurrentRef(t *testing.T) {
+	digests, err := createDigests(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := NewRefSet()
+	for _, d := range digests {
+		if err := rs.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reference digests[1] while the collection is marking. As digests[1]
+	// refers to digests[2], both must survive.
+	var once sync.Once
+	edges := func(d digest.Digest) []digest.Digest {
+		once.Do(func() {
+			if err := rs.Ref(digests[1]); err != nil {
+				t.Error(err)
+			}
+		})
+		if d == digests[1] {
+			return []digest.Digest{digests[2]}
+		}
+		return nil
+	}
+	assertCollected(t, rs.Collect([]digest.Digest{digests[0]}, edges), []digest.Digest{digests[3]})
+}
+
+func TestRefSetConcurrent(t *testing.T) {
+	digests, err := createDigests(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := NewRefSet()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j, d := range digests {
+				if j%4 == i {
+					rs.Ref(d)
+				}
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			if collected := rs.Collect(nil, nil); len(collected) != 0 {
+				t.Errorf("Referenced digests collected: %v", collected)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if rs.Len() != len(digests) {
+		t.Fatalf("Unexpected length: %d", rs.Len())
+	}
+}
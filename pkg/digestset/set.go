@@ -22,7 +22,6 @@ package digestset
 
 import (
 	"errors"
-	"sort"
 	"strings"
 	"sync"
 
@@ -49,17 +48,20 @@ var (
 // is important to always do short representation lookups on
 // the complete set of digests. To mitigate collisions, an
 // appropriately long short code should be used.
+//
+// Digests are held in a compressed radix trie keyed on their encoded
+// value, so adding, removing and looking up a digest is proportional to
+// the length of the digest rather than the size of the set.
 type Set struct {
-	mutex   sync.RWMutex
-	entries digestEntries
+	mutex    sync.RWMutex
+	trie     trie
+	watchers []*watcher
 }
 
 // NewSet creates an empty set of digests
 // which may have digests added.
 func NewSet() *Set {
-	return &Set{
-		entries: digestEntries{},
-	}
+	return &Set{}
 }
 
 // checkShortMatch checks whether two digests match as either whole
@@ -89,42 +91,104 @@ func checkShortMatch(alg digest.Algorithm, hex, shortAlg, shortHex string) bool
 func (dst *Set) Lookup(d string) (digest.Digest, error) {
 	dst.mutex.RLock()
 	defer dst.mutex.RUnlock()
-	if len(dst.entries) == 0 {
+	if dst.trie.size == 0 {
 		return "", ErrDigestNotFound
 	}
-	var (
-		searchFunc func(int) bool
-		alg        digest.Algorithm
-		hex        string
-	)
+	alg, hex := parseLookup(d)
+	// Only the first two entries ordered at or after the search value need
+	// to be considered, the first to find a match and the second to detect
+	// ambiguity.
+	var candidates lookupCandidates
+	dst.trie.seek(hex, alg, candidates.add)
+	return candidates.resolve(alg, hex)
+}
+
+// parseLookup splits the string representation passed to Lookup into an
+// optional algorithm and an encoded value or prefix.
+func parseLookup(d string) (digest.Algorithm, string) {
 	dgst, err := digest.Parse(d)
 	if err == digest.ErrDigestInvalidFormat {
-		hex = d
-		searchFunc = func(i int) bool {
-			return dst.entries[i].val >= d
-		}
-	} else {
-		hex = dgst.Hex()
-		alg = dgst.Algorithm()
-		searchFunc = func(i int) bool {
-			if dst.entries[i].val == hex {
-				return dst.entries[i].alg >= alg
-			}
-			return dst.entries[i].val >= hex
-		}
+		return "", d
 	}
-	idx := sort.Search(len(dst.entries), searchFunc)
-	if idx == len(dst.entries) || !checkShortMatch(dst.entries[idx].alg, dst.entries[idx].val, string(alg), hex) {
+	return dgst.Algorithm(), dgst.Hex()
+}
+
+// lookupCandidates collects the first two entries ordered at or after a
+// search value.
+type lookupCandidates struct {
+	entries [2]*digestEntry
+	found   int
+}
+
+// add records e as a candidate and reports whether more are needed.
+func (c *lookupCandidates) add(e *digestEntry) bool {
+	c.entries[c.found] = e
+	c.found++
+	return c.found < len(c.entries)
+}
+
+// resolve picks the digest matching alg and hex from the candidates.
+func (c *lookupCandidates) resolve(alg digest.Algorithm, hex string) (digest.Digest, error) {
+	first, second := c.entries[0], c.entries[1]
+	if c.found == 0 || !checkShortMatch(first.alg, first.val, string(alg), hex) {
 		return "", ErrDigestNotFound
 	}
-	if dst.entries[idx].alg == alg && dst.entries[idx].val == hex {
-		return dst.entries[idx].digest, nil
+	if first.alg == alg && first.val == hex {
+		return first.digest, nil
 	}
-	if idx+1 < len(dst.entries) && checkShortMatch(dst.entries[idx+1].alg, dst.entries[idx+1].val, string(alg), hex) {
+	if c.found > 1 && checkShortMatch(second.alg, second.val, string(alg), hex) {
 		return "", ErrDigestAmbiguous
 	}
 
-	return dst.entries[idx].digest, nil
+	return first.digest, nil
+}
+
+// LookupInAlgorithm looks for a digest of the given algorithm whose encoded
+// value starts with short. The short code may be prefixed with the
+// algorithm, as returned by AlgorithmShortCodeTable. Digests of other
+// algorithms never make a match ambiguous. If no digests could be found
+// ErrDigestNotFound will be returned with an empty digest value. If
+// multiple matches are found ErrDigestAmbiguous will be returned with an
+// empty digest value.
+func (dst *Set) LookupInAlgorithm(alg digest.Algorithm, short string) (digest.Digest, error) {
+	if i := strings.Index(short, ":"); i >= 0 {
+		if digest.Algorithm(short[:i]) != alg {
+			return "", ErrDigestNotFound
+		}
+		short = short[i+1:]
+	}
+	dst.mutex.RLock()
+	defer dst.mutex.RUnlock()
+
+	var match *digestEntry
+	ambiguous := false
+	dst.trie.seek(short, "", func(e *digestEntry) bool {
+		if !strings.HasPrefix(e.val, short) {
+			return false
+		}
+		if e.alg != alg {
+			return true
+		}
+		if e.val == short {
+			// An exact match always wins, as with Lookup. It is ordered
+			// before all values it is a prefix of.
+			match = e
+			return false
+		}
+		if match != nil {
+			ambiguous = true
+			return false
+		}
+		match = e
+		return true
+	})
+	if match == nil {
+		return "", ErrDigestNotFound
+	}
+	if ambiguous {
+		return "", ErrDigestAmbiguous
+	}
+	return match.digest, nil
 }
 
 // Add adds the given digest to the set. An error will be returned
@@ -134,28 +198,17 @@ func (dst *Set) Add(d digest.Digest) error {
 	if err := d.Validate(); err != nil {
 		return err
 	}
+	dst.add(d)
+	return nil
+}
+
+// add adds a validated digest to the set.
+func (dst *Set) add(d digest.Digest) {
 	dst.mutex.Lock()
 	defer dst.mutex.Unlock()
-	entry := &digestEntry{alg: d.Algorithm(), val: d.Hex(), digest: d}
-	searchFunc := func(i int) bool {
-		if dst.entries[i].val == entry.val {
-			return dst.entries[i].alg >= entry.alg
-		}
-		return dst.entries[i].val >= entry.val
+	if dst.trie.insert(&digestEntry{alg: d.Algorithm(), val: d.Hex(), digest: d}) {
+		dst.notify(SetEventAdded, d)
 	}
-	idx := sort.Search(len(dst.entries), searchFunc)
-	if idx == len(dst.entries) {
-		dst.entries = append(dst.entries, entry)
-		return nil
-	} else if dst.entries[idx].digest == d {
-		return nil
-	}
-
-	entries := append(dst.entries, nil)
-	copy(entries[idx+1:], entries[idx:len(entries)-1])
-	entries[idx] = entry
-	dst.entries = entries
-	return nil
 }
 
 // Remove removes the given digest from the set. An err will be
@@ -165,39 +218,146 @@ func (dst *Set) Remove(d digest.Digest) error {
 	if err := d.Validate(); err != nil {
 		return err
 	}
+	dst.remove(d)
+	return nil
+}
+
+// remove removes a validated digest from the set.
+func (dst *Set) remove(d digest.Digest) {
 	dst.mutex.Lock()
 	defer dst.mutex.Unlock()
-	entry := &digestEntry{alg: d.Algorithm(), val: d.Hex(), digest: d}
-	searchFunc := func(i int) bool {
-		if dst.entries[i].val == entry.val {
-			return dst.entries[i].alg >= entry.alg
+	if dst.trie.delete(d.Hex(), d.Algorithm()) {
+		dst.notify(SetEventRemoved, d)
+	}
+}
+
+// All returns all the digests in the set
+func (dst *Set) All() []digest.Digest {
+	dst.mutex.RLock()
+	defer dst.mutex.RUnlock()
+	retValues := make([]digest.Digest, 0, dst.trie.size)
+	dst.trie.root.walk(func(e *digestEntry) bool {
+		retValues = append(retValues, e.digest)
+		return true
+	})
+
+	return retValues
+}
+
+// Len returns the number of digests in the set.
+func (dst *Set) Len() int {
+	dst.mutex.RLock()
+	defer dst.mutex.RUnlock()
+	return dst.trie.size
+}
+
+// Contains returns whether the set holds the given digest. Unlike Lookup,
+// only complete digests are matched.
+func (dst *Set) Contains(d digest.Digest) bool {
+	if d.Validate() != nil {
+		return false
+	}
+	dst.mutex.RLock()
+	defer dst.mutex.RUnlock()
+	return dst.trie.get(d.Hex(), d.Algorithm()) != nil
+}
+
+// Walk calls fn for each digest in the set, ordered by encoded value then
+// algorithm, until fn returns false. Unlike All, the digests are not copied
+// into a slice first. The set is locked for reading during the walk, so fn
+// must not modify it.
+func (dst *Set) Walk(fn func(digest.Digest) bool) {
+	dst.mutex.RLock()
+	defer dst.mutex.RUnlock()
+	dst.trie.root.walk(func(e *digestEntry) bool {
+		return fn(e.digest)
+	})
+}
+
+// FilterAlgorithm returns a new set holding the digests of the set which
+// use the given algorithm.
+func (dst *Set) FilterAlgorithm(alg digest.Algorithm) *Set {
+	res := NewSet()
+	for _, e := range dst.snapshot() {
+		if e.alg == alg {
+			res.trie.insert(e)
 		}
-		return dst.entries[i].val >= entry.val
 	}
-	idx := sort.Search(len(dst.entries), searchFunc)
-	// Not found if idx is after or value at idx is not digest
-	if idx == len(dst.entries) || dst.entries[idx].digest != d {
-		return nil
+	return res
+}
+
+// Union returns a new set holding the digests found in either set.
+func (dst *Set) Union(other *Set) *Set {
+	res := NewSet()
+	for _, e := range dst.snapshot() {
+		res.trie.insert(e)
+	}
+	for _, e := range other.snapshot() {
+		res.trie.insert(e)
 	}
+	return res
+}
 
-	entries := dst.entries
-	copy(entries[idx:], entries[idx+1:])
-	entries = entries[:len(entries)-1]
-	dst.entries = entries
+// Intersect returns a new set holding the digests found in both sets.
+func (dst *Set) Intersect(other *Set) *Set {
+	return dst.filter(other, true)
+}
 
-	return nil
+// Difference returns a new set holding the digests of the set which are not
+// found in other.
+func (dst *Set) Difference(other *Set) *Set {
+	return dst.filter(other, false)
 }
 
-// All returns all the digests in the set
-func (dst *Set) All() []digest.Digest {
+// Equal returns whether both sets hold the same digests.
+func (dst *Set) Equal(other *Set) bool {
+	entries := dst.snapshot()
+	other.mutex.RLock()
+	defer other.mutex.RUnlock()
+	if len(entries) != other.trie.size {
+		return false
+	}
+	for _, e := range entries {
+		if other.trie.get(e.val, e.alg) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns a new set holding the digests of the set which are, or
+// are not, found in other.
+func (dst *Set) filter(other *Set, found bool) *Set {
+	entries := dst.snapshot()
+	res := NewSet()
+	other.mutex.RLock()
+	defer other.mutex.RUnlock()
+	for _, e := range entries {
+		if (other.trie.get(e.val, e.alg) != nil) == found {
+			res.trie.insert(e)
+		}
+	}
+	return res
+}
+
+// snapshot returns the entries of the set. Operations on two sets take a
+// snapshot of one of them rather than locking both at once, which could
+// deadlock against writers waiting on either set.
+func (dst *Set) snapshot() digestEntries {
 	dst.mutex.RLock()
 	defer dst.mutex.RUnlock()
-	retValues := make([]digest.Digest, len(dst.entries))
-	for i := range dst.entries {
-		retValues[i] = dst.entries[i].digest
-	}
+	return dst.entries()
+}
 
-	return retValues
+// entries returns all entries in the set ordered by value, then algorithm.
+// The caller must hold the mutex.
+func (dst *Set) entries() digestEntries {
+	entries := make(digestEntries, 0, dst.trie.size)
+	dst.trie.root.walk(func(e *digestEntry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	return entries
 }
 
 // ShortCodeTable returns a map of Digest to unique short codes. The
@@ -208,20 +368,50 @@ func (dst *Set) All() []digest.Digest {
 func ShortCodeTable(dst *Set, length int) map[digest.Digest]string {
 	dst.mutex.RLock()
 	defer dst.mutex.RUnlock()
-	m := make(map[digest.Digest]string, len(dst.entries))
+	return dst.entries().shortCodes(length)
+}
+
+// AlgorithmShortCodeTable returns a map of Digest to short codes of the form
+// <algorithm>:<prefix>. Unlike ShortCodeTable, prefixes only need to be
+// unique among digests of the same algorithm, so a set mixing algorithms
+// gets codes that state which algorithm they belong to and are no longer
+// than necessary. The codes must be resolved with LookupInAlgorithm, as
+// Lookup considers digests of all algorithms.
+func AlgorithmShortCodeTable(dst *Set, length int) map[digest.Digest]string {
+	dst.mutex.RLock()
+	defer dst.mutex.RUnlock()
+
+	byAlg := map[digest.Algorithm]digestEntries{}
+	for _, e := range dst.entries() {
+		byAlg[e.alg] = append(byAlg[e.alg], e)
+	}
+	m := make(map[digest.Digest]string, dst.trie.size)
+	for alg, entries := range byAlg {
+		for d, short := range entries.shortCodes(length) {
+			if short != d.String() {
+				short = string(alg) + ":" + short
+			}
+			m[d] = short
+		}
+	}
+	return m
+}
+
+func (entries digestEntries) shortCodes(length int) map[digest.Digest]string {
+	m := make(map[digest.Digest]string, len(entries))
 	l := length
 	resetIdx := 0
-	for i := 0; i < len(dst.entries); i++ {
+	for i := 0; i < len(entries); i++ {
 		var short string
 		extended := true
 		for extended {
 			extended = false
-			if len(dst.entries[i].val) <= l {
-				short = dst.entries[i].digest.String()
+			if len(entries[i].val) <= l {
+				short = entries[i].digest.String()
 			} else {
-				short = dst.entries[i].val[:l]
-				for j := i + 1; j < len(dst.entries); j++ {
-					if checkShortMatch(dst.entries[j].alg, dst.entries[j].val, "", short) {
+				short = entries[i].val[:l]
+				for j := i + 1; j < len(entries); j++ {
+					if checkShortMatch(entries[j].alg, entries[j].val, "", short) {
 						if j > resetIdx {
 							resetIdx = j
 						}
@@ -235,7 +425,7 @@ func ShortCodeTable(dst *Set, length int) map[digest.Digest]string {
 				}
 			}
 		}
-		m[dst.entries[i].digest] = short
+		m[entries[i].digest] = short
 		if i >= resetIdx {
 			l = length
 		}
@@ -249,19 +439,5 @@ type digestEntry struct {
 	digest digest.Digest
 }
 
+// digestEntries is a list of entries ordered by value, then algorithm.
 type digestEntries []*digestEntry
-
-func (d digestEntries) Len() int {
-	return len(d)
-}
-
-func (d digestEntries) Less(i, j int) bool {
-	if d[i].val != d[j].val {
-		return d[i].val < d[j].val
-	}
-	return d[i].alg < d[j].alg
-}
-
-func (d digestEntries) Swap(i, j int) {
-	d[i], d[j] = d[j], d[i]
-}
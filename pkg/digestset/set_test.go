@@ -128,7 +128,7 @@ func TestAddDuplication(t *testing.T) {
 		}
 	}
 
-	if len(dset.entries) != 8 {
+	if dset.trie.size != 8 {
 		t.Fatal("Invalid dset size")
 	}
 
@@ -136,7 +136,7 @@ func TestAddDuplication(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if len(dset.entries) != 8 {
+	if dset.trie.size != 8 {
 		t.Fatal("Duplicate digest insert should not increase entries size")
 	}
 
@@ -144,7 +144,7 @@ func TestAddDuplication(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if len(dset.entries) != 9 {
+	if dset.trie.size != 9 {
 		t.Fatal("Insert with different algorithm should be allowed")
 	}
 }
@@ -269,7 +269,7 @@ func benchAddNTable(b *testing.B, n int) {
 	}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		dset := &Set{entries: digestEntries(make([]*digestEntry, 0, n))}
+		dset := NewSet()
 		for j := range digests {
 			if err = dset.Add(digests[j]); err != nil {
 				b.Fatal(err)
@@ -283,7 +283,7 @@ func benchLookupNTable(b *testing.B, n int, shortLen int) {
 	if err != nil {
 		b.Fatal(err)
 	}
-	dset := &Set{entries: digestEntries(make([]*digestEntry, 0, n))}
+	dset := NewSet()
 	for i := range digests {
 		if err := dset.Add(digests[i]); err != nil {
 			b.Fatal(err)
@@ -309,7 +309,7 @@ func benchRemoveNTable(b *testing.B, n int) {
 	}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		dset := &Set{entries: digestEntries(make([]*digestEntry, 0, n))}
+		dset := NewSet()
 		b.StopTimer()
 		for j := range digests {
 			if err = dset.Add(digests[j]); err != nil {
@@ -330,7 +330,7 @@ func benchShortCodeNTable(b *testing.B, n int, shortLen int) {
 	if err != nil {
 		b.Fatal(err)
 	}
-	dset := &Set{entries: digestEntries(make([]*digestEntry, 0, n))}
+	dset := NewSet()
 	for i := range digests {
 		if err := dset.Add(digests[i]); err != nil {
 			b.Fatal(err)
@@ -388,3 +388,171 @@ func BenchmarkShortCode100(b *testing.B) {
 func BenchmarkShortCode1000(b *testing.B) {
 	benchShortCodeNTable(b, 1000, 12)
 }
+
+func newSetOf(t *testing.T, digests ...digest.Digest) *Set {
+	dset := NewSet()
+	for i := range digests {
+		if err := dset.Add(digests[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dset
+}
+
+func TestLenContains(t *testing.T) {
+	digests, err := createDigests(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dset := newSetOf(t, digests[:5]...)
+	if dset.Len() != 5 {
+		t.Fatalf("Unexpected length:\n\tExpected: %d\n\tActual: %d", 5, dset.Len())
+	}
+	for i, d := range digests {
+		if dset.Contains(d) != (i < 5) {
+			t.Fatalf("Unexpected containment of %s", d)
+		}
+	}
+	if dset.Contains(digest.Digest(digests[0].Encoded()[:10])) {
+		t.Fatal("Short codes should not be contained")
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	digests, err := createDigests(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newSetOf(t, digests[:6]...)
+	b := newSetOf(t, digests[4:]...)
+
+	for _, testcase := range []struct {
+		Name     string
+		Actual   *Set
+		Expected []digest.Digest
+	}{
+		{Name: "Union", Actual: a.Union(b), Expected: digests},
+		{Name: "Intersect", Actual: a.Intersect(b), Expected: digests[4:6]},
+		{Name: "Difference", Actual: a.Difference(b), Expected: digests[:4]},
+		{Name: "SelfDifference", Actual: a.Difference(a), Expected: nil},
+	} {
+		t.Run(testcase.Name, func(t *testing.T) {
+			if !testcase.Actual.Equal(newSetOf(t, testcase.Expected...)) {
+				t.Fatalf("Unexpected result: %v", testcase.Actual.All())
+			}
+		})
+	}
+
+	if a.Equal(b) {
+		t.Fatal("Different sets should not be equal")
+	}
+	if !a.Equal(a) {
+		t.Fatal("Set should equal itself")
+	}
+}
+
+func TestWalkFilterAlgorithm(t *testing.T) {
+	digests := []digest.Digest{
+		"sha256:1234111111111111111111111111111111111111111111111111111111111111",
+		"sha512:65431111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111",
+		"sha256:5432111111111111111111111111111111111111111111111111111111111111",
+		"sha384:123451111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111",
+	}
+	dset := newSetOf(t, digests...)
+
+	var walked []digest.Digest
+	dset.Walk(func(d digest.Digest) bool {
+		walked = append(walked, d)
+		return true
+	})
+	all := dset.All()
+	if len(walked) != len(all) {
+		t.Fatalf("Unexpected number of digests walked: %d != %d", len(walked), len(all))
+	}
+	for i := range all {
+		assertEqualDigests(t, walked[i], all[i])
+	}
+
+	var count int
+	dset.Walk(func(d digest.Digest) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Fatalf("Walk did not stop: %d", count)
+	}
+
+	sha256s := dset.FilterAlgorithm(digest.SHA256)
+	if !sha256s.Equal(newSetOf(t, digests[0], digests[2])) {
+		t.Fatalf("Unexpected filtered digests: %v", sha256s.All())
+	}
+}
+
+func TestAlgorithmShortCodeTable(t *testing.T) {
+	digests := []digest.Digest{
+		"sha256:1234111111111111111111111111111111111111111111111111111111111111",
+		"sha256:1235111111111111111111111111111111111111111111111111111111111111",
+		"sha256:5432111111111111111111111111111111111111111111111111111111111111",
+		"sha512:12341111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111",
+		"sha512:65431111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111",
+	}
+	dset := newSetOf(t, digests...)
+
+	dump := AlgorithmShortCodeTable(dset, 2)
+	if len(dump) != len(digests) {
+		t.Fatalf("Error unexpected size: %d, expecting %d", len(dump), len(digests))
+	}
+	assertEqualShort(t, dump[digests[0]], "sha256:1234")
+	assertEqualShort(t, dump[digests[1]], "sha256:1235")
+	assertEqualShort(t, dump[digests[2]], "sha256:54")
+	assertEqualShort(t, dump[digests[3]], "sha512:12")
+	assertEqualShort(t, dump[digests[4]], "sha512:65")
+
+	for d, short := range dump {
+		dgst, err := dset.LookupInAlgorithm(d.Algorithm(), short)
+		if err != nil {
+			t.Fatalf("Unable to look up %s: %v", short, err)
+		}
+		assertEqualDigests(t, dgst, d)
+	}
+}
+
+func TestLookupInAlgorithm(t *testing.T) {
+	digests := []digest.Digest{
+		"sha256:1234111111111111111111111111111111111111111111111111111111111111",
+		"sha256:1235111111111111111111111111111111111111111111111111111111111111",
+		"sha512:12341111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111",
+	}
+	dset := newSetOf(t, digests...)
+
+	// Ambiguous across algorithms with Lookup, but not within sha512.
+	if _, err := dset.Lookup("1234"); err != ErrDigestAmbiguous {
+		t.Fatalf("Expected %v, got %v", ErrDigestAmbiguous, err)
+	}
+	dgst, err := dset.LookupInAlgorithm(digest.SHA512, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqualDigests(t, dgst, digests[2])
+
+	dgst, err = dset.LookupInAlgorithm(digest.SHA256, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqualDigests(t, dgst, digests[0])
+
+	if _, err := dset.LookupInAlgorithm(digest.SHA256, "123"); err != ErrDigestAmbiguous {
+		t.Fatalf("Expected %v, got %v", ErrDigestAmbiguous, err)
+	}
+	if _, err := dset.LookupInAlgorithm(digest.SHA384, "1234"); err != ErrDigestNotFound {
+		t.Fatalf("Expected %v, got %v", ErrDigestNotFound, err)
+	}
+	if _, err := dset.LookupInAlgorithm(digest.SHA256, "sha512:1234"); err != ErrDigestNotFound {
+		t.Fatalf("Expected %v, got %v", ErrDigestNotFound, err)
+	}
+	dgst, err = dset.LookupInAlgorithm(digest.SHA256, digests[1].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqualDigests(t, dgst, digests[1])
+}
@@ -0,0 +1,264 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"strings"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+// shardCount is the number of shards of a ShardedSet, one for each value of
+// the leading byte of a digest.
+const shardCount = 256
+
+// ShardedSet is a set of digests with the same lookup semantics as Set,
+// partitioned by the leading byte of the digest value. Writers only lock
+// the shard their digest falls into, so many goroutines can add digests
+// concurrently without contending on a single lock.
+//
+// Since the shards are ordered like the values they hold, lookups of short
+// values spanning several shards, and short code tables, give the same
+// results as they would on a Set holding the same digests. Lookups are not
+// atomic with respect to concurrent writes to other shards.
+type ShardedSet struct {
+	shards [shardCount]Set
+}
+
+// NewShardedSet creates an empty sharded set of digests.
+func NewShardedSet() *ShardedSet {
+	return &ShardedSet{}
+}
+
+// shardIndex returns the index of the shard holding values starting with
+// prefix, or of the first shard that may hold them if prefix is shorter
+// than a byte. Valid digests are always encoded as lowercase hex.
+func shardIndex(prefix string) int {
+	idx := 0
+	for i := 0; i < 2; i++ {
+		idx <<= 4
+		if i < len(prefix) {
+			idx |= unhex(prefix[i])
+		}
+	}
+	return idx
+}
+
+// shardSpan returns the number of shards holding values starting with
+// prefix.
+func shardSpan(prefix string) int {
+	switch len(prefix) {
+	case 0:
+		return shardCount
+	case 1:
+		return 16
+	}
+	return 1
+}
+
+func unhex(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c - 'a' + 10)
+	}
+	return 0
+}
+
+// isHex reports whether s only holds lowercase hex characters, which is
+// required for it to be a prefix of any digest value in the set.
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// shard returns the shard holding d.
+func (s *ShardedSet) shard(d digest.Digest) *Set {
+	return &s.shards[shardIndex(d.Encoded())]
+}
+
+// Add adds the given digest to the set. An error will be returned
+// if the given digest is invalid. If the digest already exists in the
+// set, this operation will be a no-op.
+func (s *ShardedSet) Add(d digest.Digest) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	s.shard(d).add(d)
+	return nil
+}
+
+// Remove removes the given digest from the set. An err will be
+// returned if the given digest is invalid. If the digest does
+// not exist in the set, this operation will be a no-op.
+func (s *ShardedSet) Remove(d digest.Digest) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	s.shard(d).remove(d)
+	return nil
+}
+
+// Contains reports whether the set holds the given digest.
+func (s *ShardedSet) Contains(d digest.Digest) bool {
+	if d.Validate() != nil {
+		return false
+	}
+	return s.shard(d).Contains(d)
+}
+
+// Len returns the number of digests in the set.
+func (s *ShardedSet) Len() int {
+	n := 0
+	for i := range s.shards {
+		n += s.shards[i].Len()
+	}
+	return n
+}
+
+// Lookup looks for a digest matching the given string representation,
+// as described by Set.Lookup.
+func (s *ShardedSet) Lookup(d string) (digest.Digest, error) {
+	alg, hex := parseLookup(d)
+	if !isHex(hex) {
+		return "", ErrDigestNotFound
+	}
+	start := shardIndex(hex)
+	if shardSpan(hex) == 1 {
+		return s.shards[start].Lookup(d)
+	}
+
+	// The value is too short to pick a shard. Gather candidates as Set
+	// does, carrying on into the following shards until enough are found.
+	var candidates lookupCandidates
+	more := true
+	for i := start; i < shardCount && more; i++ {
+		shard := &s.shards[i]
+		shard.mutex.RLock()
+		shard.trie.seek(hex, alg, func(e *digestEntry) bool {
+			more = candidates.add(e)
+			return more
+		})
+		shard.mutex.RUnlock()
+	}
+	return candidates.resolve(alg, hex)
+}
+
+// LookupInAlgorithm looks for a digest of the given algorithm whose encoded
+// value starts with short, as described by Set.LookupInAlgorithm.
+func (s *ShardedSet) LookupInAlgorithm(alg digest.Algorithm, short string) (digest.Digest, error) {
+	if i := strings.Index(short, ":"); i >= 0 {
+		if digest.Algorithm(short[:i]) != alg {
+			return "", ErrDigestNotFound
+		}
+		short = short[i+1:]
+	}
+	if !isHex(short) {
+		return "", ErrDigestNotFound
+	}
+	start, span := shardIndex(short), shardSpan(short)
+	if span == 1 {
+		return s.shards[start].LookupInAlgorithm(alg, short)
+	}
+
+	// A value shorter than a byte cannot be an exact match, so any match
+	// found in more than one shard is ambiguous.
+	var match digest.Digest
+	for i := start; i < start+span; i++ {
+		d, err := s.shards[i].LookupInAlgorithm(alg, short)
+		switch {
+		case err == ErrDigestNotFound:
+			continue
+		case err != nil:
+			return "", err
+		case match != "":
+			return "", ErrDigestAmbiguous
+		}
+		match = d
+	}
+	if match == "" {
+		return "", ErrDigestNotFound
+	}
+	return match, nil
+}
+
+// All returns all the digests in the set, ordered as by Set.All.
+func (s *ShardedSet) All() []digest.Digest {
+	entries := s.entries()
+	all := make([]digest.Digest, len(entries))
+	for i, e := range entries {
+		all[i] = e.digest
+	}
+	return all
+}
+
+// Walk calls fn for each digest in the set, in the order of All, until fn
+// returns false. Each shard is read locked while it is walked, so fn must
+// not modify the set.
+func (s *ShardedSet) Walk(fn func(digest.Digest) bool) {
+	for i := range s.shards {
+		more := true
+		s.shards[i].Walk(func(d digest.Digest) bool {
+			more = fn(d)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
+// ShortCodeTable returns a map of Digest to unique short codes, identical
+// to the one ShortCodeTable returns for a Set holding the same digests.
+func (s *ShardedSet) ShortCodeTable(length int) map[digest.Digest]string {
+	return s.entries().shortCodes(length)
+}
+
+// entries returns all entries in the set ordered by value, then algorithm.
+// All shards are read locked at once, in order, for a consistent view.
+func (s *ShardedSet) entries() digestEntries {
+	for i := range s.shards {
+		s.shards[i].mutex.RLock()
+	}
+	defer func() {
+		for i := range s.shards {
+			s.shards[i].mutex.RUnlock()
+		}
+	}()
+
+	n := 0
+	for i := range s.shards {
+		n += s.shards[i].trie.size
+	}
+	entries := make(digestEntries, 0, n)
+	for i := range s.shards {
+		s.shards[i].trie.root.walk(func(e *digestEntry) bool {
+			entries = append(entries, e)
+			return true
+		})
+	}
+	return entries
+}
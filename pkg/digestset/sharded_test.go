@@ -0,0 +1,153 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+func TestShardedSetMatchesSet(t *testing.T) {
+	r := rand.New(rand.NewSource(7213))
+	// Small sets leave most shards empty, so short lookups have to carry
+	// on across shard boundaries.
+	for _, n := range []int{3, 40, 500} {
+		digests := createMixedDigests(r, n)
+		dset := NewSet()
+		sset := NewShardedSet()
+		for _, d := range digests {
+			if err := dset.Add(d); err != nil {
+				t.Fatal(err)
+			}
+			if err := sset.Add(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, d := range digests[:n/3] {
+			dset.Remove(d)
+			sset.Remove(d)
+		}
+
+		if sset.Len() != dset.Len() {
+			t.Fatalf("unexpected number of digests: %d != %d", sset.Len(), dset.Len())
+		}
+		all, expectedAll := sset.All(), dset.All()
+		for i := range expectedAll {
+			if all[i] != expectedAll[i] {
+				t.Fatalf("unexpected digest at %d: %s != %s", i, all[i], expectedAll[i])
+			}
+		}
+
+		queries := []string{"", "a", "0", "f", "g", "A", "sha256:", "sha256:a", "sha512:0"}
+		for _, d := range digests {
+			for _, l := range []int{1, 2, 3, 5, len(d.Encoded())} {
+				queries = append(queries, d.Encoded()[:l], d.Algorithm().String()+":"+d.Encoded()[:l])
+			}
+		}
+		for _, q := range queries {
+			expected, expectedErr := dset.Lookup(q)
+			actual, err := sset.Lookup(q)
+			if actual != expected || err != expectedErr {
+				t.Fatalf("lookup of %q differs: got %q, %v expected %q, %v", q, actual, err, expected, expectedErr)
+			}
+			for _, alg := range []digest.Algorithm{digest.SHA256, digest.SHA384, digest.SHA512} {
+				expected, expectedErr := dset.LookupInAlgorithm(alg, q)
+				actual, err := sset.LookupInAlgorithm(alg, q)
+				if actual != expected || err != expectedErr {
+					t.Fatalf("lookup of %q in %s differs: got %q, %v expected %q, %v", q, alg, actual, err, expected, expectedErr)
+				}
+			}
+		}
+
+		for _, length := range []int{1, 2, 4, 12} {
+			expected := ShortCodeTable(dset, length)
+			actual := sset.ShortCodeTable(length)
+			if len(actual) != len(expected) {
+				t.Fatalf("unexpected short code table size: %d != %d", len(actual), len(expected))
+			}
+			for d, short := range expected {
+				if actual[d] != short {
+					t.Fatalf("short code for %s differs: %q != %q", d, actual[d], short)
+				}
+			}
+		}
+	}
+}
+
+func TestShardedSetConcurrent(t *testing.T) {
+	digests, err := createDigests(2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sset := NewShardedSet()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(digests); i += 8 {
+				if err := sset.Add(digests[i]); err != nil {
+					t.Error(err)
+					return
+				}
+				sset.Lookup(digests[i].Encoded()[:1])
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if sset.Len() != len(digests) {
+		t.Fatalf("unexpected number of digests: %d != %d", sset.Len(), len(digests))
+	}
+	for _, d := range digests {
+		if !sset.Contains(d) {
+			t.Fatalf("missing digest %s", d)
+		}
+	}
+}
+
+func benchParallelAdd(b *testing.B, add func(digest.Digest) error) {
+	digests, err := createDigests(1 << 12)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Int()
+		for pb.Next() {
+			if err := add(digests[i%len(digests)]); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkParallelAddSet(b *testing.B) {
+	benchParallelAdd(b, NewSet().Add)
+}
+
+func BenchmarkParallelAddShardedSet(b *testing.B) {
+	benchParallelAdd(b, NewShardedSet().Add)
+}
@@ -0,0 +1,211 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+// ShortCodes is a table of unique short codes, identical to the one
+// returned by ShortCodeTable for the same digests, which is updated
+// incrementally. ShortCodeTable assigns codes to runs of digests sharing
+// prefixes, and a change only recomputes the codes of the runs next to it.
+type ShortCodes struct {
+	mutex   sync.RWMutex
+	length  int
+	entries digestEntries
+	// starts records for each entry whether a run starts at it, that is
+	// whether the short code length was reset before it.
+	starts []bool
+	codes  map[digest.Digest]string
+}
+
+// NewShortCodes creates an empty table of short codes with the given
+// minimum length.
+func NewShortCodes(length int) *ShortCodes {
+	return &ShortCodes{length: length, codes: map[digest.Digest]string{}}
+}
+
+// WatchShortCodes returns a table of short codes for the set, kept up to
+// date by watching the set until ctx is done. Updates are applied
+// asynchronously, shortly after each change to the set.
+func WatchShortCodes(ctx context.Context, dst *Set, length int) *ShortCodes {
+	t := NewShortCodes(length)
+	dst.mutex.Lock()
+	events := dst.watch(ctx)
+	t.reset(dst.entries())
+	dst.mutex.Unlock()
+
+	go func() {
+		for ev := range events {
+			switch ev.Type {
+			case SetEventAdded:
+				t.Add(ev.Digest)
+			case SetEventRemoved:
+				t.Remove(ev.Digest)
+			case SetEventOverflow:
+				t.reset(dst.snapshot())
+			}
+		}
+	}()
+	return t
+}
+
+// Add adds a digest to the table. An error will be returned if the given
+// digest is invalid.
+func (t *ShortCodes) Add(d digest.Digest) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	e := &digestEntry{alg: d.Algorithm(), val: d.Hex(), digest: d}
+	i, found := t.search(e.val, e.alg)
+	if found {
+		return nil
+	}
+	t.entries = append(t.entries, nil)
+	copy(t.entries[i+1:], t.entries[i:])
+	t.entries[i] = e
+	t.starts = append(t.starts, false)
+	copy(t.starts[i+1:], t.starts[i:])
+	t.update(i, i+1)
+	return nil
+}
+
+// Remove removes a digest from the table. An error will be returned if the
+// given digest is invalid.
+func (t *ShortCodes) Remove(d digest.Digest) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	i, found := t.search(d.Hex(), d.Algorithm())
+	if !found {
+		return nil
+	}
+	t.entries = append(t.entries[:i], t.entries[i+1:]...)
+	t.starts = append(t.starts[:i], t.starts[i+1:]...)
+	delete(t.codes, d)
+	t.update(i, i)
+	return nil
+}
+
+// Code returns the short code of a digest in the table.
+func (t *ShortCodes) Code(d digest.Digest) (string, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	short, ok := t.codes[d]
+	return short, ok
+}
+
+// Table returns a copy of the table as a map of Digest to short code.
+func (t *ShortCodes) Table() map[digest.Digest]string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	m := make(map[digest.Digest]string, len(t.codes))
+	for d, short := range t.codes {
+		m[d] = short
+	}
+	return m
+}
+
+// reset replaces the contents of the table with the given entries, which
+// must be ordered by value, then algorithm.
+func (t *ShortCodes) reset(entries digestEntries) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.entries = entries
+	t.starts = make([]bool, len(entries))
+	t.codes = make(map[digest.Digest]string, len(entries))
+	t.update(0, len(entries))
+}
+
+// search returns the index of the entry with the given value and algorithm,
+// or the index it would be inserted at.
+func (t *ShortCodes) search(val string, alg digest.Algorithm) (int, bool) {
+	i := sort.Search(len(t.entries), func(i int) bool {
+		if t.entries[i].val == val {
+			return t.entries[i].alg >= alg
+		}
+		return t.entries[i].val >= val
+	})
+	return i, i < len(t.entries) && t.entries[i].val == val && t.entries[i].alg == alg
+}
+
+// update recomputes short codes after a change at index i. It runs the
+// algorithm of digestEntries.shortCodes from the start of the run before i
+// and stops at the first run starting at or after unchanged, the index of
+// the first entry not affected by the change, that also started a run
+// before. From there on the codes are the same as before the change.
+func (t *ShortCodes) update(i, unchanged int) {
+	start := i - 1
+	if start < 0 {
+		start = 0
+	}
+	for start > 0 && !t.starts[start] {
+		start--
+	}
+
+	entries := t.entries
+	l := t.length
+	resetIdx := start
+	for i := start; i < len(entries); i++ {
+		reset := i == start || i-1 >= resetIdx
+		if reset && i >= unchanged && i > start && t.starts[i] {
+			return
+		}
+		t.starts[i] = reset
+		if reset {
+			l = t.length
+		}
+
+		var short string
+		extended := true
+		for extended {
+			extended = false
+			if len(entries[i].val) <= l {
+				short = entries[i].digest.String()
+			} else {
+				short = entries[i].val[:l]
+				for j := i + 1; j < len(entries); j++ {
+					if checkShortMatch(entries[j].alg, entries[j].val, "", short) {
+						if j > resetIdx {
+							resetIdx = j
+						}
+						extended = true
+					} else {
+						break
+					}
+				}
+				if extended {
+					l++
+				}
+			}
+		}
+		t.codes[entries[i].digest] = short
+	}
+}
@@ -0,0 +1,305 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+// sliceSet is the sorted slice implementation the trie replaced. It is kept
+// to check that the trie preserves its behaviour and to compare performance.
+type sliceSet struct {
+	entries digestEntries
+}
+
+func (dst *sliceSet) search(alg digest.Algorithm, hex string) int {
+	return sort.Search(len(dst.entries), func(i int) bool {
+		if dst.entries[i].val == hex {
+			return dst.entries[i].alg >= alg
+		}
+		return dst.entries[i].val >= hex
+	})
+}
+
+func (dst *sliceSet) Lookup(d string) (digest.Digest, error) {
+	if len(dst.entries) == 0 {
+		return "", ErrDigestNotFound
+	}
+	var (
+		alg digest.Algorithm
+		hex string
+	)
+	dgst, err := digest.Parse(d)
+	if err == digest.ErrDigestInvalidFormat {
+		hex = d
+	} else {
+		hex = dgst.Hex()
+		alg = dgst.Algorithm()
+	}
+	idx := dst.search(alg, hex)
+	if idx == len(dst.entries) || !checkShortMatch(dst.entries[idx].alg, dst.entries[idx].val, string(alg), hex) {
+		return "", ErrDigestNotFound
+	}
+	if dst.entries[idx].alg == alg && dst.entries[idx].val == hex {
+		return dst.entries[idx].digest, nil
+	}
+	if idx+1 < len(dst.entries) && checkShortMatch(dst.entries[idx+1].alg, dst.entries[idx+1].val, string(alg), hex) {
+		return "", ErrDigestAmbiguous
+	}
+	return dst.entries[idx].digest, nil
+}
+
+func (dst *sliceSet) Add(d digest.Digest) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	entry := &digestEntry{alg: d.Algorithm(), val: d.Hex(), digest: d}
+	idx := dst.search(entry.alg, entry.val)
+	if idx == len(dst.entries) {
+		dst.entries = append(dst.entries, entry)
+		return nil
+	} else if dst.entries[idx].digest == d {
+		return nil
+	}
+	entries := append(dst.entries, nil)
+	copy(entries[idx+1:], entries[idx:len(entries)-1])
+	entries[idx] = entry
+	dst.entries = entries
+	return nil
+}
+
+func (dst *sliceSet) Remove(d digest.Digest) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	idx := dst.search(d.Algorithm(), d.Hex())
+	if idx == len(dst.entries) || dst.entries[idx].digest != d {
+		return nil
+	}
+	copy(dst.entries[idx:], dst.entries[idx+1:])
+	dst.entries = dst.entries[:len(dst.entries)-1]
+	return nil
+}
+
+// createMixedDigests creates digests of several algorithms whose values
+// share prefixes with each other, to exercise splitting and merging of
+// trie nodes.
+func createMixedDigests(r *rand.Rand, count int) []digest.Digest {
+	algs := []digest.Algorithm{digest.SHA256, digest.SHA384, digest.SHA512}
+	digests := make([]digest.Digest, count)
+	for i := range digests {
+		h := sha256.New()
+		binary.Write(h, binary.BigEndian, r.Int63n(int64(count)))
+		val := fmt.Sprintf("%x", h.Sum(nil))
+		alg := algs[r.Intn(len(algs))]
+		for len(val) < alg.Size()*2 {
+			val += val
+		}
+		val = val[:alg.Size()*2]
+		// Force long shared prefixes between some of the digests.
+		if r.Intn(4) == 0 {
+			val = "abcabc" + val[6:]
+		}
+		digests[i] = digest.NewDigestFromEncoded(alg, val)
+	}
+	return digests
+}
+
+func TestTrieMatchesSlice(t *testing.T) {
+	r := rand.New(rand.NewSource(9431))
+	digests := createMixedDigests(r, 500)
+
+	dset := NewSet()
+	sset := &sliceSet{}
+	for _, d := range digests {
+		if err := dset.Add(d); err != nil {
+			t.Fatal(err)
+		}
+		if err := sset.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Remove a random selection, including digests which were never added.
+	for _, d := range createMixedDigests(r, 300) {
+		if err := dset.Remove(d); err != nil {
+			t.Fatal(err)
+		}
+		if err := sset.Remove(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		d := digests[r.Intn(len(digests))]
+		dset.Remove(d)
+		sset.Remove(d)
+	}
+
+	all := dset.All()
+	if len(all) != len(sset.entries) {
+		t.Fatalf("unexpected number of digests: %d != %d", len(all), len(sset.entries))
+	}
+	for i, d := range all {
+		if sset.entries[i].digest != d {
+			t.Fatalf("unexpected digest at %d: %s != %s", i, d, sset.entries[i].digest)
+		}
+	}
+
+	queries := []string{"", "a", "abc", "abcabc", "abcabd", "f", "sha256:abc", "sha384:abcabc", "sha512:0", "sha256:"}
+	for _, d := range digests {
+		for _, l := range []int{1, 2, 3, 5, 8, len(d.Encoded())} {
+			queries = append(queries, d.Encoded()[:l], d.Algorithm().String()+":"+d.Encoded()[:l])
+		}
+	}
+	for _, q := range queries {
+		expected, expectedErr := sset.Lookup(q)
+		actual, err := dset.Lookup(q)
+		if actual != expected || err != expectedErr {
+			t.Fatalf("lookup of %q differs: got %q, %v expected %q, %v", q, actual, err, expected, expectedErr)
+		}
+	}
+
+	for _, length := range []int{1, 2, 4, 12} {
+		expected := sset.entries.shortCodes(length)
+		actual := ShortCodeTable(dset, length)
+		if len(actual) != len(expected) {
+			t.Fatalf("unexpected short code table size: %d != %d", len(actual), len(expected))
+		}
+		for d, short := range expected {
+			if actual[d] != short {
+				t.Fatalf("short code for %s differs: %q != %q", d, actual[d], short)
+			}
+		}
+	}
+
+	// Removing everything must leave an empty, compressed trie.
+	for _, d := range digests {
+		dset.Remove(d)
+	}
+	if dset.trie.size != 0 || len(dset.trie.root.children) != 0 {
+		t.Fatalf("trie not empty after removing all digests")
+	}
+}
+
+func benchSliceAddNTable(b *testing.B, n int) {
+	digests, err := createDigests(n)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dset := &sliceSet{entries: make(digestEntries, 0, n)}
+		for j := range digests {
+			if err = dset.Add(digests[j]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func benchSliceRemoveNTable(b *testing.B, n int) {
+	digests, err := createDigests(n)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dset := &sliceSet{entries: make(digestEntries, 0, n)}
+		b.StopTimer()
+		for j := range digests {
+			if err = dset.Add(digests[j]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StartTimer()
+		for j := range digests {
+			if err = dset.Remove(digests[j]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func benchSliceLookupNTable(b *testing.B, n int, shortLen int) {
+	digests, err := createDigests(n)
+	if err != nil {
+		b.Fatal(err)
+	}
+	dset := &sliceSet{entries: make(digestEntries, 0, n)}
+	for i := range digests {
+		if err := dset.Add(digests[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	shorts := make([]string, 0, n)
+	for _, short := range dset.entries.shortCodes(shortLen) {
+		shorts = append(shorts, short)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = dset.Lookup(shorts[i%n]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAdd10000(b *testing.B) {
+	benchAddNTable(b, 10000)
+}
+
+func BenchmarkRemove10000(b *testing.B) {
+	benchRemoveNTable(b, 10000)
+}
+
+func BenchmarkLookup10000(b *testing.B) {
+	benchLookupNTable(b, 10000, 12)
+}
+
+func BenchmarkSliceAdd1000(b *testing.B) {
+	benchSliceAddNTable(b, 1000)
+}
+
+func BenchmarkSliceAdd10000(b *testing.B) {
+	benchSliceAddNTable(b, 10000)
+}
+
+func BenchmarkSliceRemove1000(b *testing.B) {
+	benchSliceRemoveNTable(b, 1000)
+}
+
+func BenchmarkSliceRemove10000(b *testing.B) {
+	benchSliceRemoveNTable(b, 10000)
+}
+
+func BenchmarkSliceLookup1000(b *testing.B) {
+	benchSliceLookupNTable(b, 1000, 12)
+}
+
+func BenchmarkSliceLookup10000(b *testing.B) {
+	benchSliceLookupNTable(b, 10000, 12)
+}
@@ -0,0 +1,215 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+var (
+	// ErrBadSnapshot is returned when a snapshot read by ReadFrom is
+	// malformed or fails checksum verification.
+	ErrBadSnapshot = errors.New("bad digest set snapshot")
+
+	// snapshotMagic identifies a serialized set and the version of its format.
+	snapshotMagic = [8]byte{'d', 'g', 's', 't', 's', 'e', 't', 1}
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// maxDigestLen bounds the length of a serialized digest so that corrupt
+// input cannot cause arbitrarily large allocations.
+const maxDigestLen = 1024
+
+// WriteTo writes a snapshot of the set to w. The snapshot consists of a
+// header, the number of digests, each digest prefixed with its length and a
+// trailing CRC-32C checksum of all preceding bytes.
+func (dst *Set) WriteTo(w io.Writer) (int64, error) {
+	dst.mutex.RLock()
+	defer dst.mutex.RUnlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	crc := crc32.New(crcTable)
+	mw := io.MultiWriter(bw, crc)
+
+	var buf [binary.MaxVarintLen64]byte
+	mw.Write(snapshotMagic[:])
+	mw.Write(buf[:binary.PutUvarint(buf[:], uint64(dst.trie.size))])
+	dst.trie.root.walk(func(e *digestEntry) bool {
+		mw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(e.digest)))])
+		io.WriteString(mw, string(e.digest))
+		return true
+	})
+	binary.BigEndian.PutUint32(buf[:], crc.Sum32())
+	bw.Write(buf[:crc32.Size])
+
+	// bufio.Writer remembers the first error, so checking on flush is
+	// enough.
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ReadFrom reads a snapshot written by WriteTo from r and adds its digests
+// to the set. The set is left unchanged if the snapshot is malformed. It
+// returns the number of bytes of the snapshot read.
+//
+// If r implements io.ByteReader, such as a bufio.Reader, ReadFrom reads no
+// further than the end of the snapshot, so that data following it can still
+// be read from r. Otherwise r is buffered and may be read past the end.
+func (dst *Set) ReadFrom(r io.Reader) (int64, error) {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	crc := crc32.New(crcTable)
+	tr := &crcReader{r: br, crc: crc}
+
+	var magic [len(snapshotMagic)]byte
+	if _, err := io.ReadFull(tr, magic[:]); err != nil {
+		return tr.n, unexpected(err)
+	}
+	if magic != snapshotMagic {
+		return tr.n, ErrBadSnapshot
+	}
+	count, err := binary.ReadUvarint(tr)
+	if err != nil {
+		return tr.n, unexpected(err)
+	}
+
+	var entries []*digestEntry
+	for i := uint64(0); i < count; i++ {
+		d, err := readDigest(tr)
+		if err != nil {
+			return tr.n, err
+		}
+		entries = append(entries, &digestEntry{alg: d.Algorithm(), val: d.Hex(), digest: d})
+	}
+
+	expected := crc.Sum32()
+	var sum [crc32.Size]byte
+	// The checksum is read through tr for the count, after the sum has
+	// been taken.
+	if _, err := io.ReadFull(tr, sum[:]); err != nil {
+		return tr.n, unexpected(err)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != expected {
+		return tr.n, ErrBadSnapshot
+	}
+
+	dst.mutex.Lock()
+	defer dst.mutex.Unlock()
+	for _, e := range entries {
+		if dst.trie.insert(e) {
+			dst.notify(SetEventAdded, e.digest)
+		}
+	}
+	return tr.n, nil
+}
+
+// readDigest reads a length prefixed digest and validates it.
+func readDigest(r io.ByteReader) (digest.Digest, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", unexpected(err)
+	}
+	if l > maxDigestLen {
+		return "", ErrBadSnapshot
+	}
+	p := make([]byte, l)
+	for i := range p {
+		if p[i], err = r.ReadByte(); err != nil {
+			return "", unexpected(err)
+		}
+	}
+	d := digest.Digest(p)
+	if err := d.Validate(); err != nil {
+		return "", err
+	}
+	return d, nil
+}
+
+// unexpected converts io.EOF into io.ErrUnexpectedEOF, for use where more
+// data is required.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// crcReader updates a checksum with all bytes read through it and counts
+// them.
+type crcReader struct {
+	r   byteReader
+	crc hash.Hash32
+	n   int64
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.crc.Write(p[:n])
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *crcReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.crc.Write([]byte{b})
+		cr.n++
+	}
+	return b, err
+}
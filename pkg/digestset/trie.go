@@ -0,0 +1,277 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+// trie is a compressed radix trie of digest entries keyed on their encoded
+// value. Entries sharing an encoded value but differing in algorithm are held
+// by the same node, ordered by algorithm. An in-order walk of the trie visits
+// entries ordered by value, then algorithm, which is the order the set has
+// always exposed.
+type trie struct {
+	root trieNode
+	size int
+}
+
+type trieNode struct {
+	// label is the part of the key between the parent and this node.
+	label string
+	// entries holds the digests whose encoded value ends at this node,
+	// ordered by algorithm.
+	entries []*digestEntry
+	// children is ordered by the first byte of their label. No two children
+	// share a first byte.
+	children []*trieNode
+}
+
+func commonPrefixLen(a, b string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// child returns the index of the child whose label starts with c, or the
+// index at which such a child would be inserted and false.
+func (n *trieNode) child(c byte) (int, bool) {
+	lo, hi := 0, len(n.children)
+	for lo < hi {
+		m := int(uint(lo+hi) >> 1)
+		if n.children[m].label[0] < c {
+			lo = m + 1
+		} else {
+			hi = m
+		}
+	}
+	return lo, lo < len(n.children) && n.children[lo].label[0] == c
+}
+
+// entry returns the index of the entry with the given algorithm, or the index
+// at which it would be inserted and false.
+func (n *trieNode) entry(alg digest.Algorithm) (int, bool) {
+	for i, e := range n.entries {
+		if e.alg >= alg {
+			return i, e.alg == alg
+		}
+	}
+	return len(n.entries), false
+}
+
+// find returns the node whose key is exactly key, or nil.
+func (t *trie) find(key string) *trieNode {
+	n := &t.root
+	for len(key) > 0 {
+		i, ok := n.child(key[0])
+		if !ok {
+			return nil
+		}
+		c := n.children[i]
+		if len(key) < len(c.label) || key[:len(c.label)] != c.label {
+			return nil
+		}
+		key = key[len(c.label):]
+		n = c
+	}
+	return n
+}
+
+// get returns the entry for the given value and algorithm, or nil.
+func (t *trie) get(val string, alg digest.Algorithm) *digestEntry {
+	n := t.find(val)
+	if n == nil {
+		return nil
+	}
+	if i, ok := n.entry(alg); ok {
+		return n.entries[i]
+	}
+	return nil
+}
+
+// insert adds the entry to the trie, returning false if an entry with the
+// same value and algorithm already exists.
+func (t *trie) insert(e *digestEntry) bool {
+	n, key := &t.root, e.val
+	for len(key) > 0 {
+		i, ok := n.child(key[0])
+		if !ok {
+			leaf := &trieNode{label: key}
+			n.children = append(n.children, nil)
+			copy(n.children[i+1:], n.children[i:])
+			n.children[i] = leaf
+			n = leaf
+			key = ""
+			break
+		}
+		c := n.children[i]
+		common := commonPrefixLen(key, c.label)
+		if common < len(c.label) {
+			// Split the edge so that the common part becomes its own node.
+			split := &trieNode{label: c.label[:common], children: []*trieNode{c}}
+			c.label = c.label[common:]
+			n.children[i] = split
+			c = split
+		}
+		key = key[common:]
+		n = c
+	}
+
+	i, ok := n.entry(e.alg)
+	if ok {
+		return false
+	}
+	n.entries = append(n.entries, nil)
+	copy(n.entries[i+1:], n.entries[i:])
+	n.entries[i] = e
+	t.size++
+	return true
+}
+
+// delete removes the entry with the given value and algorithm from the trie,
+// returning false if no such entry exists. Nodes left without entries are
+// pruned or merged with their only child to keep the trie compressed.
+func (t *trie) delete(val string, alg digest.Algorithm) bool {
+	// Remember the path so that nodes can be pruned on the way back up. The
+	// depth of the trie is bounded by the number of distinct prefixes, which
+	// in practice is small, so the path usually fits the array.
+	var stack [32]*trieNode
+	path := append(stack[:0], &t.root)
+	n, key := &t.root, val
+	for len(key) > 0 {
+		i, ok := n.child(key[0])
+		if !ok {
+			return false
+		}
+		c := n.children[i]
+		if len(key) < len(c.label) || key[:len(c.label)] != c.label {
+			return false
+		}
+		key = key[len(c.label):]
+		n = c
+		path = append(path, n)
+	}
+
+	i, ok := n.entry(alg)
+	if !ok {
+		return false
+	}
+	copy(n.entries[i:], n.entries[i+1:])
+	n.entries[len(n.entries)-1] = nil
+	n.entries = n.entries[:len(n.entries)-1]
+	t.size--
+
+	for j := len(path) - 1; j > 0; j-- {
+		n, parent := path[j], path[j-1]
+		if len(n.entries) > 0 {
+			break
+		}
+		idx, _ := parent.child(n.label[0])
+		switch len(n.children) {
+		case 0:
+			copy(parent.children[idx:], parent.children[idx+1:])
+			parent.children[len(parent.children)-1] = nil
+			parent.children = parent.children[:len(parent.children)-1]
+			continue
+		case 1:
+			c := n.children[0]
+			c.label = n.label + c.label
+			parent.children[idx] = c
+		}
+		break
+	}
+	return true
+}
+
+// walk visits all entries in order until fn returns false. It returns false
+// if the walk was stopped.
+func (n *trieNode) walk(fn func(*digestEntry) bool) bool {
+	for _, e := range n.entries {
+		if !fn(e) {
+			return false
+		}
+	}
+	for _, c := range n.children {
+		if !c.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// seek visits, in order, all entries ordered at or after the given value and
+// algorithm until fn returns false. An empty algorithm orders before all
+// others, so seek(val, "", fn) visits all entries whose value is at or after
+// val.
+func (t *trie) seek(val string, alg digest.Algorithm, fn func(*digestEntry) bool) {
+	t.root.seek(val, alg, fn)
+}
+
+// seek is called with the part of the key remaining below the parent of n.
+func (n *trieNode) seek(rest string, alg digest.Algorithm, fn func(*digestEntry) bool) bool {
+	common := commonPrefixLen(n.label, rest)
+	switch {
+	case common == len(n.label) && common == len(rest):
+		// The key of n equals the value sought.
+		for _, e := range n.entries {
+			if e.alg >= alg && !fn(e) {
+				return false
+			}
+		}
+		for _, c := range n.children {
+			if !c.walk(fn) {
+				return false
+			}
+		}
+		return true
+	case common == len(n.label):
+		// The key of n is a proper prefix of the value sought, so the
+		// entries of n order before it. Descend into the child sharing the
+		// next byte and visit all children ordered after it.
+		rest = rest[common:]
+		i, ok := n.child(rest[0])
+		if ok {
+			if !n.children[i].seek(rest, alg, fn) {
+				return false
+			}
+			i++
+		}
+		for _, c := range n.children[i:] {
+			if !c.walk(fn) {
+				return false
+			}
+		}
+		return true
+	case common == len(rest) || n.label[common] > rest[common]:
+		// Everything below n orders after the value sought.
+		return n.walk(fn)
+	default:
+		// Everything below n orders before the value sought.
+		return true
+	}
+}
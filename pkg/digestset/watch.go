@@ -0,0 +1,129 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+// WatchBuffer is the number of events buffered for each watcher of a Set.
+const WatchBuffer = 256
+
+// SetEventType is the kind of change reported by a SetEvent.
+type SetEventType int
+
+const (
+	// SetEventAdded reports that a digest was added to the set.
+	SetEventAdded SetEventType = iota + 1
+
+	// SetEventRemoved reports that a digest was removed from the set.
+	SetEventRemoved
+
+	// SetEventOverflow reports that the watcher did not keep up and events
+	// were dropped. The watcher should resynchronize with the contents of
+	// the set. Events following the overflow may already be reflected in
+	// the contents, but applying them in order still gives the right
+	// result.
+	SetEventOverflow
+)
+
+func (t SetEventType) String() string {
+	switch t {
+	case SetEventAdded:
+		return "added"
+	case SetEventRemoved:
+		return "removed"
+	case SetEventOverflow:
+		return "overflow"
+	}
+	return "unknown"
+}
+
+// SetEvent is a change to a Set. Digest is empty for SetEventOverflow.
+type SetEvent struct {
+	Type   SetEventType
+	Digest digest.Digest
+}
+
+// watcher is a channel of events with the last slot of its buffer reserved
+// for the overflow event.
+type watcher struct {
+	events     chan SetEvent
+	overflowed bool
+}
+
+// send delivers an event without blocking. When the buffer is full, the
+// event is dropped and an overflow event is sent instead, unless one is
+// still pending at the end of the buffer. The caller must hold the write
+// lock of the set.
+func (w *watcher) send(ev SetEvent) {
+	if len(w.events) < cap(w.events)-1 {
+		w.events <- ev
+		w.overflowed = false
+		return
+	}
+	if !w.overflowed {
+		w.events <- SetEvent{Type: SetEventOverflow}
+		w.overflowed = true
+	}
+}
+
+// Watch returns a channel of the changes made to the set, until ctx is
+// done, at which point the channel is closed. Up to WatchBuffer events are
+// buffered; a watcher falling further behind receives a SetEventOverflow
+// event. Changes are only reported if they modify the set, adding a digest
+// that is already present is not reported.
+func (dst *Set) Watch(ctx context.Context) <-chan SetEvent {
+	dst.mutex.Lock()
+	defer dst.mutex.Unlock()
+	return dst.watch(ctx)
+}
+
+// watch registers a watcher. The caller must hold the write lock, which
+// allows it to take a consistent snapshot of the set along with it.
+func (dst *Set) watch(ctx context.Context) <-chan SetEvent {
+	w := &watcher{events: make(chan SetEvent, WatchBuffer+1)}
+	dst.watchers = append(dst.watchers, w)
+
+	go func() {
+		<-ctx.Done()
+		dst.mutex.Lock()
+		defer dst.mutex.Unlock()
+		for i, other := range dst.watchers {
+			if other == w {
+				dst.watchers = append(dst.watchers[:i], dst.watchers[i+1:]...)
+				break
+			}
+		}
+		close(w.events)
+	}()
+	return w.events
+}
+
+// notify sends an event to all watchers. The caller must hold the write
+// lock.
+func (dst *Set) notify(t SetEventType, d digest.Digest) {
+	for _, w := range dst.watchers {
+		w.send(SetEvent{Type: t, Digest: d})
+	}
+}
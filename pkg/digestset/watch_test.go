@@ -0,0 +1,178 @@
+package digestset
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	digest "github.com/bhojpur/crypto/pkg/digest"
+)
+
+func nextEvent(t *testing.T, events <-chan SetEvent) SetEvent {
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	return SetEvent{}
+}
+
+func TestWatch(t *testing.T) {
+	dset := NewSet()
+	ctx, cancel := context.WithCancel(context.Background())
+	events := dset.Watch(ctx)
+
+	d1 := digest.FromString("bhojpur")
+	d2 := digest.FromString("crypto")
+	dset.Add(d1)
+	dset.Add(d1)
+	dset.Add(d2)
+	dset.Remove(d1)
+	dset.Remove(d1)
+
+	expected := []SetEvent{
+		{Type: SetEventAdded, Digest: d1},
+		{Type: SetEventAdded, Digest: d2},
+		{Type: SetEventRemoved, Digest: d1},
+	}
+	for _, want := range expected {
+		if ev := nextEvent(t, events); ev != want {
+			t.Fatalf("unexpected event: %v %s, expected %v %s", ev.Type, ev.Digest, want.Type, want.Digest)
+		}
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatalf("expected channel to be closed")
+	}
+	dset.mutex.RLock()
+	defer dset.mutex.RUnlock()
+	if len(dset.watchers) != 0 {
+		t.Fatalf("watcher not removed")
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	dset := NewSet()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := dset.Watch(ctx)
+
+	digests, err := createDigests(WatchBuffer + 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range digests {
+		dset.Add(d)
+	}
+
+	for i := 0; i < WatchBuffer; i++ {
+		if ev := nextEvent(t, events); ev.Type != SetEventAdded || ev.Digest != digests[i] {
+			t.Fatalf("unexpected event %d: %v %s", i, ev.Type, ev.Digest)
+		}
+	}
+	if ev := nextEvent(t, events); ev.Type != SetEventOverflow {
+		t.Fatalf("expected overflow, got %v", ev.Type)
+	}
+
+	// Once drained, events are delivered again.
+	d := digest.FromString("bhojpur")
+	dset.Add(d)
+	if ev := nextEvent(t, events); ev.Type != SetEventAdded || ev.Digest != d {
+		t.Fatalf("unexpected event after overflow: %v %s", ev.Type, ev.Digest)
+	}
+}
+
+func assertShortCodes(t *testing.T, actual, expected map[digest.Digest]string) {
+	if len(actual) != len(expected) {
+		t.Fatalf("unexpected short code table size: %d != %d", len(actual), len(expected))
+	}
+	for d, short := range expected {
+		if actual[d] != short {
+			t.Fatalf("short code for %s differs: %q != %q", d, actual[d], short)
+		}
+	}
+}
+
+func TestShortCodesIncremental(t *testing.T) {
+	r := rand.New(rand.NewSource(4177))
+	for _, length := range []int{1, 2, 4, 12} {
+		digests := createMixedDigests(r, 200)
+		dset := NewSet()
+		codes := NewShortCodes(length)
+		for i := 0; i < 600; i++ {
+			d := digests[r.Intn(len(digests))]
+			if r.Intn(3) == 0 {
+				dset.Remove(d)
+				codes.Remove(d)
+			} else {
+				dset.Add(d)
+				codes.Add(d)
+			}
+			assertShortCodes(t, codes.Table(), ShortCodeTable(dset, length))
+		}
+	}
+}
+
+func TestWatchShortCodes(t *testing.T) {
+	r := rand.New(rand.NewSource(2290))
+	digests := createMixedDigests(r, 100)
+	dset := NewSet()
+	for _, d := range digests[:50] {
+		dset.Add(d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	codes := WatchShortCodes(ctx, dset, 2)
+	assertShortCodes(t, codes.Table(), ShortCodeTable(dset, 2))
+
+	for _, d := range digests[50:] {
+		dset.Add(d)
+	}
+	for _, d := range digests[:25] {
+		dset.Remove(d)
+	}
+	expected := ShortCodeTable(dset, 2)
+	deadline := time.Now().Add(time.Second)
+	for !sameShortCodes(codes.Table(), expected) {
+		if time.Now().After(deadline) {
+			assertShortCodes(t, codes.Table(), expected)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func sameShortCodes(actual, expected map[digest.Digest]string) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+	for d, short := range expected {
+		if actual[d] != short {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	// ErrNotRunning is returned when stopping an engine the executor does
+	// not run.
+	ErrNotRunning = errors.New("engine is not running")
+
+	// ErrStopped is reported as the reason a stopped engine failed. The
+	// details of the last status of a stopped engine start with its text.
+	ErrStopped = errors.New("stopped")
+
+	// errKilled is reported if a stopped engine did not terminate within
+	// the grace period.
+	errKilled = fmt.Errorf("%w, killed after the grace period", ErrStopped)
+)
+
+// WasStopped returns true if a status of PHASE_DONE is that of a stopped
+// engine.
+func WasStopped(status *v1.EngineStatus) bool {
+	return status.Phase == v1.EnginePhase_PHASE_DONE && strings.HasPrefix(status.Details, ErrStopped.Error())
+}
+
+// Engine is an engine handed to an executor.
+type Engine struct {
+	Name     string
+	Metadata *v1.EngineMetadata
+	Spec     *Spec
+
+	// Config is the config YAML of the engine, if any.
+	Config []byte
+
+	// Application opens the gzipped application tar stream of the engine.
+	// It is nil if the engine has no application.
+	Application func() (io.ReadCloser, error)
+
+	// Logs receives the log output of the engine.
+	Logs io.Writer
+
+	// Update is called with the status of the engine whenever it changes.
+	// The last update has PHASE_DONE.
+	Update func(*v1.EngineStatus)
+
+	// CanReplay is set if the inputs of the engine have been stored, so
+	// that it can be started again.
+	CanReplay bool
+
+	// WaitUntil is the start time the engine waited for, if any.
+	WaitUntil *timestamppb.Timestamp
+}
+
+// Status returns a new status of the engine in the given phase.
+func (e *Engine) Status(phase v1.EnginePhase) *v1.EngineStatus {
+	return &v1.EngineStatus{
+		Name:     e.Name,
+		Metadata: e.Metadata,
+		Phase:    phase,
+		Conditions: &v1.EngineConditions{
+			CanReplay: e.CanReplay,
+			WaitUntil: e.WaitUntil,
+		},
+	}
+}
+
+// Executor runs engines.
+type Executor interface {
+	// Start starts running an engine. It returns once the engine has been
+	// started, reporting its progress through Engine.Update until it is
+	// done. Cancelling ctx only affects starting the engine.
+	Start(ctx context.Context, engine *Engine) error
+
+	// Stop stops a running engine, or returns ErrNotRunning. The engine
+	// enters PHASE_CLEANUP and is asked to terminate, and is killed if it
+	// has not within the grace period. Stop returns without waiting for
+	// that, the engine reports being done through Engine.Update. Stopping
+	// an engine which is being stopped already does nothing.
+	Stop(name string, grace time.Duration) error
+}
@@ -0,0 +1,640 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/logslice"
+	"github.com/bhojpur/crypto/pkg/store"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// managedByLabel marks the pods and config maps of engines.
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "bhojpur-crypto"
+
+	// engineAnnotation holds the name of the engine of a pod, which may be
+	// too long for a label.
+	engineAnnotation = "crypto.bhojpur.net/engine"
+
+	// MaxKubernetesApplication is the largest application the Kubernetes
+	// executor accepts. Applications are passed to pods in a config map,
+	// whose size is limited by the API server.
+	MaxKubernetesApplication = 900 << 10
+
+	// DefaultKubernetesImage is the image steps run in by default.
+	DefaultKubernetesImage = "alpine:3"
+
+	// logsTimeout is how long a finished engine waits for its logs to be
+	// read before it is cleaned up.
+	logsTimeout = 30 * time.Second
+)
+
+// Kubernetes runs each engine in a pod. The steps of an engine run in
+// order as init containers, except for the last one which is the main
+// container of the pod, so that a failed step ends the pod. All
+// containers share an empty working directory, into which the application
+// is unpacked first. Steps run with the environment:
+//
+//	CRYPTO_ENGINE   the name of the engine
+//	CRYPTO_CONFIG   the config YAML of the engine, if there is one
+//
+// along with the environment of the step. Pods are tracked through an
+// informer, their container logs are copied into the logs of the engine,
+// and they are deleted once they are done.
+type Kubernetes struct {
+	Client    kubernetes.Interface
+	Namespace string
+
+	// Image is the container image steps run in.
+	Image string
+
+	stop    chan struct{}
+	mu      sync.Mutex
+	engines map[string]*kubeEngine
+}
+
+var _ Executor = &Kubernetes{}
+
+// NewKubernetes returns a Kubernetes executor running pods in namespace,
+// once it has started tracking pods. Close stops tracking them.
+func NewKubernetes(ctx context.Context, client kubernetes.Interface, namespace string) (*Kubernetes, error) {
+	k := &Kubernetes{
+		Client:    client,
+		Namespace: namespace,
+		Image:     DefaultKubernetesImage,
+		stop:      make(chan struct{}),
+		engines:   map[string]*kubeEngine{},
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = managedByLabel + "=" + managedBy
+		}),
+	)
+	informer := factory.Core().V1().Pods().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { k.observe(obj, false) },
+		UpdateFunc: func(_, obj interface{}) { k.observe(obj, false) },
+		DeleteFunc: func(obj interface{}) { k.observe(obj, true) },
+	})
+	factory.Start(k.stop)
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		close(k.stop)
+		return nil, fmt.Errorf("cannot track pods: %w", ctx.Err())
+	}
+	return k, nil
+}
+
+// Close stops tracking pods.
+func (k *Kubernetes) Close() error {
+	close(k.stop)
+	return nil
+}
+
+// observe hands a pod event to the engine of the pod.
+func (k *Kubernetes) observe(obj interface{}, deleted bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	k.mu.Lock()
+	e := k.engines[pod.Annotations[engineAnnotation]]
+	k.mu.Unlock()
+	if e != nil && e.pod == pod.Name {
+		e.watch.set(pod, deleted)
+	}
+}
+
+// Start implements Executor.
+func (k *Kubernetes) Start(ctx context.Context, engine *Engine) error {
+	e := &kubeEngine{
+		k:      k,
+		engine: engine,
+		pod:    resourceName(engine.Name),
+		watch:  newPodWatch(),
+	}
+	k.mu.Lock()
+	if _, exists := k.engines[engine.Name]; exists {
+		k.mu.Unlock()
+		return fmt.Errorf("engine %s is running already", engine.Name)
+	}
+	k.engines[engine.Name] = e
+	k.mu.Unlock()
+
+	if err := e.create(ctx); err != nil {
+		k.mu.Lock()
+		delete(k.engines, engine.Name)
+		k.mu.Unlock()
+		e.cleanup()
+		return err
+	}
+	go func() {
+		e.run()
+		k.mu.Lock()
+		delete(k.engines, engine.Name)
+		k.mu.Unlock()
+	}()
+	return nil
+}
+
+// Stop implements Executor. The pod of the engine is deleted with the
+// grace period, so that the kubelet terminates its containers in time. If
+// the pod still exists once the grace period is over, it is deleted
+// forcefully.
+func (k *Kubernetes) Stop(name string, grace time.Duration) error {
+	k.mu.Lock()
+	e := k.engines[name]
+	k.mu.Unlock()
+	if e == nil {
+		return ErrNotRunning
+	}
+	if !e.watch.stop() {
+		return nil
+	}
+
+	seconds := int64((grace + time.Second - 1) / time.Second)
+	err := k.Client.CoreV1().Pods(k.Namespace).Delete(context.Background(), e.pod, metav1.DeleteOptions{GracePeriodSeconds: &seconds})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	time.AfterFunc(grace, func() {
+		k.mu.Lock()
+		running := k.engines[name] == e
+		k.mu.Unlock()
+		if !running {
+			return
+		}
+		if pod, deleted, _ := e.watch.get(); deleted || pod == nil {
+			return
+		}
+		e.watch.kill()
+		var force int64
+		err := k.Client.CoreV1().Pods(k.Namespace).Delete(context.Background(), e.pod, metav1.DeleteOptions{GracePeriodSeconds: &force})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.WithError(err).WithField("pod", e.pod).Warn("cannot kill engine pod")
+		}
+	})
+	return nil
+}
+
+var resourceNameSanitizer = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// resourceName returns the name of the pod and config map of an engine.
+func resourceName(engine string) string {
+	name := "crypto-" + resourceNameSanitizer.ReplaceAllString(strings.ToLower(engine), "-")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return strings.TrimRight(name, ".-")
+}
+
+// stepContainer returns the name of the container of the i-th step.
+func stepContainer(i int) string {
+	return fmt.Sprintf("step-%d", i)
+}
+
+const (
+	applicationContainer = "application"
+	applicationKey       = "application.tgz"
+	configKey            = "config.yaml"
+	workspaceDir         = "/workspace"
+	inputDir             = "/input"
+)
+
+// renderPod renders the pod running an engine. The config map of the same
+// name holds the config YAML and application of the engine.
+func (k *Kubernetes) renderPod(engine *Engine, name string, hasApp bool) *corev1.Pod {
+	var (
+		mounts = []corev1.VolumeMount{
+			{Name: "workspace", MountPath: workspaceDir},
+			{Name: "input", MountPath: inputDir, ReadOnly: true},
+		}
+		env        = []corev1.EnvVar{{Name: "CRYPTO_ENGINE", Value: engine.Name}}
+		containers []corev1.Container
+	)
+	if len(engine.Config) > 0 {
+		env = append(env, corev1.EnvVar{Name: "CRYPTO_CONFIG", Value: inputDir + "/" + configKey})
+	}
+	if hasApp {
+		containers = append(containers, corev1.Container{
+			Name:         applicationContainer,
+			Image:        k.Image,
+			Command:      []string{"tar", "xzf", inputDir + "/" + applicationKey, "-C", workspaceDir},
+			VolumeMounts: mounts,
+		})
+	}
+	for i, step := range engine.Spec.Steps {
+		stepEnv := append([]corev1.EnvVar(nil), env...)
+		keys := make([]string, 0, len(step.Env))
+		for key := range step.Env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			stepEnv = append(stepEnv, corev1.EnvVar{Name: key, Value: step.Env[key]})
+		}
+		containers = append(containers, corev1.Container{
+			Name:         stepContainer(i),
+			Image:        k.Image,
+			Command:      step.Command,
+			WorkingDir:   workspaceDir,
+			Env:          stepEnv,
+			VolumeMounts: mounts,
+		})
+	}
+
+	labels := map[string]string{managedByLabel: managedBy}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   k.Namespace,
+			Labels:      labels,
+			Annotations: map[string]string{engineAnnotation: engine.Name},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:  corev1.RestartPolicyNever,
+			InitContainers: containers[:len(containers)-1],
+			Containers:     containers[len(containers)-1:],
+			Volumes: []corev1.Volume{
+				{Name: "workspace", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{Name: "input", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				}}},
+			},
+		},
+	}
+}
+
+// kubeEngine is an engine run by the Kubernetes executor.
+type kubeEngine struct {
+	k      *Kubernetes
+	engine *Engine
+	pod    string
+	watch  *podWatch
+}
+
+// create creates the config map and pod of the engine.
+func (e *kubeEngine) create(ctx context.Context) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        e.pod,
+			Namespace:   e.k.Namespace,
+			Labels:      map[string]string{managedByLabel: managedBy},
+			Annotations: map[string]string{engineAnnotation: e.engine.Name},
+		},
+		Data:       map[string]string{configKey: string(e.engine.Config)},
+		BinaryData: map[string][]byte{},
+	}
+	if e.engine.Application != nil {
+		app, err := e.engine.Application()
+		if err != nil {
+			return fmt.Errorf("cannot open application: %w", err)
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(app, MaxKubernetesApplication+1))
+		app.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read application: %w", err)
+		}
+		if len(data) > MaxKubernetesApplication {
+			return fmt.Errorf("application exceeds %d bytes", MaxKubernetesApplication)
+		}
+		cm.BinaryData[applicationKey] = data
+	}
+
+	client := e.k.Client.CoreV1()
+	if _, err := client.ConfigMaps(e.k.Namespace).Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("cannot create config map: %w", err)
+	}
+	pod := e.k.renderPod(e.engine, e.pod, e.engine.Application != nil)
+	if _, err := client.Pods(e.k.Namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("cannot create pod: %w", err)
+	}
+	return nil
+}
+
+// run reports the progress of the engine until its pod is done, then
+// cleans up.
+func (e *kubeEngine) run() {
+	var (
+		status      = e.engine.Status(v1.EnginePhase_PHASE_PREPARING)
+		logsDone    = make(chan struct{})
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+	go func() {
+		defer close(logsDone)
+		e.copyLogs(ctx)
+	}()
+
+	var failure error
+	for {
+		pod, deleted, changed := e.watch.get()
+		var (
+			phase      = status.Phase
+			details    = status.Details
+			didExecute bool
+		)
+		if pod != nil {
+			phase, didExecute, details, failure = podStatus(pod, len(e.engine.Spec.Steps))
+		}
+		if deleted && phase != v1.EnginePhase_PHASE_DONE {
+			phase, failure = v1.EnginePhase_PHASE_DONE, errors.New("pod was deleted")
+		}
+		if e.watch.stopped() && phase != v1.EnginePhase_PHASE_DONE {
+			phase, details = v1.EnginePhase_PHASE_CLEANUP, "Stopping, waiting for the pod to terminate"
+		}
+		if phase == v1.EnginePhase_PHASE_DONE {
+			status.Conditions.DidExecute = status.Conditions.DidExecute || didExecute
+			break
+		}
+		if phase != status.Phase || details != status.Details || didExecute != status.Conditions.DidExecute {
+			status.Phase, status.Details, status.Conditions.DidExecute = phase, details, didExecute
+			logslice.Mark(e.engine.Logs, store.PhaseValue(phase), v1.LogSliceType_SLICE_PHASE, details)
+			e.engine.Update(proto.Clone(status).(*v1.EngineStatus))
+		}
+		<-changed
+	}
+
+	select {
+	case <-logsDone:
+	case <-time.After(logsTimeout):
+		cancel()
+		<-logsDone
+	}
+
+	status.Phase, status.Details = v1.EnginePhase_PHASE_CLEANUP, "Deleting pod"
+	logslice.Mark(e.engine.Logs, store.PhaseValue(status.Phase), v1.LogSliceType_SLICE_PHASE, status.Details)
+	e.engine.Update(proto.Clone(status).(*v1.EngineStatus))
+	e.cleanup()
+
+	switch {
+	case e.watch.killed():
+		failure = errKilled
+	case e.watch.stopped():
+		failure = ErrStopped
+	}
+	status.Phase, status.Details = v1.EnginePhase_PHASE_DONE, ""
+	status.Conditions.Success = failure == nil
+	if failure != nil {
+		status.Conditions.FailureCount = 1
+		status.Details = failure.Error()
+	}
+	logslice.Mark(e.engine.Logs, store.PhaseValue(status.Phase), v1.LogSliceType_SLICE_PHASE, "Done")
+	e.engine.Update(proto.Clone(status).(*v1.EngineStatus))
+}
+
+// cleanup deletes the pod and config map of the engine.
+func (e *kubeEngine) cleanup() {
+	client := e.k.Client.CoreV1()
+	ctx := context.Background()
+	if err := client.Pods(e.k.Namespace).Delete(ctx, e.pod, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		log.WithError(err).WithField("pod", e.pod).Warn("cannot delete engine pod")
+	}
+	if err := client.ConfigMaps(e.k.Namespace).Delete(ctx, e.pod, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		log.WithError(err).WithField("configmap", e.pod).Warn("cannot delete engine config map")
+	}
+}
+
+// copyLogs copies the logs of the step containers into the logs of the
+// engine, one after the other, marking each as a slice.
+func (e *kubeEngine) copyLogs(ctx context.Context) {
+	pods := e.k.Client.CoreV1().Pods(e.k.Namespace)
+	for i, step := range e.engine.Spec.Steps {
+		container := stepContainer(i)
+		cs, ok := e.watch.waitFor(ctx, container, func(cs *corev1.ContainerStatus) bool {
+			return cs.State.Running != nil || cs.State.Terminated != nil
+		})
+		if !ok {
+			return
+		}
+		logslice.Mark(e.engine.Logs, step.Name, v1.LogSliceType_SLICE_START, fmt.Sprint(step.Command))
+
+		out := logslice.NewWriter(e.engine.Logs, step.Name)
+		stream, err := pods.GetLogs(e.pod, &corev1.PodLogOptions{Container: container, Follow: true}).Stream(ctx)
+		if err == nil {
+			_, err = io.Copy(out, stream)
+			stream.Close()
+		}
+		out.Close()
+		if err != nil {
+			logslice.Mark(e.engine.Logs, step.Name, v1.LogSliceType_SLICE_CONTENT, "cannot read logs: "+err.Error())
+		}
+
+		if cs.State.Terminated == nil {
+			cs, ok = e.watch.waitFor(ctx, container, func(cs *corev1.ContainerStatus) bool {
+				return cs.State.Terminated != nil
+			})
+			if !ok {
+				return
+			}
+		}
+		if code := cs.State.Terminated.ExitCode; code != 0 {
+			logslice.Mark(e.engine.Logs, step.Name, v1.LogSliceType_SLICE_FAIL, fmt.Sprintf("exit code %d", code))
+			return
+		}
+		logslice.Mark(e.engine.Logs, step.Name, v1.LogSliceType_SLICE_DONE, "")
+	}
+}
+
+// containerStatus returns the status of the named container of a pod.
+func containerStatus(pod *corev1.Pod, name string) *corev1.ContainerStatus {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for i := range statuses {
+			if statuses[i].Name == name {
+				return &statuses[i]
+			}
+		}
+	}
+	return nil
+}
+
+// podStatus maps the state of a pod running an engine with the given
+// number of steps onto the phase of the engine. Details describe why the
+// pod is waiting, if it is. The engine has failed if it is done and
+// failure is not nil.
+func podStatus(pod *corev1.Pod, steps int) (phase v1.EnginePhase, didExecute bool, details string, failure error) {
+	for i := 0; i < steps; i++ {
+		cs := containerStatus(pod, stepContainer(i))
+		if cs == nil {
+			continue
+		}
+		if cs.State.Running != nil || cs.State.Terminated != nil {
+			didExecute = true
+		}
+		if t := cs.State.Terminated; t != nil && t.ExitCode != 0 && failure == nil {
+			failure = fmt.Errorf("step %d exited with code %d", i, t.ExitCode)
+			if t.Reason != "" && t.Reason != "Error" {
+				failure = fmt.Errorf("%w: %s", failure, t.Reason)
+			}
+		}
+	}
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, cs := range statuses {
+			if w := cs.State.Waiting; w != nil && w.Reason != "" && w.Reason != "PodInitializing" && details == "" {
+				details = w.Reason
+				if w.Message != "" {
+					details += ": " + w.Message
+				}
+			}
+			if t := cs.State.Terminated; t != nil && t.ExitCode != 0 && cs.Name == applicationContainer && failure == nil {
+				failure = fmt.Errorf("cannot unpack application, exit code %d", t.ExitCode)
+			}
+		}
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return v1.EnginePhase_PHASE_DONE, didExecute, "", nil
+	case corev1.PodFailed:
+		if failure == nil {
+			failure = errors.New("pod failed")
+			if pod.Status.Message != "" {
+				failure = fmt.Errorf("pod failed: %s", pod.Status.Message)
+			}
+		}
+		return v1.EnginePhase_PHASE_DONE, didExecute, "", failure
+	}
+	if didExecute {
+		return v1.EnginePhase_PHASE_RUNNING, true, details, nil
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionTrue {
+			return v1.EnginePhase_PHASE_STARTING, false, details, nil
+		}
+	}
+	return v1.EnginePhase_PHASE_PREPARING, false, details, nil
+}
+
+// podWatch holds the latest state of a pod and signals changes to it.
+type podWatch struct {
+	mu       sync.Mutex
+	pod      *corev1.Pod
+	deleted  bool
+	stopping bool
+	killing  bool
+	changed  chan struct{}
+}
+
+func newPodWatch() *podWatch {
+	return &podWatch{changed: make(chan struct{})}
+}
+
+// set records a new state of the pod.
+func (w *podWatch) set(pod *corev1.Pod, deleted bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pod, w.deleted = pod, w.deleted || deleted
+	w.signal()
+}
+
+func (w *podWatch) signal() {
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// get returns the state of the pod, and a channel closed once it changes.
+func (w *podWatch) get() (*corev1.Pod, bool, <-chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pod, w.deleted, w.changed
+}
+
+// stop records that the engine was stopped. It returns false if it was
+// stopped before.
+func (w *podWatch) stop() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopping {
+		return false
+	}
+	w.stopping = true
+	w.signal()
+	return true
+}
+
+func (w *podWatch) stopped() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stopping
+}
+
+// kill records that the pod was deleted forcefully.
+func (w *podWatch) kill() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.killing = true
+}
+
+func (w *podWatch) killed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.killing
+}
+
+// waitFor waits until the status of the named container satisfies cond.
+// It returns false if the pod is done or deleted first, or ctx is done.
+func (w *podWatch) waitFor(ctx context.Context, container string, cond func(*corev1.ContainerStatus) bool) (*corev1.ContainerStatus, bool) {
+	for {
+		pod, deleted, changed := w.get()
+		if pod != nil {
+			if cs := containerStatus(pod, container); cs != nil && cond(cs) {
+				return cs, true
+			}
+			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				return nil, false
+			}
+		}
+		if deleted {
+			return nil, false
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const kubeSpec = `steps:
+- name: build
+  command: ["make"]
+  env:
+    GOOS: linux
+- name: test
+  command: ["make", "test"]
+`
+
+func TestRenderPod(t *testing.T) {
+	spec, err := ParseSpec([]byte(kubeSpec))
+	if err != nil {
+		t.Fatalf("ParseSpec: %v", err)
+	}
+	k := &Kubernetes{Namespace: "crypto", Image: "golang:1.17"}
+	pod := k.renderPod(&Engine{Name: "crypto-main.1", Spec: spec, Config: []byte("a: b")}, "crypto-crypto-main.1", true)
+
+	var names []string
+	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		names = append(names, c.Name)
+		if c.Image != "golang:1.17" {
+			t.Fatalf("unexpected image of %s: %s", c.Name, c.Image)
+		}
+	}
+	if strings.Join(names, ",") != "application,step-0,step-1" || len(pod.Spec.Containers) != 1 {
+		t.Fatalf("unexpected containers: %v", names)
+	}
+	build := pod.Spec.InitContainers[1]
+	var env []string
+	for _, e := range build.Env {
+		env = append(env, e.Name+"="+e.Value)
+	}
+	if strings.Join(env, ",") != "CRYPTO_ENGINE=crypto-main.1,CRYPTO_CONFIG=/input/config.yaml,GOOS=linux" {
+		t.Fatalf("unexpected environment: %v", env)
+	}
+	if build.WorkingDir != workspaceDir || strings.Join(build.Command, " ") != "make" {
+		t.Fatalf("unexpected step container: %v", build)
+	}
+	if pod.Spec.RestartPolicy != corev1.RestartPolicyNever || pod.Labels[managedByLabel] != managedBy || pod.Annotations[engineAnnotation] != "crypto-main.1" {
+		t.Fatalf("unexpected pod metadata or policy: %v", pod.ObjectMeta)
+	}
+}
+
+func TestResourceName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"crypto-main.1":          "crypto-crypto-main.1",
+		"Local_Build.2":          "crypto-local-build.2",
+		strings.Repeat("a", 300): "crypto-" + strings.Repeat("a", 246),
+	} {
+		if actual := resourceName(name); actual != expected {
+			t.Fatalf("unexpected resource name for %s: %s", name, actual)
+		}
+	}
+}
+
+func running() corev1.ContainerState {
+	return corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+}
+
+func terminated(code int32) corev1.ContainerState {
+	return corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: code}}
+}
+
+func waiting(reason string) corev1.ContainerState {
+	return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}
+}
+
+func TestPodStatus(t *testing.T) {
+	scheduled := []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionTrue}}
+	tests := []struct {
+		Name       string
+		Status     corev1.PodStatus
+		Phase      v1.EnginePhase
+		DidExecute bool
+		Details    string
+		Failure    string
+	}{
+		{Name: "pending", Status: corev1.PodStatus{Phase: corev1.PodPending}, Phase: v1.EnginePhase_PHASE_PREPARING},
+		{
+			Name:   "unpacking",
+			Status: corev1.PodStatus{Phase: corev1.PodPending, Conditions: scheduled, InitContainerStatuses: []corev1.ContainerStatus{{Name: "application", State: running()}}},
+			Phase:  v1.EnginePhase_PHASE_STARTING,
+		},
+		{
+			Name: "image pull",
+			Status: corev1.PodStatus{Phase: corev1.PodPending, Conditions: scheduled, InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "application", State: waiting("ImagePullBackOff")},
+			}},
+			Phase:   v1.EnginePhase_PHASE_STARTING,
+			Details: "ImagePullBackOff",
+		},
+		{
+			Name: "first step",
+			Status: corev1.PodStatus{Phase: corev1.PodPending, Conditions: scheduled, InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "application", State: terminated(0)},
+				{Name: "step-0", State: running()},
+			}, ContainerStatuses: []corev1.ContainerStatus{{Name: "step-1", State: waiting("PodInitializing")}}},
+			Phase:      v1.EnginePhase_PHASE_RUNNING,
+			DidExecute: true,
+		},
+		{
+			Name: "succeeded",
+			Status: corev1.PodStatus{Phase: corev1.PodSucceeded, InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "step-0", State: terminated(0)},
+			}, ContainerStatuses: []corev1.ContainerStatus{{Name: "step-1", State: terminated(0)}}},
+			Phase:      v1.EnginePhase_PHASE_DONE,
+			DidExecute: true,
+		},
+		{
+			Name: "step failed",
+			Status: corev1.PodStatus{Phase: corev1.PodFailed, InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "step-0", State: terminated(2)},
+			}},
+			Phase:      v1.EnginePhase_PHASE_DONE,
+			DidExecute: true,
+			Failure:    "step 0 exited with code 2",
+		},
+		{
+			Name: "unpacking failed",
+			Status: corev1.PodStatus{Phase: corev1.PodFailed, InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "application", State: terminated(1)},
+			}},
+			Phase:   v1.EnginePhase_PHASE_DONE,
+			Failure: "cannot unpack application, exit code 1",
+		},
+		{
+			Name:    "evicted",
+			Status:  corev1.PodStatus{Phase: corev1.PodFailed, Message: "node out of memory"},
+			Phase:   v1.EnginePhase_PHASE_DONE,
+			Failure: "pod failed: node out of memory",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			phase, didExecute, details, failure := podStatus(&corev1.Pod{Status: test.Status}, 2)
+			var failureText string
+			if failure != nil {
+				failureText = failure.Error()
+			}
+			if phase != test.Phase || didExecute != test.DidExecute || details != test.Details || failureText != test.Failure {
+				t.Fatalf("unexpected status: %v %v %q %q", phase, didExecute, details, failureText)
+			}
+		})
+	}
+}
+
+func newKubeEngine(t *testing.T, app []byte) (*Engine, *recorder, *syncBuffer) {
+	spec, err := ParseSpec([]byte(kubeSpec))
+	if err != nil {
+		t.Fatalf("ParseSpec: %v", err)
+	}
+	rec := &recorder{done: make(chan *v1.EngineStatus, 1)}
+	logs := &syncBuffer{}
+	engine := &Engine{
+		Name:     "crypto-main.1",
+		Metadata: &v1.EngineMetadata{},
+		Spec:     spec,
+		Logs:     logs,
+		Update:   rec.update,
+	}
+	if app != nil {
+		engine.Application = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(app)), nil
+		}
+	}
+	return engine, rec, logs
+}
+
+func newTestKubernetes(t *testing.T) (*Kubernetes, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	k, err := NewKubernetes(ctx, client, "crypto")
+	if err != nil {
+		t.Fatalf("NewKubernetes: %v", err)
+	}
+	t.Cleanup(func() { k.Close() })
+	return k, client
+}
+
+// setPodStatus updates the status of the pod of an engine.
+func setPodStatus(t *testing.T, client *fake.Clientset, name string, status corev1.PodStatus) {
+	ctx := context.Background()
+	pod, err := client.CoreV1().Pods("crypto").Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("cannot get pod: %v", err)
+	}
+	pod.Status = status
+	if _, err := client.CoreV1().Pods("crypto").UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("cannot update pod status: %v", err)
+	}
+}
+
+// waitForPhase waits until the recorder saw an update with phase.
+func waitForPhase(t *testing.T, rec *recorder, phase v1.EnginePhase) {
+	for i := 0; i < 5000; i++ {
+		rec.mu.Lock()
+		for _, u := range rec.updates {
+			if u.Phase == phase {
+				rec.mu.Unlock()
+				return
+			}
+		}
+		rec.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("engine did not reach %v", phase)
+}
+
+func TestKubernetes(t *testing.T) {
+	k, client := newTestKubernetes(t)
+	engine, rec, logs := newKubeEngine(t, []byte("tar stream"))
+	if err := k.Start(context.Background(), engine); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	const name = "crypto-crypto-main.1"
+	cm, err := client.CoreV1().ConfigMaps("crypto").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("config map not created: %v", err)
+	}
+	if string(cm.BinaryData[applicationKey]) != "tar stream" {
+		t.Fatalf("unexpected application in config map: %q", cm.BinaryData[applicationKey])
+	}
+
+	scheduled := []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionTrue}}
+	setPodStatus(t, client, name, corev1.PodStatus{Phase: corev1.PodPending, Conditions: scheduled})
+	waitForPhase(t, rec, v1.EnginePhase_PHASE_STARTING)
+	setPodStatus(t, client, name, corev1.PodStatus{Phase: corev1.PodPending, Conditions: scheduled, InitContainerStatuses: []corev1.ContainerStatus{
+		{Name: "application", State: terminated(0)},
+		{Name: "step-0", State: running()},
+	}})
+	waitForPhase(t, rec, v1.EnginePhase_PHASE_RUNNING)
+	setPodStatus(t, client, name, corev1.PodStatus{Phase: corev1.PodSucceeded, InitContainerStatuses: []corev1.ContainerStatus{
+		{Name: "application", State: terminated(0)},
+		{Name: "step-0", State: terminated(0)},
+	}, ContainerStatuses: []corev1.ContainerStatus{{Name: "step-1", State: terminated(0)}}})
+
+	final := rec.wait(t)
+	if !final.Conditions.Success || !final.Conditions.DidExecute {
+		t.Fatalf("unexpected final status: %v", final)
+	}
+	waitForPhase(t, rec, v1.EnginePhase_PHASE_CLEANUP)
+
+	output := logs.String()
+	for _, line := range []string{
+		"[starting|PHASE]\n",
+		"[build|START] [make]\n",
+		"[build] fake logs\n",
+		"[build|DONE]\n",
+		"[test] fake logs\n",
+		"[test|DONE]\n",
+		"[cleanup|PHASE] Deleting pod\n",
+	} {
+		if !strings.Contains(output, line) {
+			t.Fatalf("logs do not contain %q:\n%s", line, output)
+		}
+	}
+
+	if _, err := client.CoreV1().Pods("crypto").Get(context.Background(), name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("pod not deleted: %v", err)
+	}
+	if _, err := client.CoreV1().ConfigMaps("crypto").Get(context.Background(), name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("config map not deleted: %v", err)
+	}
+}
+
+func TestKubernetesStop(t *testing.T) {
+	k, client := newTestKubernetes(t)
+	engine, rec, _ := newKubeEngine(t, nil)
+	if err := k.Start(context.Background(), engine); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := k.Stop(engine.Name, 1500*time.Millisecond); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	final := rec.wait(t)
+	if final.Conditions.Success || final.Details != ErrStopped.Error() || !WasStopped(final) {
+		t.Fatalf("unexpected final status: %v", final)
+	}
+	waitForPhase(t, rec, v1.EnginePhase_PHASE_CLEANUP)
+	if _, err := client.CoreV1().ConfigMaps("crypto").Get(context.Background(), "crypto-crypto-main.1", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("config map not deleted: %v", err)
+	}
+	for i := 0; k.Stop(engine.Name, 0) != ErrNotRunning; i++ {
+		if i > 1000 {
+			t.Fatalf("engine still running after it was done")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestKubernetesKill(t *testing.T) {
+	k, client := newTestKubernetes(t)
+	// The pod lingers until it is deleted a second time, forcefully.
+	var deletes int32
+	client.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return atomic.AddInt32(&deletes, 1) == 1, nil, nil
+	})
+	engine, rec, _ := newKubeEngine(t, nil)
+	if err := k.Start(context.Background(), engine); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := k.Stop(engine.Name, 10*time.Millisecond); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	// Stopping again does nothing.
+	if err := k.Stop(engine.Name, time.Hour); err != nil && err != ErrNotRunning {
+		t.Fatalf("Stop: %v", err)
+	}
+	final := rec.wait(t)
+	if final.Conditions.Success || final.Details != errKilled.Error() || !WasStopped(final) {
+		t.Fatalf("unexpected final status: %v", final)
+	}
+}
+
+func TestKubernetesApplicationTooLarge(t *testing.T) {
+	k, client := newTestKubernetes(t)
+	engine, _, _ := newKubeEngine(t, make([]byte, MaxKubernetesApplication+1))
+	if err := k.Start(context.Background(), engine); err == nil {
+		t.Fatalf("expected too large application to be rejected")
+	}
+	pods, err := client.CoreV1().Pods("crypto").List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(pods.Items) != 0 {
+		t.Fatalf("unexpected pods: %v, %v", pods, err)
+	}
+}
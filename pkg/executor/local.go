@@ -0,0 +1,324 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/logslice"
+	"github.com/bhojpur/crypto/pkg/store"
+	"google.golang.org/protobuf/proto"
+)
+
+// Local runs the steps of engines as processes on the machine the server
+// runs on. Each engine gets a working directory of its own, into which its
+// application is unpacked. Steps run in that directory with a minimal
+// environment:
+//
+//	PATH            the PATH of the server
+//	HOME            the working directory
+//	CRYPTO_ENGINE   the name of the engine
+//	CRYPTO_CONFIG   the config YAML of the engine, if there is one
+//
+// along with the environment of the step. Local does not isolate engines
+// from the machine or each other beyond that, it is meant for development.
+type Local struct {
+	// Dir holds the working directories of the engines.
+	Dir string
+
+	// Keep keeps the working directories of engines once they are done.
+	Keep bool
+
+	mu      sync.Mutex
+	running map[string]*localRun
+}
+
+var _ Executor = &Local{}
+
+// NewLocal returns a Local executor with working directories in dir.
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Local{Dir: dir, running: map[string]*localRun{}}, nil
+}
+
+// localRun is an engine run by Local.
+type localRun struct {
+	once     sync.Once
+	stopping chan struct{}
+	grace    time.Duration
+}
+
+// stop asks the engine to terminate within grace, unless it was asked to
+// before.
+func (r *localRun) stop(grace time.Duration) {
+	r.once.Do(func() {
+		r.grace = grace
+		close(r.stopping)
+	})
+}
+
+// err returns ErrStopped once the engine is asked to stop.
+func (r *localRun) err() error {
+	select {
+	case <-r.stopping:
+		return ErrStopped
+	default:
+		return nil
+	}
+}
+
+// Start implements Executor.
+func (l *Local) Start(ctx context.Context, engine *Engine) error {
+	r := &localRun{stopping: make(chan struct{})}
+	l.mu.Lock()
+	if _, exists := l.running[engine.Name]; exists {
+		l.mu.Unlock()
+		return fmt.Errorf("engine %s is running already", engine.Name)
+	}
+	l.running[engine.Name] = r
+	l.mu.Unlock()
+
+	go func() {
+		defer func() {
+			l.mu.Lock()
+			delete(l.running, engine.Name)
+			l.mu.Unlock()
+		}()
+		l.run(r, engine)
+	}()
+	return nil
+}
+
+// Stop implements Executor. The process of the running step is sent
+// SIGTERM, and killed once the grace period is over. Later steps do not
+// run.
+func (l *Local) Stop(name string, grace time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, ok := l.running[name]
+	if !ok {
+		return ErrNotRunning
+	}
+	r.stop(grace)
+	return nil
+}
+
+// run runs an engine and reports its progress.
+func (l *Local) run(r *localRun, engine *Engine) {
+	var (
+		root    = filepath.Join(l.Dir, engine.Name)
+		workdir = filepath.Join(root, "work")
+		status  = engine.Status(v1.EnginePhase_PHASE_PREPARING)
+	)
+	phase := func(p v1.EnginePhase, desc string) {
+		status.Phase = p
+		logslice.Mark(engine.Logs, store.PhaseValue(p), v1.LogSliceType_SLICE_PHASE, desc)
+		engine.Update(proto.Clone(status).(*v1.EngineStatus))
+	}
+
+	err := l.prepare(r, engine, root, workdir, phase)
+	if err == nil {
+		status.Conditions.DidExecute = true
+		phase(v1.EnginePhase_PHASE_RUNNING, "Running steps")
+		err = l.runSteps(r, engine, root, workdir, phase)
+	}
+	// An engine stopped after its last step still counts as stopped.
+	if r.err() != nil && !errors.Is(err, ErrStopped) {
+		err = ErrStopped
+	}
+	if errors.Is(err, ErrStopped) && status.Phase != v1.EnginePhase_PHASE_CLEANUP {
+		phase(v1.EnginePhase_PHASE_CLEANUP, "Stopping")
+	}
+
+	if !l.Keep {
+		phase(v1.EnginePhase_PHASE_CLEANUP, "Removing working directory")
+		if rerr := os.RemoveAll(root); rerr != nil {
+			logslice.Mark(engine.Logs, "cleanup", v1.LogSliceType_SLICE_CONTENT, "cannot remove working directory: "+rerr.Error())
+		}
+	} else if status.Phase != v1.EnginePhase_PHASE_CLEANUP {
+		phase(v1.EnginePhase_PHASE_CLEANUP, "Keeping working directory")
+	}
+
+	status.Conditions.Success = err == nil
+	if err != nil {
+		status.Conditions.FailureCount = 1
+		status.Details = err.Error()
+	}
+	phase(v1.EnginePhase_PHASE_DONE, "Done")
+}
+
+// prepare creates the working directory of an engine and unpacks its
+// application.
+func (l *Local) prepare(r *localRun, engine *Engine, root, workdir string, phase func(v1.EnginePhase, string)) error {
+	phase(v1.EnginePhase_PHASE_PREPARING, "Preparing working directory")
+	if err := os.RemoveAll(root); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(workdir, 0755); err != nil {
+		return err
+	}
+	if len(engine.Config) > 0 {
+		if err := ioutil.WriteFile(filepath.Join(root, "config.yaml"), engine.Config, 0644); err != nil {
+			return err
+		}
+	}
+	if engine.Application == nil {
+		phase(v1.EnginePhase_PHASE_STARTING, "No application to unpack")
+		return r.err()
+	}
+
+	phase(v1.EnginePhase_PHASE_STARTING, "Unpacking application")
+	app, err := engine.Application()
+	if err != nil {
+		return fmt.Errorf("cannot open application: %w", err)
+	}
+	defer app.Close()
+	if err := untar(app, workdir); err != nil {
+		return err
+	}
+	return r.err()
+}
+
+// runSteps runs the steps of an engine in order until one fails.
+func (l *Local) runSteps(r *localRun, engine *Engine, root, workdir string, phase func(v1.EnginePhase, string)) error {
+	env := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + workdir,
+		"CRYPTO_ENGINE=" + engine.Name,
+	}
+	if len(engine.Config) > 0 {
+		env = append(env, "CRYPTO_CONFIG="+filepath.Join(root, "config.yaml"))
+	}
+
+	for _, step := range engine.Spec.Steps {
+		if err := r.err(); err != nil {
+			return err
+		}
+		logslice.Mark(engine.Logs, step.Name, v1.LogSliceType_SLICE_START, fmt.Sprint(step.Command))
+
+		out := logslice.NewWriter(engine.Logs, step.Name)
+		cmd := exec.Command(step.Command[0], step.Command[1:]...)
+		cmd.Dir = workdir
+		cmd.Env = append(env, stepEnv(step)...)
+		err := runStep(r, cmd, out, phase)
+		out.Close()
+
+		if err != nil {
+			logslice.Mark(engine.Logs, step.Name, v1.LogSliceType_SLICE_FAIL, err.Error())
+			if errors.Is(err, ErrStopped) {
+				return err
+			}
+			return fmt.Errorf("step %s failed: %w", step.Name, err)
+		}
+		logslice.Mark(engine.Logs, step.Name, v1.LogSliceType_SLICE_DONE, "")
+	}
+	return nil
+}
+
+// outputDelay is how long the output of a step is still copied once the
+// step exited, in case processes it left behind hold on to its output.
+const outputDelay = time.Second
+
+// runStep runs the command of a step, copying its output to out. The step
+// runs in a process group of its own, which is killed once the step
+// exited. If the engine is stopped meanwhile, the group is sent SIGTERM
+// and killed after the grace period.
+func runStep(r *localRun, cmd *exec.Cmd, out io.Writer, phase func(v1.EnginePhase, string)) error {
+	// The output goes through a pipe of our own rather than one of
+	// exec.Cmd, so that waiting for the step does not wait for processes
+	// it left behind.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stdout, cmd.Stderr = pw, pw
+	setProcessGroup(cmd)
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		io.Copy(out, pr)
+	}()
+	defer func() {
+		signalGroup(cmd, syscall.SIGKILL)
+		select {
+		case <-copied:
+		case <-time.After(outputDelay):
+		}
+		pr.Close()
+		<-copied
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-r.stopping:
+	}
+
+	phase(v1.EnginePhase_PHASE_CLEANUP, fmt.Sprintf("Stopping, killing the running step after %v", r.grace))
+	if err := signalGroup(cmd, syscall.SIGTERM); err != nil {
+		// Not all platforms support signals other than kill.
+		signalGroup(cmd, syscall.SIGKILL)
+	}
+	timer := time.NewTimer(r.grace)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return ErrStopped
+	case <-timer.C:
+	}
+	signalGroup(cmd, syscall.SIGKILL)
+	<-done
+	return errKilled
+}
+
+// stepEnv returns the environment of a step in a stable order.
+func stepEnv(step Step) []string {
+	env := make([]string, 0, len(step.Env))
+	for k, v := range step.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
@@ -0,0 +1,119 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// alive returns true if the process exists and is not a zombie.
+func alive(pid int) bool {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesized command name.
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestLocalStopKillsProcessGroup(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	pidFile := filepath.Join(t.TempDir(), "sleep.pid")
+	engine, rec, logs := newLocalEngine(t, `steps:
+- name: wait
+  command: ["/bin/sh", "-c", "trap '' TERM; sleep 20 & echo $! > `+pidFile+`; echo waiting; wait; true"]
+`, nil)
+	if err := l.Start(context.Background(), engine); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	for i := 0; !strings.Contains(logs.String(), "[wait] waiting\n"); i++ {
+		if i > 5000 {
+			t.Fatalf("step did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("cannot read pid of grandchild: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("invalid pid of grandchild: %v", err)
+	}
+
+	stopped := time.Now()
+	if err := l.Stop(engine.Name, 100*time.Millisecond); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	final := rec.wait(t)
+	if took := time.Since(stopped); took > 5*time.Second {
+		t.Fatalf("stopping took %v", took)
+	}
+	if final.Details != errKilled.Error() {
+		t.Fatalf("unexpected final status: %v", final)
+	}
+	for i := 0; alive(pid); i++ {
+		if i > 1000 {
+			t.Fatalf("grandchild %d still running", pid)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLocalStepLeavingProcesses(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	engine, rec, _ := newLocalEngine(t, `steps:
+- name: daemon
+  command: ["/bin/sh", "-c", "sleep 20 & echo started"]
+`, nil)
+	started := time.Now()
+	if err := l.Start(context.Background(), engine); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	final := rec.wait(t)
+	if took := time.Since(started); took > 5*time.Second {
+		t.Fatalf("step took %v", took)
+	}
+	if !final.Conditions.Success {
+		t.Fatalf("unexpected final status: %v", final)
+	}
+}
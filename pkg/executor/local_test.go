@@ -0,0 +1,392 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+)
+
+type tarEntry struct {
+	name, link, content string
+	typ                 byte
+}
+
+func makeTar(t *testing.T, entries ...tarEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Linkname: e.link, Typeflag: e.typ, Mode: 0755, Size: int64(len(e.content))}
+		if e.typ != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader: %v", err)
+		}
+		io.WriteString(tw, e.content)
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestUntar(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Entries []tarEntry
+		Err     bool
+	}{
+		{Name: "valid", Entries: []tarEntry{
+			{name: "./", typ: tar.TypeDir},
+			{name: "bin/", typ: tar.TypeDir},
+			{name: "bin/run.sh", content: "echo hi", typ: tar.TypeReg},
+			{name: "lib/data", content: "data", typ: tar.TypeReg},
+			{name: "lib/current", link: "../bin", typ: tar.TypeSymlink},
+			{name: "run", link: "bin/run.sh", typ: tar.TypeLink},
+		}},
+		{Name: "absolute", Entries: []tarEntry{{name: "/etc/passwd", typ: tar.TypeReg}}, Err: true},
+		{Name: "parent", Entries: []tarEntry{{name: "a/../../x", typ: tar.TypeReg}}, Err: true},
+		{Name: "backslash", Entries: []tarEntry{{name: `..\x`, typ: tar.TypeReg}}, Err: true},
+		{Name: "absolute symlink", Entries: []tarEntry{{name: "l", link: "/etc", typ: tar.TypeSymlink}}, Err: true},
+		{Name: "escaping symlink", Entries: []tarEntry{{name: "a/l", link: "../../etc", typ: tar.TypeSymlink}}, Err: true},
+		{Name: "below symlink", Entries: []tarEntry{
+			{name: "d", link: ".", typ: tar.TypeSymlink},
+			{name: "d/l", link: "../x", typ: tar.TypeSymlink},
+		}, Err: true},
+		{Name: "symlink chain", Entries: []tarEntry{
+			{name: "a", link: ".", typ: tar.TypeSymlink},
+			{name: "b", link: "a/..", typ: tar.TypeSymlink},
+		}, Err: true},
+		{Name: "reversed symlink chain", Entries: []tarEntry{
+			{name: "b", link: "a/../x", typ: tar.TypeSymlink},
+			{name: "a", link: ".", typ: tar.TypeSymlink},
+		}, Err: true},
+		{Name: "write through symlink", Entries: []tarEntry{
+			{name: "d", link: "sub", typ: tar.TypeSymlink},
+			{name: "d/f", content: "x", typ: tar.TypeReg},
+		}, Err: true},
+		{Name: "escaping hardlink", Entries: []tarEntry{{name: "l", link: "../x", typ: tar.TypeLink}}, Err: true},
+		{Name: "device", Entries: []tarEntry{{name: "dev", typ: tar.TypeChar}}, Err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "app")
+			os.Mkdir(dir, 0755)
+			err := untar(bytes.NewReader(makeTar(t, test.Entries...)), dir)
+			if test.Err {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("untar: %v", err)
+			}
+			data, err := ioutil.ReadFile(filepath.Join(dir, "lib", "current", "run.sh"))
+			if err != nil || string(data) != "echo hi" {
+				t.Fatalf("unexpected content: %q, %v", data, err)
+			}
+			if data, err := ioutil.ReadFile(filepath.Join(dir, "run")); err != nil || string(data) != "echo hi" {
+				t.Fatalf("unexpected hardlink content: %q, %v", data, err)
+			}
+		})
+	}
+}
+
+// recorder records the updates of an engine.
+type recorder struct {
+	mu      sync.Mutex
+	updates []*v1.EngineStatus
+	done    chan *v1.EngineStatus
+}
+
+func (r *recorder) update(s *v1.EngineStatus) {
+	r.mu.Lock()
+	r.updates = append(r.updates, s)
+	r.mu.Unlock()
+	if s.Phase == v1.EnginePhase_PHASE_DONE {
+		r.done <- s
+	}
+}
+
+func (r *recorder) wait(t *testing.T) *v1.EngineStatus {
+	select {
+	case s := <-r.done:
+		return s
+	case <-time.After(10 * time.Second):
+		t.Fatalf("engine did not finish")
+		return nil
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newLocalEngine(t *testing.T, spec string, app []byte) (*Engine, *recorder, *syncBuffer) {
+	s, err := ParseSpec([]byte(spec))
+	if err != nil {
+		t.Fatalf("ParseSpec: %v", err)
+	}
+	rec := &recorder{done: make(chan *v1.EngineStatus, 1)}
+	logs := &syncBuffer{}
+	engine := &Engine{
+		Name:     "local.1",
+		Metadata: &v1.EngineMetadata{},
+		Spec:     s,
+		Config:   []byte("key: value\n"),
+		Logs:     logs,
+		Update:   rec.update,
+	}
+	if app != nil {
+		engine.Application = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(app)), nil
+		}
+	}
+	return engine, rec, logs
+}
+
+func TestLocal(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	dir := t.TempDir()
+	l, err := NewLocal(dir)
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+
+	app := makeTar(t, tarEntry{name: "greeting", content: "hello", typ: tar.TypeReg})
+	engine, rec, logs := newLocalEngine(t, `steps:
+- name: greet
+  command: ["/bin/sh", "-c", "cat greeting; echo; echo \"$GREETEE\" >&2; cat \"$CRYPTO_CONFIG\""]
+  env:
+    GREETEE: world
+- name: fail
+  command: ["/bin/sh", "-c", "exit 3"]
+- name: never
+  command: ["/bin/sh", "-c", "echo never"]
+`, app)
+	if err := l.Start(context.Background(), engine); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	final := rec.wait(t)
+	if final.Conditions.Success || final.Conditions.FailureCount != 1 || !final.Conditions.DidExecute {
+		t.Fatalf("unexpected final status: %v", final)
+	}
+	if !strings.Contains(final.Details, "step fail failed") {
+		t.Fatalf("unexpected details: %s", final.Details)
+	}
+
+	var phases []v1.EnginePhase
+	for _, u := range rec.updates {
+		phases = append(phases, u.Phase)
+	}
+	expected := []v1.EnginePhase{
+		v1.EnginePhase_PHASE_PREPARING,
+		v1.EnginePhase_PHASE_STARTING,
+		v1.EnginePhase_PHASE_RUNNING,
+		v1.EnginePhase_PHASE_CLEANUP,
+		v1.EnginePhase_PHASE_DONE,
+	}
+	if len(phases) != len(expected) {
+		t.Fatalf("unexpected phases: %v", phases)
+	}
+	for i := range expected {
+		if phases[i] != expected[i] {
+			t.Fatalf("unexpected phases: %v", phases)
+		}
+	}
+
+	output := logs.String()
+	for _, line := range []string{
+		"[greet|START] [/bin/sh -c",
+		"[greet] hello\n",
+		"[greet] world\n",
+		"[greet] key: value\n",
+		"[greet|DONE]\n",
+		"[fail|FAIL] exit status 3\n",
+		"[done|PHASE] Done\n",
+	} {
+		if !strings.Contains(output, line) {
+			t.Fatalf("logs do not contain %q:\n%s", line, output)
+		}
+	}
+	if strings.Contains(output, "[never") {
+		t.Fatalf("step after failure was run:\n%s", output)
+	}
+	if _, err := os.Stat(filepath.Join(dir, engine.Name)); !os.IsNotExist(err) {
+		t.Fatalf("working directory was not removed: %v", err)
+	}
+}
+
+func TestLocalPhases(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	tests := []struct {
+		Name string
+		App  bool
+		Keep bool
+	}{
+		{Name: "application"},
+		{Name: "no application"},
+		{Name: "keep", App: true, Keep: true},
+		{Name: "keep without application", Keep: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			l, err := NewLocal(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewLocal: %v", err)
+			}
+			l.Keep = test.Keep
+			var app []byte
+			if test.App {
+				app = makeTar(t, tarEntry{name: "greeting", content: "hello", typ: tar.TypeReg})
+			}
+			engine, rec, _ := newLocalEngine(t, `steps:
+- name: greet
+  command: ["/bin/sh", "-c", "echo hello"]
+`, app)
+			if err := l.Start(context.Background(), engine); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			if final := rec.wait(t); !final.Conditions.Success {
+				t.Fatalf("unexpected final status: %v", final)
+			}
+
+			var phases []v1.EnginePhase
+			for _, u := range rec.updates {
+				if len(phases) == 0 || phases[len(phases)-1] != u.Phase {
+					phases = append(phases, u.Phase)
+				}
+			}
+			expected := []v1.EnginePhase{
+				v1.EnginePhase_PHASE_PREPARING,
+				v1.EnginePhase_PHASE_STARTING,
+				v1.EnginePhase_PHASE_RUNNING,
+				v1.EnginePhase_PHASE_CLEANUP,
+				v1.EnginePhase_PHASE_DONE,
+			}
+			if !reflect.DeepEqual(phases, expected) {
+				t.Fatalf("unexpected phases: %v", phases)
+			}
+		})
+	}
+}
+
+func TestLocalStop(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	tests := []struct {
+		Name    string
+		Command string
+		Grace   time.Duration
+		Details string
+	}{
+		{Name: "terminated", Command: "echo waiting; exec sleep 60", Grace: time.Minute, Details: ErrStopped.Error()},
+		{Name: "killed", Command: "trap '' TERM; echo waiting; sleep 20; true", Grace: 100 * time.Millisecond, Details: errKilled.Error()},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			l, err := NewLocal(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewLocal: %v", err)
+			}
+			engine, rec, logs := newLocalEngine(t, `steps:
+- name: wait
+  command: ["/bin/sh", "-c", "`+test.Command+`"]
+- name: never
+  command: ["/bin/sh", "-c", "echo never"]
+`, nil)
+			if err := l.Start(context.Background(), engine); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			for i := 0; !strings.Contains(logs.String(), "[wait] waiting\n"); i++ {
+				if i > 5000 {
+					t.Fatalf("step did not start")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			stopped := time.Now()
+			if err := l.Stop(engine.Name, test.Grace); err != nil {
+				t.Fatalf("Stop: %v", err)
+			}
+			// Stopping again does nothing.
+			if err := l.Stop(engine.Name, 0); err != nil && err != ErrNotRunning {
+				t.Fatalf("Stop: %v", err)
+			}
+			final := rec.wait(t)
+			if final.Conditions.Success || !final.Conditions.DidExecute || final.Details != test.Details || !WasStopped(final) {
+				t.Fatalf("unexpected final status: %v", final)
+			}
+			if took := time.Since(stopped); took > test.Grace+5*time.Second {
+				t.Fatalf("stopping took %v", took)
+			}
+			rec.mu.Lock()
+			cleanup := rec.updates[len(rec.updates)-3]
+			rec.mu.Unlock()
+			if cleanup.Phase != v1.EnginePhase_PHASE_CLEANUP || !strings.Contains(logs.String(), "[cleanup|PHASE] Stopping") {
+				t.Fatalf("engine did not enter cleanup when stopped: %v\n%s", cleanup, logs.String())
+			}
+			if strings.Contains(logs.String(), "[never") {
+				t.Fatalf("step after the stopped one ran:\n%s", logs.String())
+			}
+			// The engine is removed once it is done.
+			for i := 0; l.Stop(engine.Name, 0) != ErrNotRunning; i++ {
+				if i > 1000 {
+					t.Fatalf("engine still running after it was done")
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+)
+
+// Noop is an executor which does not run anything. It reports engines as
+// finished successfully right away, without executing them, which is
+// useful to try out the API.
+type Noop struct{}
+
+var _ Executor = Noop{}
+
+// Start implements Executor.
+func (Noop) Start(ctx context.Context, engine *Engine) error {
+	go func() {
+		for _, step := range engine.Spec.Steps {
+			fmt.Fprintf(engine.Logs, "skipping step %s: %v\n", step.Name, step.Command)
+		}
+		status := engine.Status(v1.EnginePhase_PHASE_DONE)
+		status.Conditions.Success = true
+		status.Details = "not executed by the noop executor"
+		engine.Update(status)
+	}()
+	return nil
+}
+
+// Stop implements Executor. Engines finish immediately, so there is
+// nothing to stop.
+func (Noop) Stop(name string, grace time.Duration) error {
+	return ErrNotRunning
+}
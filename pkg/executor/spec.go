@@ -0,0 +1,63 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Spec is the specification of an engine, as found in an engine YAML file.
+type Spec struct {
+	// Description is a human readable description of the engine.
+	Description string `json:"desc,omitempty"`
+
+	// Steps are run in order until one fails.
+	Steps []Step `json:"steps"`
+}
+
+// Step is a single command run by an engine.
+type Step struct {
+	Name    string            `json:"name"`
+	Command []string          `json:"command"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+// ParseSpec parses and validates an engine YAML file.
+func ParseSpec(data []byte) (*Spec, error) {
+	var spec Spec
+	if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+		return nil, fmt.Errorf("cannot parse engine spec: %w", err)
+	}
+	if len(spec.Steps) == 0 {
+		return nil, fmt.Errorf("engine spec has no steps")
+	}
+	for i, step := range spec.Steps {
+		if step.Name == "" {
+			return nil, fmt.Errorf("step %d has no name", i)
+		}
+		if len(step.Command) == 0 {
+			return nil, fmt.Errorf("step %s has no command", step.Name)
+		}
+	}
+	return &spec, nil
+}
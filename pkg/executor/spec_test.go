@@ -0,0 +1,69 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"testing"
+)
+
+func TestParseSpec(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		steps int
+		err   bool
+	}{
+		{
+			name: "valid",
+			input: `desc: build and test
+steps:
+- name: build
+  command: ["go", "build", "./..."]
+- name: test
+  command: ["go", "test", "./..."]
+  env:
+    CGO_ENABLED: "0"
+`,
+			steps: 2,
+		},
+		{name: "no steps", input: "desc: nothing", err: true},
+		{name: "unnamed step", input: "steps:\n- command: [true]", err: true},
+		{name: "no command", input: "steps:\n- name: empty", err: true},
+		{name: "unknown field", input: "steps:\n- name: a\n  command: [true]\n  image: alpine", err: true},
+		{name: "invalid yaml", input: "steps: [", err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := ParseSpec([]byte(tc.input))
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSpec: %v", err)
+			}
+			if len(spec.Steps) != tc.steps {
+				t.Fatalf("expected %d steps, got %d", tc.steps, len(spec.Steps))
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// untar unpacks a gzipped tar stream into dir. Entries must stay within
+// dir: absolute names, names with ".." elements and symlinks that may point
+// outside of dir are rejected, and so are entries below symlinks, device
+// files and the like.
+// Set-user-ID and similar permission bits are dropped.
+func untar(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("cannot read application: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read application: %w", err)
+		}
+
+		name, err := localName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			continue
+		}
+		// Symlinks are only checked against the names of the entries, so
+		// their parents must be real directories.
+		if err := checkParents(dir, name); err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dst, mode|0700)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(dst, tr, mode)
+		case tar.TypeSymlink:
+			if err := checkLinkname(name, hdr.Linkname); err != nil {
+				return fmt.Errorf("application entry %s links outside of the application", hdr.Name)
+			}
+			if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+				err = os.Symlink(hdr.Linkname, dst)
+			}
+		case tar.TypeLink:
+			var target string
+			if target, err = localName(hdr.Linkname); err != nil {
+				return err
+			}
+			if err := checkParents(dir, target); err != nil {
+				return err
+			}
+			if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+				err = os.Link(filepath.Join(dir, filepath.FromSlash(target)), dst)
+			}
+		case tar.TypeXGlobalHeader:
+		default:
+			return fmt.Errorf("application entry %s has unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot unpack application: %w", err)
+		}
+	}
+}
+
+// localName cleans a slash separated name, which must not leave the
+// directory it is relative to.
+func localName(name string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(name, `\`) {
+		return "", fmt.Errorf("application entry %s is outside of the application", name)
+	}
+	return clean, nil
+}
+
+// checkLinkname returns an error if the target of the named symlink may
+// resolve outside of the application. Only leading ".." elements, which
+// go up through the real parent directories of the symlink, are allowed.
+// Any other ".." element would go up from wherever the element before it
+// points to, which may be a symlink created before or after this one, as
+// with a -> . and b -> a/..
+func checkLinkname(name, linkname string) error {
+	if path.IsAbs(linkname) {
+		return fmt.Errorf("absolute link %s", linkname)
+	}
+	if _, err := localName(path.Join(path.Dir(name), linkname)); err != nil {
+		return err
+	}
+	var descended bool
+	for _, elem := range strings.Split(linkname, "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if descended {
+				return fmt.Errorf("link %s goes up after descending", linkname)
+			}
+		default:
+			descended = true
+		}
+	}
+	return nil
+}
+
+// checkParents returns an error if any parent of the named entry in dir
+// is a symlink.
+func checkParents(dir, name string) error {
+	parent := dir
+	elems := strings.Split(name, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		parent = filepath.Join(parent, elem)
+		fi, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("application entry %s is below a symlink", name)
+		}
+	}
+	return nil
+}
+
+func writeFile(dst string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	// Never write through an existing file, which may be a symlink.
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
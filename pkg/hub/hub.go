@@ -0,0 +1,193 @@
+package hub
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package hub distributes engine status updates to subscribers in process.
+//
+// Every subscriber has a bounded queue which holds at most one status per
+// engine: a newer status of an engine replaces the queued one in place.
+// Publishing never blocks. A subscriber whose queue is full of updates of
+// distinct engines is cut off with ErrOverflow, so that it can start over
+// from the store rather than miss updates silently.
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/store"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultQueueSize is the number of engines whose updates are queued for a
+// subscriber if Subscribe is called with a size of zero.
+const DefaultQueueSize = 100
+
+var (
+	// ErrOverflow is returned by Next once a subscriber fell too far
+	// behind.
+	ErrOverflow = errors.New("subscriber too slow, updates were dropped")
+
+	// ErrClosed is returned by Next once a subscription is closed.
+	ErrClosed = errors.New("subscription closed")
+)
+
+// Hub distributes engine status updates. The zero value is ready to use.
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// New returns a new Hub.
+func New() *Hub {
+	return &Hub{}
+}
+
+// Publish hands a status update to all subscribers whose filter it
+// matches.
+func (h *Hub) Publish(status *v1.EngineStatus) {
+	// Subscribers share the status, it must not change once published.
+	status = proto.Clone(status).(*v1.EngineStatus)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		if sub.filter == nil || sub.filter.Match(status) {
+			sub.push(status)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the updates matching filter, or all
+// updates if filter is nil. Updates of at most size engines are queued.
+func (h *Hub) Subscribe(filter *store.Filter, size int) *Subscription {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	sub := &Subscription{
+		hub:    h,
+		filter: filter,
+		size:   size,
+		ready:  make(chan struct{}, 1),
+		latest: map[string]*v1.EngineStatus{},
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = map[*Subscription]struct{}{}
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+// Len returns the number of subscriptions.
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
+
+// Subscription receives the status updates published on a hub.
+type Subscription struct {
+	hub    *Hub
+	filter *store.Filter
+	size   int
+	ready  chan struct{}
+
+	mu      sync.Mutex
+	pending []string
+	latest  map[string]*v1.EngineStatus
+	err     error
+}
+
+// push queues a status, replacing any queued status of the same engine.
+func (s *Subscription) push(status *v1.EngineStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if _, queued := s.latest[status.Name]; !queued {
+		if len(s.pending) >= s.size {
+			s.fail(ErrOverflow)
+			return
+		}
+		s.pending = append(s.pending, status.Name)
+	}
+	s.latest[status.Name] = status
+	s.signal()
+}
+
+// fail drops the queued updates and makes Next return err.
+func (s *Subscription) fail(err error) {
+	s.err = err
+	s.pending = nil
+	s.latest = nil
+	s.signal()
+}
+
+func (s *Subscription) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Next returns the next update, blocking until there is one, the context
+// is done or the subscription failed.
+func (s *Subscription) Next(ctx context.Context) (*v1.EngineStatus, error) {
+	for {
+		s.mu.Lock()
+		if len(s.pending) > 0 {
+			name := s.pending[0]
+			s.pending = s.pending[1:]
+			status := s.latest[name]
+			delete(s.latest, name)
+			s.mu.Unlock()
+			return status, nil
+		}
+		err := s.err
+		s.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close ends the subscription. Updates still queued are dropped.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subs, s)
+	s.hub.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.fail(ErrClosed)
+	}
+}
@@ -0,0 +1,142 @@
+package hub
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/store"
+)
+
+func status(name string, phase v1.EnginePhase) *v1.EngineStatus {
+	return &v1.EngineStatus{Name: name, Phase: phase}
+}
+
+func TestCoalescing(t *testing.T) {
+	h := New()
+	sub := h.Subscribe(nil, 0)
+	defer sub.Close()
+
+	h.Publish(status("a.1", v1.EnginePhase_PHASE_PREPARING))
+	h.Publish(status("b.1", v1.EnginePhase_PHASE_PREPARING))
+	h.Publish(status("a.1", v1.EnginePhase_PHASE_RUNNING))
+	h.Publish(status("a.1", v1.EnginePhase_PHASE_DONE))
+
+	ctx := context.Background()
+	expected := []*v1.EngineStatus{
+		status("a.1", v1.EnginePhase_PHASE_DONE),
+		status("b.1", v1.EnginePhase_PHASE_PREPARING),
+	}
+	for _, e := range expected {
+		st, err := sub.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if st.Name != e.Name || st.Phase != e.Phase {
+			t.Fatalf("unexpected update %s %v, expected %s %v", st.Name, st.Phase, e.Name, e.Phase)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := sub.Next(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected no further update, got %v", err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	filter, err := store.NewFilter([]*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "phase", Value: "done"}}}})
+	if err != nil {
+		t.Fatalf("NewFilter: %v", err)
+	}
+	h := New()
+	sub := h.Subscribe(filter, 0)
+	defer sub.Close()
+
+	h.Publish(status("a.1", v1.EnginePhase_PHASE_RUNNING))
+	h.Publish(status("b.1", v1.EnginePhase_PHASE_DONE))
+	st, err := sub.Next(context.Background())
+	if err != nil || st.Name != "b.1" {
+		t.Fatalf("unexpected update: %v, %v", st, err)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	h := New()
+	slow := h.Subscribe(nil, 3)
+	defer slow.Close()
+	fast := h.Subscribe(nil, 0)
+	defer fast.Close()
+
+	// Publishing must not block on the slow subscriber.
+	for i := 0; i < 4; i++ {
+		h.Publish(status(fmt.Sprintf("e.%d", i), v1.EnginePhase_PHASE_RUNNING))
+	}
+	if _, err := slow.Next(context.Background()); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expected ErrOverflow, got %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := fast.Next(context.Background()); err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	h := New()
+	sub := h.Subscribe(nil, 0)
+	done := make(chan error)
+	go func() {
+		_, err := sub.Next(context.Background())
+		done <- err
+	}()
+	sub.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Next did not return after Close")
+	}
+	if h.Len() != 0 {
+		t.Fatalf("subscription not removed from hub")
+	}
+	h.Publish(status("a.1", v1.EnginePhase_PHASE_DONE))
+}
+
+func TestPublishDoesNotShareStatus(t *testing.T) {
+	h := New()
+	sub := h.Subscribe(nil, 0)
+	defer sub.Close()
+	st := status("a.1", v1.EnginePhase_PHASE_RUNNING)
+	h.Publish(st)
+	st.Phase = v1.EnginePhase_PHASE_DONE
+	got, err := sub.Next(context.Background())
+	if err != nil || got.Phase != v1.EnginePhase_PHASE_RUNNING {
+		t.Fatalf("published status changed: %v, %v", got, err)
+	}
+}
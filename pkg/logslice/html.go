@@ -0,0 +1,204 @@
+package logslice
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// HTML converts lines of terminal output into HTML. Text is escaped, SGR
+// escape sequences for bold, faint, italic, underline and the 16 basic
+// colours become spans with CSS classes, and all other escape sequences
+// are dropped:
+//
+//	term-bold, term-faint, term-italic, term-underline
+//	term-fg30 ... term-fg37, term-fg90 ... term-fg97
+//	term-bg40 ... term-bg47, term-bg100 ... term-bg107
+//
+// Styles carry over from one line to the next, but every line is balanced
+// HTML on its own. The zero value is ready to use.
+type HTML struct {
+	bold, faint, italic, underline bool
+	fg, bg                         int
+}
+
+// Line converts a line of output.
+func (h *HTML) Line(line string) string {
+	var (
+		res  strings.Builder
+		open = h.openSpan(&res)
+	)
+	for len(line) > 0 {
+		i := strings.IndexByte(line, '\x1b')
+		if i < 0 {
+			res.WriteString(html.EscapeString(line))
+			break
+		}
+		res.WriteString(html.EscapeString(line[:i]))
+		seq, params, final := parseEscape(line[i:])
+		line = line[i+seq:]
+		if final != 'm' {
+			continue
+		}
+		if open {
+			res.WriteString("</span>")
+		}
+		h.apply(params)
+		open = h.openSpan(&res)
+	}
+	if open {
+		res.WriteString("</span>")
+	}
+	return res.String()
+}
+
+// openSpan opens a span with the current style, if there is any.
+func (h *HTML) openSpan(w *strings.Builder) bool {
+	var classes []string
+	if h.bold {
+		classes = append(classes, "term-bold")
+	}
+	if h.faint {
+		classes = append(classes, "term-faint")
+	}
+	if h.italic {
+		classes = append(classes, "term-italic")
+	}
+	if h.underline {
+		classes = append(classes, "term-underline")
+	}
+	if h.fg != 0 {
+		classes = append(classes, "term-fg"+strconv.Itoa(h.fg))
+	}
+	if h.bg != 0 {
+		classes = append(classes, "term-bg"+strconv.Itoa(h.bg))
+	}
+	if len(classes) == 0 {
+		return false
+	}
+	w.WriteString(`<span class="` + strings.Join(classes, " ") + `">`)
+	return true
+}
+
+// apply applies the parameters of an SGR sequence.
+func (h *HTML) apply(params []int) {
+	if len(params) == 0 {
+		params = []int{0}
+	}
+	for i := 0; i < len(params); i++ {
+		switch p := params[i]; {
+		case p == 0:
+			*h = HTML{}
+		case p == 1:
+			h.bold = true
+		case p == 2:
+			h.faint = true
+		case p == 3:
+			h.italic = true
+		case p == 4:
+			h.underline = true
+		case p == 22:
+			h.bold, h.faint = false, false
+		case p == 23:
+			h.italic = false
+		case p == 24:
+			h.underline = false
+		case 30 <= p && p <= 37, 90 <= p && p <= 97:
+			h.fg = p
+		case p == 39:
+			h.fg = 0
+		case 40 <= p && p <= 47, 100 <= p && p <= 107:
+			h.bg = p
+		case p == 49:
+			h.bg = 0
+		case p == 38, p == 48:
+			// Extended colours are not supported, skip their arguments.
+			if i+1 < len(params) && params[i+1] == 5 {
+				i += 2
+			} else if i+1 < len(params) && params[i+1] == 2 {
+				i += 4
+			}
+		}
+	}
+}
+
+// parseEscape parses the escape sequence at the start of s, returning its
+// length, and its parameters and final byte if it is a CSI sequence. An
+// incomplete sequence extends to the end of s.
+func parseEscape(s string) (n int, params []int, final byte) {
+	if len(s) < 2 {
+		return len(s), nil, 0
+	}
+	switch {
+	case s[1] == ']':
+		// Operating system commands, such as window titles, end with BEL
+		// or ST.
+		end := len(s)
+		if i := strings.IndexByte(s, '\a'); i >= 0 {
+			end = i + 1
+		}
+		if i := strings.Index(s, "\x1b\\"); i >= 0 && i+2 < end {
+			end = i + 2
+		}
+		return end, nil, 0
+	case s[1] != '[':
+		// Other sequences are intermediate bytes followed by a final byte,
+		// such as character set selection.
+		n = 1
+		for n < len(s) && 0x20 <= s[n] && s[n] <= 0x2f {
+			n++
+		}
+		if n < len(s) {
+			n++
+		}
+		return n, nil, 0
+	}
+	var (
+		cur    int
+		hasCur bool
+	)
+	for n = 2; n < len(s); n++ {
+		c := s[n]
+		switch {
+		case '0' <= c && c <= '9':
+			if cur < 1<<16 {
+				cur = cur*10 + int(c-'0')
+			}
+			hasCur = true
+		case c == ';':
+			params = append(params, cur)
+			cur, hasCur = 0, false
+		case 0x40 <= c && c <= 0x7e:
+			if hasCur || len(params) > 0 {
+				params = append(params, cur)
+			}
+			return n + 1, params, c
+		case 0x20 <= c && c <= 0x3f:
+			// Private and intermediate bytes.
+		default:
+			// Not a valid CSI sequence, drop what was read so far.
+			return n, nil, 0
+		}
+	}
+	return len(s), nil, 0
+}
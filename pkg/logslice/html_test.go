@@ -0,0 +1,59 @@
+package logslice
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import "testing"
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Lines    []string
+		Expected []string
+	}{
+		{"plain", []string{"a < b && c"}, []string{"a &lt; b &amp;&amp; c"}},
+		{"escaped markup", []string{"\x1b[31m<script>alert(1)</script>"}, []string{`<span class="term-fg31">&lt;script&gt;alert(1)&lt;/script&gt;</span>`}},
+		{"reset", []string{"\x1b[1;32mok\x1b[0m done"}, []string{`<span class="term-bold term-fg32">ok</span> done`}},
+		{"empty reset", []string{"\x1b[4mu\x1b[m"}, []string{`<span class="term-underline">u</span>`}},
+		{"carry over", []string{"\x1b[44mblue", "still\x1b[49m"}, []string{
+			`<span class="term-bg44">blue</span>`,
+			`<span class="term-bg44">still</span>`,
+		}},
+		{"partial reset", []string{"\x1b[1;91mx\x1b[39my\x1b[22mz"}, []string{
+			`<span class="term-bold term-fg91">x</span><span class="term-bold">y</span>z`,
+		}},
+		{"extended colours", []string{"\x1b[38;5;196;1mx\x1b[48;2;1;2;3;4my"}, []string{
+			`<span class="term-bold">x</span><span class="term-bold term-underline">y</span>`,
+		}},
+		{"other sequences", []string{"\x1b[2K\x1b[?25lprogress\x1b]0;title\a\x1b(B!"}, []string{"progress!"}},
+		{"string terminator", []string{"\x1b]8;;http://x\x1b\\link"}, []string{"link"}},
+		{"incomplete", []string{"text\x1b[3"}, []string{"text"}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var h HTML
+			for i, line := range test.Lines {
+				if actual := h.Line(line); actual != test.Expected[i] {
+					t.Fatalf("unexpected HTML for %q: %s, expected %s", line, actual, test.Expected[i])
+				}
+			}
+		})
+	}
+}
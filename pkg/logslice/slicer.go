@@ -0,0 +1,168 @@
+package logslice
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package logslice splits the output of engines into named slices.
+//
+// Engines mark their output in band, one marker per line:
+//
+//	[<slice>|START] <description>
+//	[<slice>] <content>
+//	[<slice>|DONE]
+//	[<slice>|FAIL] <reason>
+//	[<slice>|RESULT] <payload>
+//	[<phase>|PHASE] <description>
+//
+// A slice starts implicitly with its first content line if it was not
+// started explicitly. Lines without a marker are content of the unnamed
+// slice. Slices still open when the output ends are abandoned.
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+)
+
+// markerRegexp matches the marker at the start of a line.
+var markerRegexp = regexp.MustCompile(`^\[([a-zA-Z0-9._/-]*)(?:\|(START|DONE|FAIL|RESULT|PHASE))?\] ?`)
+
+var markerTypes = map[string]v1.LogSliceType{
+	"":       v1.LogSliceType_SLICE_CONTENT,
+	"START":  v1.LogSliceType_SLICE_START,
+	"DONE":   v1.LogSliceType_SLICE_DONE,
+	"FAIL":   v1.LogSliceType_SLICE_FAIL,
+	"RESULT": v1.LogSliceType_SLICE_RESULT,
+	"PHASE":  v1.LogSliceType_SLICE_PHASE,
+}
+
+// Slicer turns lines of output into slice events. It keeps track of the
+// slices which are open.
+type Slicer struct {
+	open []string
+}
+
+// Line returns the events of a line of output, without its line ending.
+func (s *Slicer) Line(line string) []*v1.LogSliceEvent {
+	m := markerRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return []*v1.LogSliceEvent{{Type: v1.LogSliceType_SLICE_CONTENT, Payload: line}}
+	}
+	name, tpe, payload := m[1], markerTypes[m[2]], line[len(m[0]):]
+
+	var res []*v1.LogSliceEvent
+	switch tpe {
+	case v1.LogSliceType_SLICE_START:
+		if !s.isOpen(name) {
+			s.open = append(s.open, name)
+		}
+	case v1.LogSliceType_SLICE_CONTENT:
+		if name != "" && !s.isOpen(name) {
+			s.open = append(s.open, name)
+			res = append(res, &v1.LogSliceEvent{Name: name, Type: v1.LogSliceType_SLICE_START})
+		}
+	case v1.LogSliceType_SLICE_DONE, v1.LogSliceType_SLICE_FAIL:
+		s.close(name)
+	}
+	return append(res, &v1.LogSliceEvent{Name: name, Type: tpe, Payload: payload})
+}
+
+// Close returns an abandoned event for each slice left open, in the order
+// they were started.
+func (s *Slicer) Close() []*v1.LogSliceEvent {
+	res := make([]*v1.LogSliceEvent, 0, len(s.open))
+	for _, name := range s.open {
+		res = append(res, &v1.LogSliceEvent{Name: name, Type: v1.LogSliceType_SLICE_ABANDONED})
+	}
+	s.open = nil
+	return res
+}
+
+func (s *Slicer) isOpen(name string) bool {
+	for _, n := range s.open {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Slicer) close(name string) {
+	for i, n := range s.open {
+		if n == name {
+			s.open = append(s.open[:i], s.open[i+1:]...)
+			return
+		}
+	}
+}
+
+// Read reads output from r until it ends and passes the events of each
+// line to emit, according to mode:
+//
+//   - LOGS_UNSLICED: every line is content of the unnamed slice, markers
+//     are passed through as they are.
+//   - LOGS_RAW: lines are sliced and payloads passed through as they are.
+//   - LOGS_HTML: lines are sliced and ANSI escape sequences in payloads
+//     converted to HTML, see HTML.
+//
+// If the output ends with slices left open, they are abandoned.
+func Read(r io.Reader, mode v1.ListenRequestLogs, emit func(*v1.LogSliceEvent) error) error {
+	var (
+		slicer Slicer
+		html   HTML
+		br     = bufio.NewReader(r)
+	)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			var evts []*v1.LogSliceEvent
+			switch mode {
+			case v1.ListenRequestLogs_LOGS_UNSLICED:
+				evts = []*v1.LogSliceEvent{{Type: v1.LogSliceType_SLICE_CONTENT, Payload: line}}
+			default:
+				evts = slicer.Line(line)
+			}
+			for _, evt := range evts {
+				if mode == v1.ListenRequestLogs_LOGS_HTML {
+					evt.Payload = html.Line(evt.Payload)
+				}
+				if err := emit(evt); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, evt := range slicer.Close() {
+		if err := emit(evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
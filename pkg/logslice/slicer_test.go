@@ -0,0 +1,129 @@
+package logslice
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+)
+
+func TestRead(t *testing.T) {
+	const output = "setting up\n" +
+		"[build|START] Building\n" +
+		"[build] compiling \x1b[1mmain\x1b[0m\n" +
+		"[build|RESULT] bin/crypto\n" +
+		"[build|DONE]\n" +
+		"[test] ok\n" +
+		"[lint|START]\n" +
+		"[test|FAIL] 1 test failed\r\n" +
+		"[deploy|PHASE] Deploying\n" +
+		"[not a marker] <b>"
+
+	tests := []struct {
+		Mode     v1.ListenRequestLogs
+		Expected []string
+	}{
+		{
+			Mode: v1.ListenRequestLogs_LOGS_UNSLICED,
+			Expected: []string{
+				"SLICE_CONTENT  setting up",
+				"SLICE_CONTENT  [build|START] Building",
+				"SLICE_CONTENT  [build] compiling \x1b[1mmain\x1b[0m",
+				"SLICE_CONTENT  [build|RESULT] bin/crypto",
+				"SLICE_CONTENT  [build|DONE]",
+				"SLICE_CONTENT  [test] ok",
+				"SLICE_CONTENT  [lint|START]",
+				"SLICE_CONTENT  [test|FAIL] 1 test failed",
+				"SLICE_CONTENT  [deploy|PHASE] Deploying",
+				"SLICE_CONTENT  [not a marker] <b>",
+			},
+		},
+		{
+			Mode: v1.ListenRequestLogs_LOGS_RAW,
+			Expected: []string{
+				"SLICE_CONTENT  setting up",
+				"SLICE_START build Building",
+				"SLICE_CONTENT build compiling \x1b[1mmain\x1b[0m",
+				"SLICE_RESULT build bin/crypto",
+				"SLICE_DONE build ",
+				"SLICE_START test ",
+				"SLICE_CONTENT test ok",
+				"SLICE_START lint ",
+				"SLICE_FAIL test 1 test failed",
+				"SLICE_PHASE deploy Deploying",
+				"SLICE_CONTENT  [not a marker] <b>",
+				"SLICE_ABANDONED lint ",
+			},
+		},
+		{
+			Mode: v1.ListenRequestLogs_LOGS_HTML,
+			Expected: []string{
+				"SLICE_CONTENT  setting up",
+				"SLICE_START build Building",
+				`SLICE_CONTENT build compiling <span class="term-bold">main</span>`,
+				"SLICE_RESULT build bin/crypto",
+				"SLICE_DONE build ",
+				"SLICE_START test ",
+				"SLICE_CONTENT test ok",
+				"SLICE_START lint ",
+				"SLICE_FAIL test 1 test failed",
+				"SLICE_PHASE deploy Deploying",
+				"SLICE_CONTENT  [not a marker] &lt;b&gt;",
+				"SLICE_ABANDONED lint ",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Mode.String(), func(t *testing.T) {
+			var events []string
+			err := Read(strings.NewReader(output), test.Mode, func(evt *v1.LogSliceEvent) error {
+				events = append(events, evt.Type.String()+" "+evt.Name+" "+evt.Payload)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if len(events) != len(test.Expected) {
+				t.Fatalf("unexpected events:\n%s", strings.Join(events, "\n"))
+			}
+			for i := range events {
+				if events[i] != test.Expected[i] {
+					t.Fatalf("unexpected event %d: %q, expected %q", i, events[i], test.Expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadEmitError(t *testing.T) {
+	expected := errors.New("gone")
+	var n int
+	err := Read(strings.NewReader("a\nb\n"), v1.ListenRequestLogs_LOGS_RAW, func(*v1.LogSliceEvent) error {
+		n++
+		return expected
+	})
+	if err != expected || n != 1 {
+		t.Fatalf("unexpected result: %v after %d events", err, n)
+	}
+}
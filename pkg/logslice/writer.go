@@ -0,0 +1,105 @@
+package logslice
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+)
+
+// Writer writes output as content of a named slice, prefixing each line
+// with the marker of the slice. Lines which start with a marker other than
+// a content marker are written as they are, so that programs can start,
+// end and report results of their own slices.
+type Writer struct {
+	w    io.Writer
+	name string
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewWriter returns a Writer writing the content of the named slice to w.
+func NewWriter(w io.Writer, name string) *Writer {
+	return &Writer{w: w, name: name}
+}
+
+// Write implements io.Writer. Incomplete lines are held back until they
+// are completed or the Writer is closed.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := w.writeLine(w.buf[:i+1]); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// Close writes an incomplete last line, if there is one. It does not
+// close the underlying writer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := append(w.buf, '\n')
+	w.buf = nil
+	return w.writeLine(line)
+}
+
+func (w *Writer) writeLine(line []byte) error {
+	if m := markerRegexp.FindSubmatch(line); m == nil || len(m[2]) == 0 {
+		line = append([]byte("["+w.name+"] "), line...)
+	}
+	_, err := w.w.Write(line)
+	return err
+}
+
+// Mark writes a marker line to w.
+func Mark(w io.Writer, name string, tpe v1.LogSliceType, payload string) error {
+	var marker string
+	switch tpe {
+	case v1.LogSliceType_SLICE_CONTENT:
+		marker = "[" + name + "]"
+	case v1.LogSliceType_SLICE_START, v1.LogSliceType_SLICE_DONE, v1.LogSliceType_SLICE_FAIL,
+		v1.LogSliceType_SLICE_RESULT, v1.LogSliceType_SLICE_PHASE:
+		marker = "[" + name + "|" + tpe.String()[len("SLICE_"):] + "]"
+	default:
+		return fmt.Errorf("cannot mark %v", tpe)
+	}
+	if payload != "" {
+		marker += " " + payload
+	}
+	_, err := io.WriteString(w, marker+"\n")
+	return err
+}
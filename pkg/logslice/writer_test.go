@@ -0,0 +1,56 @@
+package logslice
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, "build")
+	io.WriteString(w, "compil")
+	io.WriteString(w, "ing\n[INFO] done\n[tests|START]\n")
+	io.WriteString(w, "[tests|RESULT] 3 passed\nno newline")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	Mark(&buf, "build", v1.LogSliceType_SLICE_DONE, "")
+	Mark(&buf, "running", v1.LogSliceType_SLICE_PHASE, "Running steps")
+
+	const expected = "[build] compiling\n" +
+		"[build] [INFO] done\n" +
+		"[tests|START]\n" +
+		"[tests|RESULT] 3 passed\n" +
+		"[build] no newline\n" +
+		"[build|DONE]\n" +
+		"[running|PHASE] Running steps\n"
+	if buf.String() != expected {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+	if err := Mark(&buf, "x", v1.LogSliceType_SLICE_ABANDONED, ""); err == nil {
+		t.Fatalf("expected abandoned slices not to be marked")
+	}
+}
@@ -0,0 +1,152 @@
+package resumable
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"io"
+	"sort"
+)
+
+// Checkpoint is a snapshot of the state of a Hash taken after Offset bytes
+// have been written to it.
+type Checkpoint struct {
+	Offset int64
+	State  []byte
+}
+
+// Checkpointer wraps a Hash and snapshots its state every interval bytes
+// into an index of checkpoints. This allows the hash to be rewound to an
+// earlier offset without rehashing the content from the beginning.
+type Checkpointer struct {
+	hash        Hash
+	interval    int64
+	checkpoints []Checkpoint
+}
+
+// NewCheckpointer returns a Checkpointer writing to h, taking a checkpoint
+// every interval bytes. The current state of h is recorded as the first
+// checkpoint, so h may already contain data.
+func NewCheckpointer(h Hash, interval int64) (*Checkpointer, error) {
+	if interval <= 0 {
+		return nil, ErrBadInterval
+	}
+	c := &Checkpointer{
+		hash:     h,
+		interval: interval,
+	}
+	if err := c.checkpoint(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Write writes p to the underlying Hash, taking a checkpoint whenever the
+// number of bytes written crosses a multiple of the interval.
+func (c *Checkpointer) Write(p []byte) (nn int, err error) {
+	for len(p) > 0 {
+		n := c.interval - c.hash.Len()%c.interval
+		if n > int64(len(p)) {
+			n = int64(len(p))
+		}
+		// Writes to a Hash never return an error.
+		c.hash.Write(p[:n])
+		nn += int(n)
+		p = p[n:]
+
+		if c.hash.Len()%c.interval == 0 {
+			if err := c.checkpoint(); err != nil {
+				return nn, err
+			}
+		}
+	}
+	return nn, nil
+}
+
+// Hash returns the underlying Hash. Data written directly to it bypasses
+// checkpointing.
+func (c *Checkpointer) Hash() Hash {
+	return c.hash
+}
+
+// Len returns the number of bytes written to the underlying Hash so far.
+func (c *Checkpointer) Len() int64 {
+	return c.hash.Len()
+}
+
+// Checkpoints returns the index of checkpoints, ordered by offset.
+func (c *Checkpointer) Checkpoints() []Checkpoint {
+	checkpoints := make([]Checkpoint, len(c.checkpoints))
+	copy(checkpoints, c.checkpoints)
+	return checkpoints
+}
+
+// RewindTo resets the Hash to the state it had after offset bytes were
+// written. The nearest checkpoint at or before offset is restored and the
+// gap up to offset is re-hashed from r, which must provide the same content
+// that was originally written. Checkpoints after offset are discarded. If
+// the gap cannot be read completely the Hash is left at an offset between
+// the checkpoint and offset.
+func (c *Checkpointer) RewindTo(offset int64, r io.ReaderAt) error {
+	if offset < 0 || offset > c.hash.Len() {
+		return ErrBadOffset
+	}
+
+	idx := sort.Search(len(c.checkpoints), func(i int) bool {
+		return c.checkpoints[i].Offset > offset
+	})
+	if idx == 0 {
+		// The first checkpoint is taken at creation time, rewinding before
+		// it is impossible.
+		return ErrBadOffset
+	}
+	cp := c.checkpoints[idx-1]
+	if err := c.hash.Restore(cp.State); err != nil {
+		return err
+	}
+	c.checkpoints = c.checkpoints[:idx]
+
+	gap := offset - cp.Offset
+	if gap == 0 {
+		return nil
+	}
+	n, err := io.Copy(c, io.NewSectionReader(r, cp.Offset, gap))
+	if err != nil {
+		return err
+	}
+	if n != gap {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func (c *Checkpointer) checkpoint() error {
+	state, err := c.hash.State()
+	if err != nil {
+		return err
+	}
+	offset := c.hash.Len()
+	if n := len(c.checkpoints); n > 0 && c.checkpoints[n-1].Offset == offset {
+		c.checkpoints[n-1].State = state
+		return nil
+	}
+	c.checkpoints = append(c.checkpoints, Checkpoint{Offset: offset, State: state})
+	return nil
+}
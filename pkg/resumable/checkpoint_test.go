@@ -0,0 +1,96 @@
+package resumable_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"crypto/rand"
+	stdsha256 "crypto/sha256"
+	"io"
+	"testing"
+
+	"github.com/bhojpur/crypto/pkg/resumable"
+	"github.com/bhojpur/crypto/pkg/resumable/sha256"
+)
+
+func TestCheckpointerRewind(t *testing.T) {
+	buf := make([]byte, 10*1024)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		t.Fatalf("unable to load random data: %s", err)
+	}
+
+	c, err := resumable.NewCheckpointer(sha256.New().(resumable.Hash), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in odd sized pieces so that checkpoints fall in the middle of
+	// writes.
+	for p := buf; len(p) > 0; {
+		n := 333
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := c.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+
+	checkpoints := c.Checkpoints()
+	if len(checkpoints) != 11 {
+		t.Fatalf("unexpected number of checkpoints: %d != 11", len(checkpoints))
+	}
+	for i, cp := range checkpoints {
+		if cp.Offset != int64(i*1000) {
+			t.Fatalf("unexpected checkpoint offset at %d: %d", i, cp.Offset)
+		}
+	}
+
+	r := bytes.NewReader(buf)
+	for _, offset := range []int64{8000, 4321, 4321, 999, 0} {
+		if err := c.RewindTo(offset, r); err != nil {
+			t.Fatalf("unable to rewind to %d: %s", offset, err)
+		}
+		if c.Len() != offset {
+			t.Fatalf("unexpected length after rewind: %d != %d", c.Len(), offset)
+		}
+		expected := stdsha256.Sum256(buf[:offset])
+		if !bytes.Equal(c.Hash().Sum(nil), expected[:]) {
+			t.Fatalf("digests do not match after rewind to %d", offset)
+		}
+	}
+
+	// Writing the remainder after a rewind yields the full digest again.
+	if _, err := c.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := stdsha256.Sum256(buf)
+	if !bytes.Equal(c.Hash().Sum(nil), expected[:]) {
+		t.Fatalf("digests do not match after rewrite")
+	}
+
+	if err := c.RewindTo(int64(len(buf))+1, r); err != resumable.ErrBadOffset {
+		t.Fatalf("unexpected error rewinding past the end: %v", err)
+	}
+	if err := c.RewindTo(5500, bytes.NewReader(buf[:5200])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected error rewinding with short reader: %v", err)
+	}
+}
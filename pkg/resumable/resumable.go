@@ -42,6 +42,11 @@ package resumable
 // application can be completely oblivious to the presence of the alternative
 // hash functions.
 //
+// The sub-packages also register themselves with the digest package, so that
+// a resumable digester can be obtained without relying on import order:
+//
+// 	d, err := digest.SHA256.ResumableDigester()
+//
 // Also note that the implementations available in this package are completely
 // untouched from their Go counterparts in the standard library. Only an extra
 // file is added to each package to implement the extra resumable hash
@@ -55,6 +60,14 @@ import (
 var (
 	// ErrBadState is returned if Restore fails post-unmarshaling validation.
 	ErrBadState = fmt.Errorf("bad hash state")
+
+	// ErrBadInterval is returned if a Checkpointer is created with a
+	// non-positive interval.
+	ErrBadInterval = fmt.Errorf("bad checkpoint interval")
+
+	// ErrBadOffset is returned if a Checkpointer cannot be rewound to the
+	// requested offset.
+	ErrBadOffset = fmt.Errorf("bad checkpoint offset")
 )
 
 // Hash is the common interface implemented by all resumable hash functions.
@@ -70,3 +83,11 @@ type Hash interface {
 	// Restore resets the Hash to the given state.
 	Restore(state []byte) error
 }
+
+// Cloner is implemented by resumable hash functions that can fork their state
+// cheaply, without a round-trip through State and Restore.
+type Cloner interface {
+	// Clone returns an independent copy of the Hash. Writes to the copy do
+	// not affect the original and vice versa.
+	Clone() Hash
+}
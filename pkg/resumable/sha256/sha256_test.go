@@ -7,6 +7,7 @@
 package sha256
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
 	"io"
@@ -187,3 +188,34 @@ func BenchmarkHash1K(b *testing.B) {
 func BenchmarkHash8K(b *testing.B) {
 	benchmarkSize(b, 8192)
 }
+
+func TestSumInto(t *testing.T) {
+	dst := make([]byte, Size)
+	for _, size := range []int{0, 8, 1024, 8192} {
+		SumInto(dst, buf[:size])
+		expected := Sum256(buf[:size])
+		if !bytes.Equal(dst, expected[:]) {
+			t.Fatalf("SumInto(%d bytes) = %x want %x", size, dst, expected)
+		}
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { SumInto(dst, buf[:64]) }); allocs != 0 {
+		t.Fatalf("SumInto allocated %v times, want 0", allocs)
+	}
+}
+
+func benchmarkSumInto(b *testing.B, size int) {
+	b.SetBytes(int64(size))
+	sum := make([]byte, Size)
+	for i := 0; i < b.N; i++ {
+		SumInto(sum, buf[:size])
+	}
+}
+
+func BenchmarkSumInto8Bytes(b *testing.B) {
+	benchmarkSumInto(b, 8)
+}
+
+func BenchmarkSumInto1K(b *testing.B) {
+	benchmarkSumInto(b, 1024)
+}
@@ -280,4 +280,4 @@ loop:
 	JB	loop
 
 end:
-	RET
\ No newline at end of file
+	RET
@@ -559,11 +559,8 @@
 	ADDL  y3, h                        // h = t1 + S0 + MAJ					// --
 
 TEXT ·block(SB), 0, $536-32
-	CMPB runtime·support_avx2(SB), $0
-	JE   noavx2bmi2
-	CMPB runtime·support_bmi2(SB), $1  // check for RORXL instruction
+	CMPB ·useAVX2(SB), $1 // check for AVX2 and the RORXL instruction
 	JE   avx2
-noavx2bmi2:
 
 	MOVQ p_base+8(FP), SI
 	MOVQ p_len+16(FP), DX
@@ -1041,4 +1038,4 @@ DATA K256<>+0x1f4(SB)/4, $0xa4506ceb
 DATA K256<>+0x1f8(SB)/4, $0xbef9a3f7
 DATA K256<>+0x1fc(SB)/4, $0xc67178f2
 
-GLOBL K256<>(SB), (NOPTR + RODATA), $512
\ No newline at end of file
+GLOBL K256<>(SB), (NOPTR + RODATA), $512
@@ -29,6 +29,7 @@ import (
 	"io"
 	"testing"
 
+	pkgdigest "github.com/bhojpur/crypto/pkg/digest"
 	"github.com/bhojpur/crypto/pkg/resumable"
 )
 
@@ -92,3 +93,75 @@ func TestResumableRegistered(t *testing.T) {
 	}
 
 }
+
+func TestResumableDigester(t *testing.T) {
+	p := make([]byte, 3*1024)
+	if _, err := io.ReadFull(rand.Reader, p); err != nil {
+		t.Fatalf("unable to load random data: %s", err)
+	}
+
+	for _, alg := range []pkgdigest.Algorithm{pkgdigest.SHA224, pkgdigest.SHA256} {
+		first, err := alg.ResumableDigester()
+		if err != nil {
+			t.Fatalf("unable to get resumable digester for %s: %s", alg, err)
+		}
+		first.Hash().Write(p[:1000])
+		state, err := first.State()
+		if err != nil {
+			t.Fatalf("unable to get state of digester: %s", err)
+		}
+
+		second, err := alg.ResumableDigester()
+		if err != nil {
+			t.Fatalf("unable to get resumable digester for %s: %s", alg, err)
+		}
+		if err := second.Restore(state); err != nil {
+			t.Fatalf("unable to restore state of digester: %s", err)
+		}
+		if second.Len() != 1000 {
+			t.Fatalf("unexpected length after restore: %d != 1000", second.Len())
+		}
+		second.Hash().Write(p[1000:])
+
+		if second.Digest() != alg.FromBytes(p) {
+			t.Fatalf("digests do not match: got %s, expected %s", second.Digest(), alg.FromBytes(p))
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	h := New().(resumable.Cloner)
+	orig := h.(hash.Hash)
+	io.WriteString(orig, "shared prefix")
+
+	clone := h.Clone()
+	io.WriteString(clone, " and a fork")
+	io.WriteString(orig, " and the original")
+
+	if expected := sha256.Sum256([]byte("shared prefix and a fork")); !bytes.Equal(clone.Sum(nil), expected[:]) {
+		t.Fatalf("unexpected clone digest: %x != %x", clone.Sum(nil), expected)
+	}
+	if expected := sha256.Sum256([]byte("shared prefix and the original")); !bytes.Equal(orig.Sum(nil), expected[:]) {
+		t.Fatalf("unexpected original digest: %x != %x", orig.Sum(nil), expected)
+	}
+}
+
+func TestPrefixDigesterFork(t *testing.T) {
+	d := pkgdigest.SHA256.PrefixDigester()
+	io.WriteString(d.Hash(), "layer one")
+	d.Mark()
+
+	fork, err := d.Fork()
+	if err != nil {
+		t.Fatalf("unable to fork prefix digester: %s", err)
+	}
+	io.WriteString(fork.Hash(), " and a fork")
+	io.WriteString(d.Hash(), " and layer two")
+
+	if expected := pkgdigest.SHA256.FromString("layer one and a fork"); fork.Digest() != expected {
+		t.Fatalf("unexpected fork digest: %s != %s", fork.Digest(), expected)
+	}
+	if expected := pkgdigest.SHA256.FromString("layer one and layer two"); d.Digest() != expected {
+		t.Fatalf("unexpected digest: %s != %s", d.Digest(), expected)
+	}
+}
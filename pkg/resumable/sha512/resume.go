@@ -25,17 +25,31 @@ import (
 	"crypto"
 	"encoding/gob"
 
+	pkgdigest "github.com/bhojpur/crypto/pkg/digest"
 	"github.com/bhojpur/crypto/pkg/resumable"
 
 	// import to ensure that our init function runs after the standard package
 	_ "crypto/sha512"
 )
 
+func init() {
+	pkgdigest.RegisterResumable(pkgdigest.SHA384, func() resumable.Hash { return New384().(*digest) })
+	pkgdigest.RegisterResumable(pkgdigest.SHA512, func() resumable.Hash { return New().(*digest) })
+	pkgdigest.RegisterResumable(pkgdigest.SHA512_224, func() resumable.Hash { return New512_224().(*digest) })
+	pkgdigest.RegisterResumable(pkgdigest.SHA512_256, func() resumable.Hash { return New512_256().(*digest) })
+}
+
 // Len returns the number of bytes which have been written to the digest.
 func (d *digest) Len() int64 {
 	return int64(d.len)
 }
 
+// Clone returns an independent copy of the digest.
+func (d *digest) Clone() resumable.Hash {
+	d0 := *d
+	return &d0
+}
+
 // State returns a snapshot of the state of the digest.
 func (d *digest) State() ([]byte, error) {
 	var buf bytes.Buffer
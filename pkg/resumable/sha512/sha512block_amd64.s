@@ -270,4 +270,4 @@ loop:
 	JB	loop
 
 end:
-	RET
\ No newline at end of file
+	RET
@@ -31,4 +31,4 @@ done:
 	XOR	R0, R0        // restore R0
 	RET
 generic:
-	BR	·blockGeneric(SB)
\ No newline at end of file
+	BR	·blockGeneric(SB)
@@ -29,6 +29,7 @@ import (
 	"io"
 	"testing"
 
+	pkgdigest "github.com/bhojpur/crypto/pkg/digest"
 	"github.com/bhojpur/crypto/pkg/resumable"
 )
 
@@ -94,3 +95,60 @@ func TestResumableRegistered(t *testing.T) {
 	}
 
 }
+
+func TestResumableDigester(t *testing.T) {
+	p := make([]byte, 3*1024)
+	if _, err := io.ReadFull(rand.Reader, p); err != nil {
+		t.Fatalf("unable to load random data: %s", err)
+	}
+
+	for _, alg := range []pkgdigest.Algorithm{pkgdigest.SHA384, pkgdigest.SHA512, pkgdigest.SHA512_224, pkgdigest.SHA512_256} {
+		first, err := alg.ResumableDigester()
+		if err != nil {
+			t.Fatalf("unable to get resumable digester for %s: %s", alg, err)
+		}
+		first.Hash().Write(p[:1000])
+		state, err := first.State()
+		if err != nil {
+			t.Fatalf("unable to get state of digester: %s", err)
+		}
+
+		second, err := alg.ResumableDigester()
+		if err != nil {
+			t.Fatalf("unable to get resumable digester for %s: %s", alg, err)
+		}
+		if err := second.Restore(state); err != nil {
+			t.Fatalf("unable to restore state of digester: %s", err)
+		}
+		if second.Len() != 1000 {
+			t.Fatalf("unexpected length after restore: %d != 1000", second.Len())
+		}
+		second.Hash().Write(p[1000:])
+
+		if second.Digest() != alg.FromBytes(p) {
+			t.Fatalf("digests do not match: got %s, expected %s", second.Digest(), alg.FromBytes(p))
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	for _, newHash := range []func() hash.Hash{New384, New, New512_224, New512_256} {
+		orig := newHash()
+		io.WriteString(orig, "shared prefix")
+
+		clone := orig.(resumable.Cloner).Clone()
+		io.WriteString(clone, " and a fork")
+
+		expected := newHash()
+		io.WriteString(expected, "shared prefix and a fork")
+		if !bytes.Equal(clone.Sum(nil), expected.Sum(nil)) {
+			t.Fatalf("unexpected clone digest: %x != %x", clone.Sum(nil), expected.Sum(nil))
+		}
+
+		expected.Reset()
+		io.WriteString(expected, "shared prefix")
+		if !bytes.Equal(orig.Sum(nil), expected.Sum(nil)) {
+			t.Fatalf("writes to clone modified the original")
+		}
+	}
+}
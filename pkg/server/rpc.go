@@ -0,0 +1,415 @@
+package server
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"sync"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/digest"
+	"github.com/bhojpur/crypto/pkg/executor"
+	"github.com/bhojpur/crypto/pkg/hub"
+	"github.com/bhojpur/crypto/pkg/logslice"
+	"github.com/bhojpur/crypto/pkg/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StartLocalEngine implements v1.CryptoServiceServer.
+func (s *Service) StartLocalEngine(srv v1.CryptoService_StartLocalEngineServer) error {
+	req, err := srv.Recv()
+	if err != nil {
+		return err
+	}
+	md := req.GetMetadata()
+	if md == nil {
+		return status.Error(codes.InvalidArgument, "first message must contain the metadata")
+	}
+
+	var (
+		configYAML, engineYAML bytes.Buffer
+		app                    *ingestion
+	)
+	defer func() {
+		if app != nil {
+			app.abort()
+		}
+	}()
+recv:
+	for {
+		req, err := srv.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch content := req.Content.(type) {
+		case *v1.StartLocalEngineRequest_ConfigYaml:
+			configYAML.Write(content.ConfigYaml)
+		case *v1.StartLocalEngineRequest_EngineYaml:
+			engineYAML.Write(content.EngineYaml)
+		case *v1.StartLocalEngineRequest_ApplicationTar:
+			if app == nil {
+				app = s.ingest()
+			}
+			if _, err := app.Write(content.ApplicationTar); err != nil {
+				return status.Errorf(codes.Internal, "cannot store application: %v", err)
+			}
+		case *v1.StartLocalEngineRequest_ApplicationTarDone:
+			break recv
+		default:
+			return status.Error(codes.InvalidArgument, "metadata must only be sent once")
+		}
+	}
+
+	spec, err := executor.ParseSpec(engineYAML.Bytes())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	var dgst digest.Digest
+	if app != nil {
+		dgst, err = app.finish()
+		app = nil
+		if err != nil {
+			return status.Errorf(codes.Internal, "cannot store application: %v", err)
+		}
+	}
+
+	st, err := s.startEngine(srv.Context(), &store.EngineInputs{
+		Metadata:    md,
+		EngineYAML:  engineYAML.Bytes(),
+		ConfigYAML:  configYAML.Bytes(),
+		Application: dgst,
+	}, spec, nil)
+	if err != nil {
+		return err
+	}
+	return srv.SendAndClose(&v1.StartEngineResponse{Status: st})
+}
+
+// ingestion streams content into the content store.
+type ingestion struct {
+	*io.PipeWriter
+	done chan struct{}
+	dgst digest.Digest
+	err  error
+}
+
+func (s *Service) ingest() *ingestion {
+	pr, pw := io.Pipe()
+	in := &ingestion{PipeWriter: pw, done: make(chan struct{})}
+	go func() {
+		defer close(in.done)
+		in.dgst, in.err = s.Content.Ingest(digest.Canonical, pr)
+		pr.CloseWithError(in.err)
+	}()
+	return in
+}
+
+// finish completes the ingestion and returns the digest of the content.
+func (in *ingestion) finish() (digest.Digest, error) {
+	in.Close()
+	<-in.done
+	return in.dgst, in.err
+}
+
+// abort cancels the ingestion.
+func (in *ingestion) abort() {
+	in.CloseWithError(errors.New("aborted"))
+	<-in.done
+}
+
+// StartEngine implements v1.CryptoServiceServer.
+func (s *Service) StartEngine(ctx context.Context, req *v1.StartEngineRequest) (*v1.StartEngineResponse, error) {
+	if req.Metadata == nil {
+		return nil, status.Error(codes.InvalidArgument, "metadata is required")
+	}
+	if len(req.EngineYaml) == 0 {
+		return nil, status.Error(codes.Unimplemented, "loading engine specs from repositories is not supported, engine_yaml is required")
+	}
+	spec, err := executor.ParseSpec(req.EngineYaml)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var app digest.Digest
+	if len(req.Sideload) > 0 {
+		app, err = s.Content.Ingest(digest.Canonical, bytes.NewReader(req.Sideload))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot store sideload: %v", err)
+		}
+	}
+
+	st, err := s.startEngine(ctx, &store.EngineInputs{
+		Metadata:    req.Metadata,
+		EngineYAML:  req.EngineYaml,
+		NameSuffix:  req.NameSuffix,
+		Application: app,
+	}, spec, req.WaitUntil)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.StartEngineResponse{Status: st}, nil
+}
+
+// StartFromPreviousEngine implements v1.CryptoServiceServer.
+func (s *Service) StartFromPreviousEngine(ctx context.Context, req *v1.StartFromPreviousEngineRequest) (*v1.StartEngineResponse, error) {
+	prev, err := s.Engines.Get(ctx, req.PreviousEngine)
+	if err != nil {
+		return nil, storeError(err, req.PreviousEngine)
+	}
+	if !prev.GetConditions().GetCanReplay() || s.Inputs == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "engine %s cannot be replayed", req.PreviousEngine)
+	}
+	if prev.GetMetadata().GetRepository().GetRepo() != "" {
+		if err := s.checkGitOpsToken(req.GitopsToken); err != nil {
+			return nil, err
+		}
+	}
+
+	in, err := s.Inputs.GetInputs(ctx, req.PreviousEngine)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Errorf(codes.FailedPrecondition, "inputs of engine %s are gone, it cannot be replayed", req.PreviousEngine)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot load inputs of %s: %v", req.PreviousEngine, err)
+	}
+	if in.Application != "" {
+		// The content might have been removed from the store since.
+		app, err := s.Content.Get(in.Application)
+		if err != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "application of engine %s is gone, it cannot be replayed: %v", req.PreviousEngine, err)
+		}
+		app.Close()
+	}
+	spec, err := executor.ParseSpec(in.EngineYAML)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "engine %s cannot be replayed: %v", req.PreviousEngine, err)
+	}
+
+	st, err := s.startEngine(ctx, in, spec, req.WaitUntil)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.StartEngineResponse{Status: st}, nil
+}
+
+// checkGitOpsToken returns an error status unless token is the GitOps
+// token of the service.
+func (s *Service) checkGitOpsToken(token string) error {
+	if s.GitOpsToken == "" {
+		return status.Error(codes.FailedPrecondition, "replaying engines of repositories requires a gitops token to be configured on the server")
+	}
+	if token == "" {
+		return status.Error(codes.Unauthenticated, "gitops_token is required to replay engines of repositories")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.GitOpsToken)) != 1 {
+		return status.Error(codes.PermissionDenied, "invalid gitops_token")
+	}
+	return nil
+}
+
+// ListEngines implements v1.CryptoServiceServer.
+func (s *Service) ListEngines(ctx context.Context, req *v1.ListEnginesRequest) (*v1.ListEnginesResponse, error) {
+	result, total, err := s.Engines.Find(ctx, req.Filter, req.Order, int(req.Start), int(req.Limit))
+	if errors.Is(err, store.ErrBadQuery) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &v1.ListEnginesResponse{Total: int32(total), Result: result}, nil
+}
+
+// Subscribe implements v1.CryptoServiceServer.
+func (s *Service) Subscribe(req *v1.SubscribeRequest, srv v1.CryptoService_SubscribeServer) error {
+	filter, err := store.NewFilter(req.Filter)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	sub := s.Hub.Subscribe(filter, 0)
+	defer sub.Close()
+	for {
+		st, err := sub.Next(srv.Context())
+		if errors.Is(err, hub.ErrOverflow) {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+		if err != nil {
+			return nil
+		}
+		if err := srv.Send(&v1.SubscribeResponse{Result: st}); err != nil {
+			return err
+		}
+	}
+}
+
+// GetEngine implements v1.CryptoServiceServer.
+func (s *Service) GetEngine(ctx context.Context, req *v1.GetEngineRequest) (*v1.GetEngineResponse, error) {
+	st, err := s.Engines.Get(ctx, req.Name)
+	if err != nil {
+		return nil, storeError(err, req.Name)
+	}
+	return &v1.GetEngineResponse{Result: st}, nil
+}
+
+// Listen implements v1.CryptoServiceServer. The stream ends once the
+// engine is done and all requested output has been sent.
+func (s *Service) Listen(req *v1.ListenRequest, srv v1.CryptoService_ListenServer) error {
+	if _, ok := v1.ListenRequestLogs_name[int32(req.Logs)]; !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown logs mode %d", req.Logs)
+	}
+
+	// Start listening before getting the status, so that no update is
+	// missed in between. Only the latest status of a single engine is ever
+	// queued.
+	filter, err := store.NewFilter([]*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "name", Value: req.Name}}}})
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	var updates, started *hub.Subscription
+	if req.Updates {
+		updates = s.Hub.Subscribe(filter, 1)
+		defer updates.Close()
+	}
+	if req.Logs != v1.ListenRequestLogs_LOGS_DISABLED {
+		started = s.Hub.Subscribe(filter, 1)
+		defer started.Close()
+	}
+	st, err := s.Engines.Get(srv.Context(), req.Name)
+	if err != nil {
+		return storeError(err, req.Name)
+	}
+
+	var (
+		mu   sync.Mutex
+		send = func(resp *v1.ListenResponse) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return srv.Send(resp)
+		}
+		errc = make(chan error, 2)
+		n    int
+	)
+	if req.Updates {
+		n++
+		go func() {
+			errc <- s.sendUpdates(srv.Context(), st, updates, send)
+		}()
+	}
+	if req.Logs != v1.ListenRequestLogs_LOGS_DISABLED {
+		n++
+		go func() {
+			errc <- s.sendLogs(srv.Context(), req, st, started, send)
+		}()
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				return err
+			}
+		case <-srv.Context().Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// sendUpdates sends the status st and the following updates of the same
+// engine, until it is done.
+func (s *Service) sendUpdates(ctx context.Context, st *v1.EngineStatus, updates *hub.Subscription, send func(*v1.ListenResponse) error) error {
+	for {
+		if err := send(&v1.ListenResponse{Content: &v1.ListenResponse_Update{Update: st}}); err != nil {
+			return err
+		}
+		if st.Phase == v1.EnginePhase_PHASE_DONE {
+			return nil
+		}
+		next, err := updates.Next(ctx)
+		if err != nil {
+			return nil
+		}
+		st = next
+	}
+}
+
+// sendLogs sends the logs of an engine, waiting for it to start first if
+// its status st is PHASE_WAITING.
+func (s *Service) sendLogs(ctx context.Context, req *v1.ListenRequest, st *v1.EngineStatus, started *hub.Subscription, send func(*v1.ListenResponse) error) error {
+	for st.Phase == v1.EnginePhase_PHASE_WAITING {
+		next, err := started.Next(ctx)
+		if err != nil {
+			return nil
+		}
+		st = next
+	}
+	started.Close()
+
+	logs, err := s.Logs.Read(req.Name)
+	if err != nil {
+		return storeError(err, req.Name)
+	}
+	defer logs.Close()
+	return logslice.Read(logs, req.Logs, func(slice *v1.LogSliceEvent) error {
+		return send(&v1.ListenResponse{Content: &v1.ListenResponse_Slice{Slice: slice}})
+	})
+}
+
+// StopEngine implements v1.CryptoServiceServer. Stopping an engine which
+// is being stopped or was stopped before succeeds.
+func (s *Service) StopEngine(ctx context.Context, req *v1.StopEngineRequest) (*v1.StopEngineResponse, error) {
+	st, err := s.Engines.Get(ctx, req.Name)
+	if err != nil {
+		return nil, storeError(err, req.Name)
+	}
+	if st.Phase == v1.EnginePhase_PHASE_WAITING {
+		stopped, err := s.cancelWaiting(ctx, st)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot stop %s: %v", req.Name, err)
+		}
+		if stopped {
+			return &v1.StopEngineResponse{}, nil
+		}
+		// The engine was started or stopped meanwhile.
+		if st, err = s.Engines.Get(ctx, req.Name); err != nil {
+			return nil, storeError(err, req.Name)
+		}
+	}
+	switch {
+	case executor.WasStopped(st):
+		return &v1.StopEngineResponse{}, nil
+	case st.Phase == v1.EnginePhase_PHASE_DONE:
+		return nil, status.Errorf(codes.FailedPrecondition, "engine %s has already finished", req.Name)
+	}
+	if err := s.Executor.Stop(req.Name, s.StopGracePeriod); err != nil {
+		if errors.Is(err, executor.ErrNotRunning) {
+			return nil, status.Errorf(codes.FailedPrecondition, "engine %s is not running", req.Name)
+		}
+		return nil, status.Errorf(codes.Internal, "cannot stop %s: %v", req.Name, err)
+	}
+	return &v1.StopEngineResponse{}, nil
+}
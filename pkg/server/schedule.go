@@ -0,0 +1,158 @@
+package server
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/executor"
+	"github.com/bhojpur/crypto/pkg/store"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+// Resume schedules the engines the store holds in PHASE_WAITING, such as
+// those left waiting when the server was last stopped. Engines whose start
+// time has passed meanwhile are started right away.
+//
+// Replicas sharing a store may all resume. Each waiting engine is claimed
+// in the store before it is started, so only one of them starts it.
+func (s *Service) Resume(ctx context.Context) error {
+	filter := []*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "phase", Value: store.PhaseValue(v1.EnginePhase_PHASE_WAITING)}}}}
+	waiting, _, err := s.Engines.Find(ctx, filter, nil, 0, 0)
+	if err != nil {
+		return fmt.Errorf("cannot find waiting engines: %w", err)
+	}
+	for _, st := range waiting {
+		s.schedule(st.Name, st.GetConditions().GetWaitUntil().AsTime())
+	}
+	return nil
+}
+
+// schedule starts a waiting engine at the given time.
+func (s *Service) schedule(name string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.waiting[name]; ok {
+		return
+	}
+	s.waiting[name] = time.AfterFunc(time.Until(at), func() {
+		s.startWaiting(name)
+	})
+}
+
+// cancelWaiting stops a waiting engine from ever starting, whether it was
+// scheduled by this server or by another one sharing the store. It
+// returns false if the engine is no longer waiting.
+func (s *Service) cancelWaiting(ctx context.Context, st *v1.EngineStatus) (bool, error) {
+	st = proto.Clone(st).(*v1.EngineStatus)
+	st.Phase = v1.EnginePhase_PHASE_DONE
+	st.Details = executor.ErrStopped.Error() + " before it started"
+	err := s.Engines.Transition(ctx, v1.EnginePhase_PHASE_WAITING, st)
+	if errors.Is(err, store.ErrPhaseChanged) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	if timer, ok := s.waiting[st.Name]; ok {
+		timer.Stop()
+		delete(s.waiting, st.Name)
+	}
+	s.mu.Unlock()
+
+	// Create empty logs, so that listeners of the engine find them as
+	// for any other engine that is done.
+	if logs, err := s.Logs.Open(st.Name); err == nil {
+		logs.Close()
+	}
+	s.update(st)
+	return true, nil
+}
+
+// startWaiting starts a waiting engine from its stored inputs, unless it
+// was stopped or started by another server meanwhile.
+func (s *Service) startWaiting(name string) {
+	s.mu.Lock()
+	_, ok := s.waiting[name]
+	delete(s.waiting, name)
+	s.mu.Unlock()
+	if !ok {
+		// The engine was stopped meanwhile.
+		return
+	}
+
+	ctx := context.Background()
+	logger := log.WithField("name", name)
+	st, err := s.Engines.Get(ctx, name)
+	if err != nil {
+		logger.WithError(err).Error("cannot start waiting engine")
+		return
+	}
+	if st.Phase != v1.EnginePhase_PHASE_WAITING {
+		return
+	}
+
+	// Claim the engine. The claim is not published, listeners learn about
+	// the engine once run has opened its logs.
+	claimed := proto.Clone(st).(*v1.EngineStatus)
+	claimed.Phase = v1.EnginePhase_PHASE_PREPARING
+	claimed.Details = ""
+	err = s.Engines.Transition(ctx, v1.EnginePhase_PHASE_WAITING, claimed)
+	if errors.Is(err, store.ErrPhaseChanged) {
+		return
+	} else if err != nil {
+		logger.WithError(err).Error("cannot start waiting engine")
+		return
+	}
+
+	fail := func(err error) {
+		logger.WithError(err).Error("cannot start waiting engine")
+		claimed.Phase = v1.EnginePhase_PHASE_DONE
+		claimed.Conditions.FailureCount = 1
+		claimed.Details = err.Error()
+		s.update(claimed)
+	}
+	in, err := s.Inputs.GetInputs(ctx, name)
+	if err != nil {
+		fail(fmt.Errorf("cannot load inputs: %w", err))
+		return
+	}
+	spec, err := executor.ParseSpec(in.EngineYAML)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if _, err := s.run(ctx, claimed, in, spec); err != nil {
+		// run records the failure once the engine is registered.
+		latest, getErr := s.Engines.Get(ctx, name)
+		if getErr == nil && latest.Phase != v1.EnginePhase_PHASE_DONE {
+			fail(err)
+			return
+		}
+		logger.WithError(err).Error("cannot start waiting engine")
+	}
+}
@@ -0,0 +1,301 @@
+package server
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package server implements the CryptoService API on top of a store and an
+// executor.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/cas"
+	"github.com/bhojpur/crypto/pkg/executor"
+	"github.com/bhojpur/crypto/pkg/hub"
+	"github.com/bhojpur/crypto/pkg/store"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Config configures a Service.
+type Config struct {
+	Engines  store.Engines
+	Inputs   store.Inputs
+	Logs     store.Logs
+	Numbers  store.NumberGroup
+	Content  cas.Store
+	Executor executor.Executor
+
+	// Hub distributes status updates to listeners. Updates from other
+	// server replicas may be published on it as well. If it is nil, the
+	// service creates its own.
+	Hub *hub.Hub
+
+	// GitOpsToken must be presented to replay engines started from a
+	// repository. If it is empty, such engines cannot be replayed.
+	GitOpsToken string
+
+	// StopGracePeriod is the time stopped engines get to terminate before
+	// they are killed. If it is zero, DefaultStopGracePeriod is used.
+	StopGracePeriod time.Duration
+}
+
+// DefaultStopGracePeriod is the time stopped engines get to terminate by
+// default.
+const DefaultStopGracePeriod = 30 * time.Second
+
+// storeTimeout bounds how long storing a status of an engine may take.
+const storeTimeout = 10 * time.Second
+
+// Service implements v1.CryptoServiceServer.
+type Service struct {
+	Config
+
+	mu       sync.Mutex
+	logs     map[string]io.Closer
+	waiting  map[string]*time.Timer
+	updating map[string]*updateLock
+
+	v1.UnimplementedCryptoServiceServer
+}
+
+var _ v1.CryptoServiceServer = &Service{}
+
+// NewService returns a new Service.
+func NewService(cfg Config) *Service {
+	if cfg.Hub == nil {
+		cfg.Hub = hub.New()
+	}
+	if cfg.StopGracePeriod == 0 {
+		cfg.StopGracePeriod = DefaultStopGracePeriod
+	}
+	return &Service{
+		Config:   cfg,
+		logs:     map[string]io.Closer{},
+		waiting:  map[string]*time.Timer{},
+		updating: map[string]*updateLock{},
+	}
+}
+
+// startEngine registers a new engine and hands it to the executor, or
+// schedules it if it is to wait until a later time. The inputs are stored,
+// so that the engine can be replayed or started later on.
+func (s *Service) startEngine(ctx context.Context, in *store.EngineInputs, spec *executor.Spec, waitUntil *timestamppb.Timestamp) (*v1.EngineStatus, error) {
+	if waitUntil != nil {
+		if err := waitUntil.CheckValid(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid wait_until: %v", err)
+		}
+		if !waitUntil.AsTime().After(time.Now()) {
+			waitUntil = nil
+		} else if s.Inputs == nil {
+			return nil, status.Error(codes.Unimplemented, "delayed start requires a store for engine inputs")
+		}
+	}
+
+	name, err := s.newName(in.Metadata, in.NameSuffix)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot name engine: %v", err)
+	}
+	md := proto.Clone(in.Metadata).(*v1.EngineMetadata)
+	md.Created = timestamppb.Now()
+	md.Finished = nil
+
+	var canReplay bool
+	if s.Inputs != nil {
+		err := s.Inputs.StoreInputs(ctx, name, in)
+		switch {
+		case err == nil:
+			canReplay = true
+		case waitUntil != nil:
+			// A waiting engine is started from its stored inputs.
+			return nil, status.Errorf(codes.Internal, "cannot store inputs of %s: %v", name, err)
+		default:
+			// An engine whose inputs are lost still runs, it just cannot
+			// be replayed.
+			log.WithError(err).WithField("name", name).Warn("cannot store engine inputs")
+		}
+	}
+
+	st := &v1.EngineStatus{
+		Name:       name,
+		Metadata:   md,
+		Conditions: &v1.EngineConditions{CanReplay: canReplay},
+	}
+	if waitUntil != nil {
+		st.Phase = v1.EnginePhase_PHASE_WAITING
+		st.Conditions.WaitUntil = waitUntil
+		st.Details = "waiting until " + waitUntil.AsTime().Format(time.RFC3339)
+		s.update(st)
+		s.schedule(name, waitUntil.AsTime())
+		return st, nil
+	}
+	return s.run(ctx, st, in, spec)
+}
+
+// run hands a registered engine to the executor.
+func (s *Service) run(ctx context.Context, st *v1.EngineStatus, in *store.EngineInputs, spec *executor.Spec) (*v1.EngineStatus, error) {
+	name := st.Name
+	logs, err := s.Logs.Open(name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot open logs of %s: %v", name, err)
+	}
+	s.mu.Lock()
+	s.logs[name] = logs
+	s.mu.Unlock()
+
+	engine := &executor.Engine{
+		Name:      name,
+		Metadata:  st.Metadata,
+		Spec:      spec,
+		Config:    in.ConfigYAML,
+		Logs:      logs,
+		Update:    s.update,
+		CanReplay: st.GetConditions().GetCanReplay(),
+		WaitUntil: st.GetConditions().GetWaitUntil(),
+	}
+	if app := in.Application; app != "" {
+		engine.Application = func() (io.ReadCloser, error) {
+			return s.Content.Get(app)
+		}
+	}
+
+	initial := engine.Status(v1.EnginePhase_PHASE_PREPARING)
+	s.update(initial)
+	if err := s.Executor.Start(ctx, engine); err != nil {
+		failed := engine.Status(v1.EnginePhase_PHASE_DONE)
+		failed.Conditions.FailureCount = 1
+		failed.Details = err.Error()
+		s.update(failed)
+		return nil, status.Errorf(codes.Internal, "cannot start %s: %v", name, err)
+	}
+	return initial, nil
+}
+
+// update records a new status of an engine and notifies the listeners.
+func (s *Service) update(st *v1.EngineStatus) {
+	st = proto.Clone(st).(*v1.EngineStatus)
+	if st.Phase == v1.EnginePhase_PHASE_DONE {
+		if st.Metadata == nil {
+			st.Metadata = &v1.EngineMetadata{}
+		}
+		if st.Metadata.Finished == nil {
+			st.Metadata.Finished = timestamppb.Now()
+		}
+	}
+
+	// Updates of an engine are stored and published in order, while those
+	// of different engines do not wait for each other.
+	unlock := s.lockUpdates(st.Name)
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	err := s.Engines.Store(ctx, st)
+	cancel()
+	if err != nil {
+		log.WithError(err).WithField("name", st.Name).Error("cannot store engine status")
+	}
+	if st.Phase == v1.EnginePhase_PHASE_DONE {
+		s.mu.Lock()
+		logs, ok := s.logs[st.Name]
+		delete(s.logs, st.Name)
+		s.mu.Unlock()
+		if ok {
+			if err := logs.Close(); err != nil {
+				log.WithError(err).WithField("name", st.Name).Warn("cannot close engine logs")
+			}
+		}
+	}
+	s.Hub.Publish(st)
+}
+
+// updateLock serializes the updates of an engine.
+type updateLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockUpdates locks the updates of the named engine. The returned function
+// unlocks them.
+func (s *Service) lockUpdates(name string) (unlock func()) {
+	s.mu.Lock()
+	l, ok := s.updating[name]
+	if !ok {
+		l = &updateLock{}
+		s.updating[name] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.updating, name)
+		}
+		s.mu.Unlock()
+	}
+}
+
+var nameSanitizer = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// newName returns a new engine name of the form <repo>-<ref>-<suffix>.<n>.
+func (s *Service) newName(md *v1.EngineMetadata, suffix string) (string, error) {
+	parts := []string{"local"}
+	if repo := md.GetRepository(); repo != nil && repo.Repo != "" {
+		parts = []string{repo.Repo}
+		if ref := strings.TrimPrefix(repo.Ref, "refs/heads/"); ref != "" {
+			parts = append(parts, ref)
+		}
+	}
+	if suffix != "" {
+		parts = append(parts, suffix)
+	}
+	base := nameSanitizer.ReplaceAllString(strings.ToLower(strings.Join(parts, "-")), "-")
+	base = strings.Trim(base, ".-")
+	if base == "" {
+		base = "engine"
+	}
+
+	nr, err := s.Numbers.Next(base)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%d", base, nr), nil
+}
+
+// storeError converts a store error into a gRPC status.
+func storeError(err error, name string) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return status.Errorf(codes.NotFound, "engine %s not found", name)
+	}
+	return status.Error(codes.Internal, err.Error())
+}
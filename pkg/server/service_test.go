@@ -0,0 +1,730 @@
+package server
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/cas"
+	"github.com/bhojpur/crypto/pkg/executor"
+	"github.com/bhojpur/crypto/pkg/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const testSpec = `steps:
+- name: build
+  command: ["make"]
+`
+
+// fakeExecutor hands started engines to the test.
+type fakeExecutor struct {
+	started chan *executor.Engine
+	mu      sync.Mutex
+	stopped []string
+	grace   time.Duration
+}
+
+func (e *fakeExecutor) Start(ctx context.Context, engine *executor.Engine) error {
+	e.started <- engine
+	return nil
+}
+
+func (e *fakeExecutor) Stop(name string, grace time.Duration) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopped = append(e.stopped, name)
+	e.grace = grace
+	return nil
+}
+
+// newTestClient serves a Service backed by a memory store, logs in a
+// temporary directory and the given executor, and returns a client for it.
+func newTestClient(t *testing.T, exec executor.Executor) (v1.CryptoServiceClient, *Service) {
+	logs, err := store.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	s := store.NewMemoryStore()
+	service := NewService(Config{
+		Engines:     s,
+		Inputs:      s,
+		Logs:        logs,
+		Numbers:     s,
+		Content:     cas.NewMemoryStore(),
+		Executor:    exec,
+		GitOpsToken: "secret",
+	})
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	v1.RegisterCryptoServiceServer(srv, service)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return v1.NewCryptoServiceClient(conn), service
+}
+
+func assertCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("expected %v, got %v", code, err)
+	}
+}
+
+func TestNoopEngine(t *testing.T) {
+	client, _ := newTestClient(t, executor.Noop{})
+	ctx := context.Background()
+
+	resp, err := client.StartEngine(ctx, &v1.StartEngineRequest{
+		Metadata: &v1.EngineMetadata{
+			Owner:      "bhojpur",
+			Repository: &v1.Repository{Repo: "Crypto", Ref: "refs/heads/main"},
+		},
+		EngineYaml: []byte(testSpec),
+		NameSuffix: "nightly",
+	})
+	if err != nil {
+		t.Fatalf("StartEngine: %v", err)
+	}
+	name := resp.Status.Name
+	if name != "crypto-main-nightly.1" {
+		t.Fatalf("unexpected name: %s", name)
+	}
+
+	updates, lines := listen(t, client, name)
+	if last := updates[len(updates)-1]; last.Phase != v1.EnginePhase_PHASE_DONE || !last.Conditions.Success {
+		t.Fatalf("unexpected final status: %v", last)
+	}
+	if len(lines) != 1 || lines[0] != "skipping step build: [make]" {
+		t.Fatalf("unexpected logs: %q", lines)
+	}
+
+	get, err := client.GetEngine(ctx, &v1.GetEngineRequest{Name: name})
+	if err != nil {
+		t.Fatalf("GetEngine: %v", err)
+	}
+	if get.Result.Metadata.Created == nil || get.Result.Metadata.Finished == nil {
+		t.Fatalf("timestamps not recorded: %v", get.Result.Metadata)
+	}
+	list, err := client.ListEngines(ctx, &v1.ListEnginesRequest{})
+	if err != nil {
+		t.Fatalf("ListEngines: %v", err)
+	}
+	if list.Total != 1 || list.Result[0].Name != name {
+		t.Fatalf("unexpected list: %v", list)
+	}
+
+	_, err = client.StopEngine(ctx, &v1.StopEngineRequest{Name: name})
+	assertCode(t, err, codes.FailedPrecondition)
+	_, err = client.GetEngine(ctx, &v1.GetEngineRequest{Name: "unknown.1"})
+	assertCode(t, err, codes.NotFound)
+	_, err = client.StartEngine(ctx, &v1.StartEngineRequest{Metadata: &v1.EngineMetadata{}, EngineYaml: []byte("steps: [")})
+	assertCode(t, err, codes.InvalidArgument)
+}
+
+// listen collects the updates and log lines of an engine until it is done.
+func listen(t *testing.T, client v1.CryptoServiceClient, name string) (updates []*v1.EngineStatus, lines []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Listen(ctx, &v1.ListenRequest{
+		Name:    name,
+		Updates: true,
+		Logs:    v1.ListenRequestLogs_LOGS_UNSLICED,
+	})
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if update := resp.GetUpdate(); update != nil {
+			updates = append(updates, update)
+		}
+		if slice := resp.GetSlice(); slice != nil {
+			lines = append(lines, slice.Payload)
+		}
+	}
+}
+
+func TestStartLocalEngine(t *testing.T) {
+	exec := &fakeExecutor{started: make(chan *executor.Engine, 1)}
+	client, _ := newTestClient(t, exec)
+
+	stream, err := client.StartLocalEngine(context.Background())
+	if err != nil {
+		t.Fatalf("StartLocalEngine: %v", err)
+	}
+	reqs := []*v1.StartLocalEngineRequest{
+		{Content: &v1.StartLocalEngineRequest_Metadata{Metadata: &v1.EngineMetadata{Owner: "bhojpur"}}},
+		{Content: &v1.StartLocalEngineRequest_ConfigYaml{ConfigYaml: []byte("rules: []")}},
+		{Content: &v1.StartLocalEngineRequest_EngineYaml{EngineYaml: []byte(testSpec[:10])}},
+		{Content: &v1.StartLocalEngineRequest_EngineYaml{EngineYaml: []byte(testSpec[10:])}},
+		{Content: &v1.StartLocalEngineRequest_ApplicationTar{ApplicationTar: []byte("tar ")}},
+		{Content: &v1.StartLocalEngineRequest_ApplicationTar{ApplicationTar: []byte("stream")}},
+		{Content: &v1.StartLocalEngineRequest_ApplicationTarDone{ApplicationTarDone: true}},
+	}
+	for _, req := range reqs {
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if resp.Status.Name != "local.1" || resp.Status.Phase != v1.EnginePhase_PHASE_PREPARING {
+		t.Fatalf("unexpected status: %v", resp.Status)
+	}
+
+	engine := <-exec.started
+	if len(engine.Spec.Steps) != 1 || engine.Application == nil {
+		t.Fatalf("unexpected engine: %+v", engine)
+	}
+	rc, err := engine.Application()
+	if err != nil {
+		t.Fatalf("Application: %v", err)
+	}
+	app, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(app) != "tar stream" {
+		t.Fatalf("unexpected application: %q, %v", app, err)
+	}
+}
+
+func TestStartFromPreviousEngine(t *testing.T) {
+	exec := &fakeExecutor{started: make(chan *executor.Engine, 1)}
+	client, service := newTestClient(t, exec)
+	ctx := context.Background()
+
+	stream, err := client.StartLocalEngine(ctx)
+	if err != nil {
+		t.Fatalf("StartLocalEngine: %v", err)
+	}
+	for _, req := range []*v1.StartLocalEngineRequest{
+		{Content: &v1.StartLocalEngineRequest_Metadata{Metadata: &v1.EngineMetadata{Owner: "bhojpur"}}},
+		{Content: &v1.StartLocalEngineRequest_ConfigYaml{ConfigYaml: []byte("rules: []")}},
+		{Content: &v1.StartLocalEngineRequest_EngineYaml{EngineYaml: []byte(testSpec)}},
+		{Content: &v1.StartLocalEngineRequest_ApplicationTar{ApplicationTar: []byte("tar stream")}},
+		{Content: &v1.StartLocalEngineRequest_ApplicationTarDone{ApplicationTarDone: true}},
+	} {
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	local, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if !local.Status.Conditions.CanReplay {
+		t.Fatalf("engine cannot be replayed: %v", local.Status)
+	}
+	<-exec.started
+
+	resp, err := client.StartFromPreviousEngine(ctx, &v1.StartFromPreviousEngineRequest{PreviousEngine: local.Status.Name})
+	if err != nil {
+		t.Fatalf("StartFromPreviousEngine: %v", err)
+	}
+	if resp.Status.Name != "local.2" || resp.Status.Metadata.Owner != "bhojpur" {
+		t.Fatalf("unexpected status: %v", resp.Status)
+	}
+	engine := <-exec.started
+	if len(engine.Spec.Steps) != 1 || string(engine.Config) != "rules: []" || engine.Application == nil {
+		t.Fatalf("unexpected engine: %+v", engine)
+	}
+	rc, err := engine.Application()
+	if err != nil {
+		t.Fatalf("Application: %v", err)
+	}
+	app, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(app) != "tar stream" {
+		t.Fatalf("unexpected application: %q, %v", app, err)
+	}
+
+	// Engines of repositories require the gitops token.
+	repo, err := client.StartEngine(ctx, &v1.StartEngineRequest{
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur", Repository: &v1.Repository{Repo: "crypto", Ref: "main"}},
+		EngineYaml: []byte(testSpec),
+	})
+	if err != nil {
+		t.Fatalf("StartEngine: %v", err)
+	}
+	<-exec.started
+	_, err = client.StartFromPreviousEngine(ctx, &v1.StartFromPreviousEngineRequest{PreviousEngine: repo.Status.Name})
+	assertCode(t, err, codes.Unauthenticated)
+	_, err = client.StartFromPreviousEngine(ctx, &v1.StartFromPreviousEngineRequest{PreviousEngine: repo.Status.Name, GitopsToken: "guess"})
+	assertCode(t, err, codes.PermissionDenied)
+	resp, err = client.StartFromPreviousEngine(ctx, &v1.StartFromPreviousEngineRequest{PreviousEngine: repo.Status.Name, GitopsToken: "secret"})
+	if err != nil {
+		t.Fatalf("StartFromPreviousEngine: %v", err)
+	}
+	if resp.Status.Name != "crypto-main.2" {
+		t.Fatalf("unexpected name: %s", resp.Status.Name)
+	}
+	<-exec.started
+
+	_, err = client.StartFromPreviousEngine(ctx, &v1.StartFromPreviousEngineRequest{PreviousEngine: "unknown.1"})
+	assertCode(t, err, codes.NotFound)
+
+	// Engines whose inputs were not stored cannot be replayed.
+	if err := service.Engines.Store(ctx, &v1.EngineStatus{Name: "old.1", Metadata: &v1.EngineMetadata{}, Conditions: &v1.EngineConditions{}}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	_, err = client.StartFromPreviousEngine(ctx, &v1.StartFromPreviousEngineRequest{PreviousEngine: "old.1"})
+	assertCode(t, err, codes.FailedPrecondition)
+}
+
+func TestWaitingEngine(t *testing.T) {
+	exec := &fakeExecutor{started: make(chan *executor.Engine, 1)}
+	client, _ := newTestClient(t, exec)
+	ctx := context.Background()
+
+	waitUntil := timestamppb.New(time.Now().Add(200 * time.Millisecond))
+	resp, err := client.StartEngine(ctx, &v1.StartEngineRequest{
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur"},
+		EngineYaml: []byte(testSpec),
+		WaitUntil:  waitUntil,
+	})
+	if err != nil {
+		t.Fatalf("StartEngine: %v", err)
+	}
+	if resp.Status.Phase != v1.EnginePhase_PHASE_WAITING || !resp.Status.Conditions.WaitUntil.AsTime().Equal(waitUntil.AsTime()) {
+		t.Fatalf("unexpected status: %v", resp.Status)
+	}
+
+	// Listening to the logs waits for the engine to start.
+	stream, err := client.Listen(ctx, &v1.ListenRequest{Name: resp.Status.Name, Logs: v1.ListenRequestLogs_LOGS_UNSLICED})
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	received := make(chan *v1.ListenResponse, 1)
+	go func() {
+		msg, err := stream.Recv()
+		if err != nil {
+			t.Errorf("Recv: %v", err)
+		}
+		received <- msg
+	}()
+
+	var engine *executor.Engine
+	select {
+	case engine = <-exec.started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("engine did not start")
+	}
+	if time.Now().Before(waitUntil.AsTime()) {
+		t.Fatalf("engine started before its time")
+	}
+	if st := engine.Status(v1.EnginePhase_PHASE_RUNNING); st.Conditions.WaitUntil == nil || !st.Conditions.CanReplay {
+		t.Fatalf("unexpected conditions: %v", st.Conditions)
+	}
+	io.WriteString(engine.Logs, "hello\n")
+	select {
+	case msg := <-received:
+		if msg.GetSlice().GetPayload() != "hello" {
+			t.Fatalf("unexpected logs: %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("logs of started engine not received")
+	}
+	engine.Update(engine.Status(v1.EnginePhase_PHASE_DONE))
+
+	// A start time in the past starts the engine right away.
+	resp, err = client.StartEngine(ctx, &v1.StartEngineRequest{
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur"},
+		EngineYaml: []byte(testSpec),
+		WaitUntil:  timestamppb.New(time.Now().Add(-time.Minute)),
+	})
+	if err != nil {
+		t.Fatalf("StartEngine: %v", err)
+	}
+	if resp.Status.Phase != v1.EnginePhase_PHASE_PREPARING {
+		t.Fatalf("unexpected status: %v", resp.Status)
+	}
+	<-exec.started
+}
+
+func TestStopWaitingEngine(t *testing.T) {
+	exec := &fakeExecutor{started: make(chan *executor.Engine, 1)}
+	client, _ := newTestClient(t, exec)
+	ctx := context.Background()
+
+	resp, err := client.StartEngine(ctx, &v1.StartEngineRequest{
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur"},
+		EngineYaml: []byte(testSpec),
+		WaitUntil:  timestamppb.New(time.Now().Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("StartEngine: %v", err)
+	}
+	name := resp.Status.Name
+	if _, err := client.StopEngine(ctx, &v1.StopEngineRequest{Name: name}); err != nil {
+		t.Fatalf("StopEngine: %v", err)
+	}
+
+	updates, lines := listen(t, client, name)
+	last := updates[len(updates)-1]
+	if last.Phase != v1.EnginePhase_PHASE_DONE || last.Conditions.Success || last.Conditions.DidExecute || last.Details != "stopped before it started" {
+		t.Fatalf("unexpected final status: %v", last)
+	}
+	if len(lines) != 0 {
+		t.Fatalf("unexpected logs: %q", lines)
+	}
+	if len(exec.started) != 0 {
+		t.Fatalf("stopped engine was started")
+	}
+}
+
+func TestResume(t *testing.T) {
+	exec := &fakeExecutor{started: make(chan *executor.Engine, 1)}
+	_, service := newTestClient(t, exec)
+	ctx := context.Background()
+
+	// An engine left waiting by a previous server.
+	err := service.Inputs.StoreInputs(ctx, "local.7", &store.EngineInputs{
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur"},
+		EngineYAML: []byte(testSpec),
+	})
+	if err != nil {
+		t.Fatalf("StoreInputs: %v", err)
+	}
+	err = service.Engines.Store(ctx, &v1.EngineStatus{
+		Name:       "local.7",
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur", Created: timestamppb.Now()},
+		Phase:      v1.EnginePhase_PHASE_WAITING,
+		Conditions: &v1.EngineConditions{CanReplay: true, WaitUntil: timestamppb.New(time.Now().Add(-time.Second))},
+	})
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	if err := service.Resume(ctx); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	select {
+	case engine := <-exec.started:
+		if engine.Name != "local.7" || engine.Metadata.Owner != "bhojpur" || len(engine.Spec.Steps) != 1 {
+			t.Fatalf("unexpected engine: %+v", engine)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("waiting engine was not resumed")
+	}
+	st, err := service.Engines.Get(ctx, "local.7")
+	if err != nil || st.Phase != v1.EnginePhase_PHASE_PREPARING {
+		t.Fatalf("unexpected status: %v, %v", st, err)
+	}
+}
+
+func TestResumeSharedStore(t *testing.T) {
+	exec := &fakeExecutor{started: make(chan *executor.Engine, 1)}
+	_, service := newTestClient(t, exec)
+	ctx := context.Background()
+
+	err := service.Inputs.StoreInputs(ctx, "local.7", &store.EngineInputs{
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur"},
+		EngineYAML: []byte(testSpec),
+	})
+	if err != nil {
+		t.Fatalf("StoreInputs: %v", err)
+	}
+	err = service.Engines.Store(ctx, &v1.EngineStatus{
+		Name:       "local.7",
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur", Created: timestamppb.Now()},
+		Phase:      v1.EnginePhase_PHASE_WAITING,
+		Conditions: &v1.EngineConditions{CanReplay: true, WaitUntil: timestamppb.New(time.Now().Add(-time.Second))},
+	})
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	// Both replicas resume, only one of them starts the engine.
+	otherExec := &fakeExecutor{started: make(chan *executor.Engine, 1)}
+	cfg := service.Config
+	cfg.Executor = otherExec
+	other := NewService(cfg)
+	if err := service.Resume(ctx); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if err := other.Resume(ctx); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	var started int
+	timeout := time.After(time.Second)
+	for started < 2 {
+		select {
+		case <-exec.started:
+			started++
+		case <-otherExec.started:
+			started++
+		case <-timeout:
+			if started != 1 {
+				t.Fatalf("engine was started %d times", started)
+			}
+			return
+		}
+	}
+	t.Fatalf("engine was started twice")
+}
+
+func TestStopEngineWaitingElsewhere(t *testing.T) {
+	exec := &fakeExecutor{started: make(chan *executor.Engine, 1)}
+	client, service := newTestClient(t, exec)
+	ctx := context.Background()
+
+	// An engine scheduled by another server sharing the store.
+	err := service.Engines.Store(ctx, &v1.EngineStatus{
+		Name:       "local.7",
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur", Created: timestamppb.Now()},
+		Phase:      v1.EnginePhase_PHASE_WAITING,
+		Conditions: &v1.EngineConditions{CanReplay: true, WaitUntil: timestamppb.New(time.Now().Add(time.Hour))},
+	})
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if _, err := client.StopEngine(ctx, &v1.StopEngineRequest{Name: "local.7"}); err != nil {
+		t.Fatalf("StopEngine: %v", err)
+	}
+	st, err := service.Engines.Get(ctx, "local.7")
+	if err != nil || !executor.WasStopped(st) {
+		t.Fatalf("unexpected status: %v, %v", st, err)
+	}
+	if _, err := client.StopEngine(ctx, &v1.StopEngineRequest{Name: "local.7"}); err != nil {
+		t.Fatalf("StopEngine of a stopped engine: %v", err)
+	}
+}
+
+func TestListenRunningEngine(t *testing.T) {
+	exec := &fakeExecutor{started: make(chan *executor.Engine, 1)}
+	client, _ := newTestClient(t, exec)
+	ctx := context.Background()
+
+	resp, err := client.StartEngine(ctx, &v1.StartEngineRequest{
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur"},
+		EngineYaml: []byte(testSpec),
+	})
+	if err != nil {
+		t.Fatalf("StartEngine: %v", err)
+	}
+	engine := <-exec.started
+
+	go func() {
+		// Give the listener time to attach before the engine progresses.
+		time.Sleep(20 * time.Millisecond)
+		engine.Update(engine.Status(v1.EnginePhase_PHASE_RUNNING))
+		io.WriteString(engine.Logs, "hello\nworld\n")
+		done := engine.Status(v1.EnginePhase_PHASE_DONE)
+		done.Conditions.Success = true
+		engine.Update(done)
+	}()
+
+	updates, lines := listen(t, client, resp.Status.Name)
+	var phases []v1.EnginePhase
+	for _, u := range updates {
+		phases = append(phases, u.Phase)
+	}
+	// Updates are coalesced, so the running phase may be skipped.
+	if len(phases) < 2 || phases[0] != v1.EnginePhase_PHASE_PREPARING || phases[len(phases)-1] != v1.EnginePhase_PHASE_DONE {
+		t.Fatalf("unexpected phases: %v", phases)
+	}
+	if len(lines) != 2 || lines[0] != "hello" || lines[1] != "world" {
+		t.Fatalf("unexpected logs: %q", lines)
+	}
+}
+
+func TestStopEngine(t *testing.T) {
+	exec := &fakeExecutor{started: make(chan *executor.Engine, 1)}
+	client, _ := newTestClient(t, exec)
+	ctx := context.Background()
+
+	resp, err := client.StartEngine(ctx, &v1.StartEngineRequest{
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur"},
+		EngineYaml: []byte(testSpec),
+	})
+	if err != nil {
+		t.Fatalf("StartEngine: %v", err)
+	}
+	engine := <-exec.started
+	if _, err := client.StopEngine(ctx, &v1.StopEngineRequest{Name: resp.Status.Name}); err != nil {
+		t.Fatalf("StopEngine: %v", err)
+	}
+	exec.mu.Lock()
+	if len(exec.stopped) != 1 || exec.stopped[0] != resp.Status.Name || exec.grace != DefaultStopGracePeriod {
+		t.Fatalf("engine not stopped: %v within %v", exec.stopped, exec.grace)
+	}
+	exec.mu.Unlock()
+
+	// Stopping is idempotent, also once the engine is done.
+	st := engine.Status(v1.EnginePhase_PHASE_CLEANUP)
+	engine.Update(st)
+	if _, err := client.StopEngine(ctx, &v1.StopEngineRequest{Name: resp.Status.Name}); err != nil {
+		t.Fatalf("StopEngine while stopping: %v", err)
+	}
+	st = engine.Status(v1.EnginePhase_PHASE_DONE)
+	st.Conditions.DidExecute = true
+	st.Details = executor.ErrStopped.Error()
+	engine.Update(st)
+	if _, err := client.StopEngine(ctx, &v1.StopEngineRequest{Name: resp.Status.Name}); err != nil {
+		t.Fatalf("StopEngine once stopped: %v", err)
+	}
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	if len(exec.stopped) != 2 {
+		t.Fatalf("stopped engine handed to the executor again: %v", exec.stopped)
+	}
+
+	_, err = client.StopEngine(ctx, &v1.StopEngineRequest{Name: "unknown.1"})
+	assertCode(t, err, codes.NotFound)
+}
+
+func TestSubscribeFilter(t *testing.T) {
+	client, service := newTestClient(t, executor.Noop{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	badFilter := []*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "unknown"}}}}
+	_, err := client.ListEngines(ctx, &v1.ListEnginesRequest{Filter: badFilter})
+	assertCode(t, err, codes.InvalidArgument)
+	bad, err := client.Subscribe(ctx, &v1.SubscribeRequest{Filter: badFilter})
+	if err == nil {
+		_, err = bad.Recv()
+	}
+	assertCode(t, err, codes.InvalidArgument)
+
+	sub, err := client.Subscribe(ctx, &v1.SubscribeRequest{
+		Filter: []*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "phase", Value: "done"}}}},
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	waitForListener(t, service)
+
+	resp, err := client.StartEngine(ctx, &v1.StartEngineRequest{
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur"},
+		EngineYaml: []byte(testSpec),
+	})
+	if err != nil {
+		t.Fatalf("StartEngine: %v", err)
+	}
+	update, err := sub.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if update.Result.Name != resp.Status.Name || update.Result.Phase != v1.EnginePhase_PHASE_DONE {
+		t.Fatalf("unexpected update: %v", update.Result)
+	}
+
+	list, err := client.ListEngines(ctx, &v1.ListEnginesRequest{
+		Filter: []*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "phase", Value: "running"}}}},
+	})
+	if err != nil {
+		t.Fatalf("ListEngines: %v", err)
+	}
+	if list.Total != 0 {
+		t.Fatalf("unexpected engines: %v", list.Result)
+	}
+}
+
+// waitForListener waits until a listener is attached to the service.
+func waitForListener(t *testing.T, s *Service) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if s.Hub.Len() > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("no listener attached")
+}
+
+// blockingStore blocks storing the status of one engine until released.
+type blockingStore struct {
+	store.Engines
+	name    string
+	release chan struct{}
+}
+
+func (s *blockingStore) Store(ctx context.Context, st *v1.EngineStatus) error {
+	if st.Name == s.name {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.Engines.Store(ctx, st)
+}
+
+func TestUpdateDoesNotBlockOtherEngines(t *testing.T) {
+	_, service := newTestClient(t, &fakeExecutor{})
+	blocking := &blockingStore{Engines: service.Engines, name: "slow.1", release: make(chan struct{})}
+	service.Engines = blocking
+
+	slowDone := make(chan struct{})
+	go func() {
+		service.update(&v1.EngineStatus{Name: "slow.1", Phase: v1.EnginePhase_PHASE_RUNNING})
+		close(slowDone)
+	}()
+	fastDone := make(chan struct{})
+	go func() {
+		service.update(&v1.EngineStatus{Name: "fast.1", Phase: v1.EnginePhase_PHASE_RUNNING})
+		close(fastDone)
+	}()
+	select {
+	case <-fastDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("update of one engine waited for the store of another")
+	}
+
+	close(blocking.release)
+	<-slowDone
+	if st, err := service.Engines.Get(context.Background(), "slow.1"); err != nil || st.Phase != v1.EnginePhase_PHASE_RUNNING {
+		t.Fatalf("unexpected status: %v, %v", st, err)
+	}
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if len(service.updating) != 0 {
+		t.Fatalf("update locks were not released: %v", service.updating)
+	}
+}
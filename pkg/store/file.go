@@ -0,0 +1,288 @@
+package store
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// nameRegexp matches valid engine names, which are used as file names.
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// ValidateName returns an error if name is not a valid engine name.
+func ValidateName(name string) error {
+	if !nameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid engine name %q", name)
+	}
+	return nil
+}
+
+// FileStore keeps engines, their inputs and logs and number groups in a
+// directory.
+// The status of all engines is also held in memory.
+type FileStore struct {
+	dir  string
+	live liveLogs
+
+	mu      sync.RWMutex
+	engines map[string]*v1.EngineStatus
+	numbers map[string]int
+}
+
+var (
+	_ Engines     = &FileStore{}
+	_ Inputs      = &FileStore{}
+	_ Logs        = &FileStore{}
+	_ NumberGroup = &FileStore{}
+)
+
+const (
+	enginesDir  = "engines"
+	inputsDir   = "inputs"
+	logsDir     = "logs"
+	numbersFile = "numbers.json"
+)
+
+// NewFileStore opens the store in dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	for _, sub := range []string{enginesDir, inputsDir, logsDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	s := &FileStore{
+		dir:     dir,
+		engines: map[string]*v1.EngineStatus{},
+		numbers: map[string]int{},
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, enginesDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		name := strings.TrimSuffix(fi.Name(), ".json")
+		if name == fi.Name() || ValidateName(name) != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, enginesDir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var status v1.EngineStatus
+		if err := protojson.Unmarshal(data, &status); err != nil {
+			return nil, fmt.Errorf("cannot load engine %s: %w", name, err)
+		}
+		s.engines[name] = &status
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, numbersFile))
+	if err == nil {
+		err = json.Unmarshal(data, &s.numbers)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot load number groups: %w", err)
+	}
+	return s, nil
+}
+
+// Store implements Engines.
+func (s *FileStore) Store(ctx context.Context, status *v1.EngineStatus) error {
+	if err := ValidateName(status.Name); err != nil {
+		return err
+	}
+	data, err := protojson.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store(status, data)
+}
+
+// Transition implements Engines.
+func (s *FileStore) Transition(ctx context.Context, from v1.EnginePhase, status *v1.EngineStatus) error {
+	data, err := protojson.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.engines[status.Name]
+	if !ok {
+		return ErrNotFound
+	}
+	if old.Phase != from {
+		return ErrPhaseChanged
+	}
+	return s.store(status, data)
+}
+
+// store writes status, marshalled as data. s.mu must be held.
+func (s *FileStore) store(status *v1.EngineStatus, data []byte) error {
+	if err := writeFile(filepath.Join(s.dir, enginesDir, status.Name+".json"), data); err != nil {
+		return err
+	}
+	s.engines[status.Name] = proto.Clone(status).(*v1.EngineStatus)
+	return nil
+}
+
+// Get implements Engines.
+func (s *FileStore) Get(ctx context.Context, name string) (*v1.EngineStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status, ok := s.engines[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return proto.Clone(status).(*v1.EngineStatus), nil
+}
+
+// Find implements Engines.
+func (s *FileStore) Find(ctx context.Context, filter []*v1.FilterExpression, order []*v1.OrderExpression, start, limit int) ([]*v1.EngineStatus, int, error) {
+	s.mu.RLock()
+	all := make([]*v1.EngineStatus, 0, len(s.engines))
+	for _, status := range s.engines {
+		all = append(all, proto.Clone(status).(*v1.EngineStatus))
+	}
+	s.mu.RUnlock()
+	return find(all, filter, order, start, limit)
+}
+
+// StoreInputs implements Inputs.
+func (s *FileStore) StoreInputs(ctx context.Context, name string, inputs *EngineInputs) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	data, err := json.Marshal(inputs)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.inputsPath(name), data)
+}
+
+// GetInputs implements Inputs.
+func (s *FileStore) GetInputs(ctx context.Context, name string) (*EngineInputs, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, ErrNotFound
+	}
+	data, err := ioutil.ReadFile(s.inputsPath(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var inputs EngineInputs
+	if err := json.Unmarshal(data, &inputs); err != nil {
+		return nil, fmt.Errorf("cannot load inputs of %s: %w", name, err)
+	}
+	return &inputs, nil
+}
+
+func (s *FileStore) inputsPath(name string) string {
+	return filepath.Join(s.dir, inputsDir, name+".json")
+}
+
+// Open implements Logs.
+func (s *FileStore) Open(name string) (io.WriteCloser, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	// Register the live log before the file exists, so that readers
+	// finding the file always follow it while it is written.
+	log := s.live.open(name)
+	if log == nil {
+		return nil, ErrAlreadyExists
+	}
+	f, err := os.OpenFile(s.logPath(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		s.live.close(name, log)
+		if os.IsExist(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, err
+	}
+	return &liveWriter{WriteCloser: f, name: name, log: log, owner: &s.live}, nil
+}
+
+// Read implements Logs.
+func (s *FileStore) Read(name string) (io.ReadCloser, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	// Look up the live log first, so that logs completed in between are
+	// read to the end rather than followed forever.
+	log := s.live.get(name)
+	f, err := os.Open(s.logPath(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newFollowReader(f, log), nil
+}
+
+func (s *FileStore) logPath(name string) string {
+	return filepath.Join(s.dir, logsDir, name+".log")
+}
+
+// Next implements NumberGroup.
+func (s *FileStore) Next(group string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nr := s.numbers[group] + 1
+	s.numbers[group] = nr
+	data, err := json.Marshal(s.numbers)
+	if err == nil {
+		err = writeFile(filepath.Join(s.dir, numbersFile), data)
+	}
+	if err != nil {
+		s.numbers[group] = nr - 1
+		return 0, err
+	}
+	return nr, nil
+}
+
+// writeFile atomically replaces the file at path with data.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,257 @@
+package store
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newStatus(name string, created time.Time) *v1.EngineStatus {
+	return &v1.EngineStatus{
+		Name:     name,
+		Metadata: &v1.EngineMetadata{Owner: "bhojpur", Created: timestamppb.New(created)},
+		Phase:    v1.EnginePhase_PHASE_RUNNING,
+	}
+}
+
+func TestFileStoreEngines(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	now := time.Now()
+	for i, name := range []string{"a.1", "b.1", "c.1"} {
+		if err := s.Store(ctx, newStatus(name, now.Add(time.Duration(i)*time.Minute))); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+	if err := s.Store(ctx, newStatus("../escape", now)); err == nil {
+		t.Fatalf("expected invalid name to be rejected")
+	}
+	if _, err := s.Get(ctx, "d.1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// The store must be reloaded from disk.
+	s, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	st, err := s.Get(ctx, "b.1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if st.Metadata.Owner != "bhojpur" || st.Phase != v1.EnginePhase_PHASE_RUNNING {
+		t.Fatalf("unexpected status: %v", st)
+	}
+
+	slice, total, err := s.Find(ctx, nil, nil, 1, 1)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if total != 3 || len(slice) != 1 || slice[0].Name != "b.1" {
+		t.Fatalf("unexpected page: %v of %d", slice, total)
+	}
+	slice, _, err = s.Find(ctx, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(slice) != 3 || slice[0].Name != "c.1" || slice[2].Name != "a.1" {
+		t.Fatalf("unexpected order: %v", slice)
+	}
+}
+
+// testTransition checks that Transition only replaces a status in the
+// expected phase.
+func testTransition(t *testing.T, s Engines) {
+	ctx := context.Background()
+	st := newStatus("a.1", time.Now())
+	st.Phase = v1.EnginePhase_PHASE_WAITING
+	if err := s.Store(ctx, st); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	st.Phase = v1.EnginePhase_PHASE_PREPARING
+	if err := s.Transition(ctx, v1.EnginePhase_PHASE_WAITING, st); err != nil {
+		t.Fatalf("Transition: %v", err)
+	}
+	// A second claim of the waiting engine fails.
+	stopped := newStatus("a.1", time.Now())
+	stopped.Phase = v1.EnginePhase_PHASE_DONE
+	if err := s.Transition(ctx, v1.EnginePhase_PHASE_WAITING, stopped); !errors.Is(err, ErrPhaseChanged) {
+		t.Fatalf("expected ErrPhaseChanged, got %v", err)
+	}
+	if got, err := s.Get(ctx, "a.1"); err != nil || got.Phase != v1.EnginePhase_PHASE_PREPARING {
+		t.Fatalf("unexpected status: %v, %v", got, err)
+	}
+	stopped.Name = "b.1"
+	if err := s.Transition(ctx, v1.EnginePhase_PHASE_WAITING, stopped); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFileStoreTransition(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	testTransition(t, s)
+
+	s, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if st, err := s.Get(context.Background(), "a.1"); err != nil || st.Phase != v1.EnginePhase_PHASE_PREPARING {
+		t.Fatalf("transition was not persisted: %v, %v", st, err)
+	}
+}
+
+func TestFileStoreNumbers(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	for _, expected := range []int{1, 2} {
+		if nr, err := s.Next("local"); err != nil || nr != expected {
+			t.Fatalf("Next: %d, %v, expected %d", nr, err, expected)
+		}
+	}
+	s, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if nr, err := s.Next("local"); err != nil || nr != 3 {
+		t.Fatalf("Next after reload: %d, %v", nr, err)
+	}
+	if nr, err := s.Next("other"); err != nil || nr != 1 {
+		t.Fatalf("Next of other group: %d, %v", nr, err)
+	}
+}
+
+func TestFileStoreLogs(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if _, err := s.Read("a.1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	w, err := s.Open("a.1")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := s.Open("a.1"); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected ErrAlreadyExists, got %v", err)
+	}
+	io.WriteString(w, "first\n")
+
+	r, err := s.Read("a.1")
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	defer r.Close()
+	done := make(chan string)
+	go func() {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("ReadAll: %v", err)
+		}
+		done <- string(data)
+	}()
+
+	// The reader follows the logs until they are closed.
+	time.Sleep(10 * time.Millisecond)
+	io.WriteString(w, "second\n")
+	select {
+	case <-done:
+		t.Fatalf("reader did not follow the logs")
+	case <-time.After(10 * time.Millisecond):
+	}
+	w.Close()
+	select {
+	case data := <-done:
+		if data != "first\nsecond\n" {
+			t.Fatalf("unexpected logs: %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("reader did not finish after the logs were closed")
+	}
+
+	// Complete logs are read to the end.
+	r, err = s.Read("a.1")
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil || string(data) != "first\nsecond\n" {
+		t.Fatalf("unexpected complete logs: %q, %v", data, err)
+	}
+}
+
+func TestFileStoreInputs(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if _, err := s.GetInputs(ctx, "a.1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	inputs := &EngineInputs{
+		Metadata:    &v1.EngineMetadata{Owner: "bhojpur", Repository: &v1.Repository{Repo: "crypto"}},
+		EngineYAML:  []byte("steps: []"),
+		ConfigYAML:  []byte("engine: engine.yaml"),
+		NameSuffix:  "nightly",
+		Application: "sha256:abc",
+	}
+	if err := s.StoreInputs(ctx, "a.1", inputs); err != nil {
+		t.Fatalf("StoreInputs: %v", err)
+	}
+
+	s, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	res, err := s.GetInputs(ctx, "a.1")
+	if err != nil {
+		t.Fatalf("GetInputs: %v", err)
+	}
+	if res.Metadata.GetOwner() != "bhojpur" || res.Metadata.GetRepository().GetRepo() != "crypto" ||
+		string(res.EngineYAML) != "steps: []" || string(res.ConfigYAML) != "engine: engine.yaml" ||
+		res.NameSuffix != "nightly" || res.Application != inputs.Application {
+		t.Fatalf("unexpected inputs: %+v", res)
+	}
+}
@@ -0,0 +1,355 @@
+package store
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Filters and orders refer to the fields of an engine status by their
+// dotted proto names, such as "metadata.repository.repo", and to
+// annotations as "annotations.<key>". Enum fields have the values returned
+// by PhaseValue and TriggerValue, and timestamps are compared with filter
+// values formatted as TimeFormat.
+
+// TimeFormat is the format of timestamps compared with filter values: RFC
+// 3339 in UTC, to the second, such as "2021-03-04T05:06:07Z". Fractions of
+// a second are dropped, so that all stores format timestamps alike whatever
+// precision they keep. Orders compare timestamps in full.
+const TimeFormat = "2006-01-02T15:04:05Z"
+
+type fieldKind int
+
+const (
+	kindText fieldKind = iota
+	kindBool
+	kindInt
+	kindTime
+	kindAnnotation
+)
+
+// value is the value of a field of an engine status. Unset timestamps and
+// missing annotations are null.
+type value struct {
+	kind  fieldKind
+	null  bool
+	str   string
+	num   int64
+	stamp time.Time
+}
+
+func text(s string) value { return value{kind: kindText, str: s} }
+
+func boolean(b bool) value {
+	if b {
+		return value{kind: kindBool, num: 1}
+	}
+	return value{kind: kindBool}
+}
+
+func integer(i int32) value { return value{kind: kindInt, num: int64(i)} }
+
+func timestamp(ts *timestamppb.Timestamp) value {
+	if ts == nil {
+		return value{kind: kindTime, null: true}
+	}
+	return value{kind: kindTime, stamp: ts.AsTime()}
+}
+
+// String returns the value compared with the values of filter terms.
+func (v value) String() string {
+	switch {
+	case v.null:
+		return ""
+	case v.kind == kindBool:
+		return strconv.FormatBool(v.num != 0)
+	case v.kind == kindInt:
+		return strconv.FormatInt(v.num, 10)
+	case v.kind == kindTime:
+		return v.stamp.UTC().Format(TimeFormat)
+	}
+	return v.str
+}
+
+// exists returns true if the value is set. Following proto3, fields are
+// set if they do not have their zero value, while annotations are set if
+// they are present.
+func (v value) exists() bool {
+	switch {
+	case v.null:
+		return false
+	case v.kind == kindText:
+		return v.str != ""
+	case v.kind == kindBool, v.kind == kindInt:
+		return v.num != 0
+	}
+	return true
+}
+
+// compare returns -1, 0 or 1 if v is less than, equal to or greater than w.
+// Null values are less than all others.
+func (v value) compare(w value) int {
+	switch {
+	case v.null && w.null:
+		return 0
+	case v.null:
+		return -1
+	case w.null:
+		return 1
+	}
+	switch v.kind {
+	case kindBool, kindInt:
+		if v.num < w.num {
+			return -1
+		} else if v.num > w.num {
+			return 1
+		}
+		return 0
+	case kindTime:
+		if v.stamp.Before(w.stamp) {
+			return -1
+		} else if v.stamp.After(w.stamp) {
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(v.str, w.str)
+}
+
+type fieldFunc func(s *v1.EngineStatus) value
+
+// fields resolves the fields of an engine status other than annotations.
+var fields = map[string]fieldFunc{
+	"name":    func(s *v1.EngineStatus) value { return text(s.Name) },
+	"phase":   func(s *v1.EngineStatus) value { return text(PhaseValue(s.Phase)) },
+	"details": func(s *v1.EngineStatus) value { return text(s.Details) },
+
+	"metadata.owner":               func(s *v1.EngineStatus) value { return text(s.GetMetadata().GetOwner()) },
+	"metadata.repository.host":     func(s *v1.EngineStatus) value { return text(s.GetMetadata().GetRepository().GetHost()) },
+	"metadata.repository.owner":    func(s *v1.EngineStatus) value { return text(s.GetMetadata().GetRepository().GetOwner()) },
+	"metadata.repository.repo":     func(s *v1.EngineStatus) value { return text(s.GetMetadata().GetRepository().GetRepo()) },
+	"metadata.repository.ref":      func(s *v1.EngineStatus) value { return text(s.GetMetadata().GetRepository().GetRef()) },
+	"metadata.repository.revision": func(s *v1.EngineStatus) value { return text(s.GetMetadata().GetRepository().GetRevision()) },
+	"metadata.trigger":             func(s *v1.EngineStatus) value { return text(TriggerValue(s.GetMetadata().GetTrigger())) },
+	"metadata.created":             func(s *v1.EngineStatus) value { return timestamp(s.GetMetadata().GetCreated()) },
+	"metadata.finished":            func(s *v1.EngineStatus) value { return timestamp(s.GetMetadata().GetFinished()) },
+	"metadata.engine_spec_name":    func(s *v1.EngineStatus) value { return text(s.GetMetadata().GetEngineSpecName()) },
+
+	"conditions.success":       func(s *v1.EngineStatus) value { return boolean(s.GetConditions().GetSuccess()) },
+	"conditions.failure_count": func(s *v1.EngineStatus) value { return integer(s.GetConditions().GetFailureCount()) },
+	"conditions.can_replay":    func(s *v1.EngineStatus) value { return boolean(s.GetConditions().GetCanReplay()) },
+	"conditions.wait_until":    func(s *v1.EngineStatus) value { return timestamp(s.GetConditions().GetWaitUntil()) },
+	"conditions.did_execute":   func(s *v1.EngineStatus) value { return boolean(s.GetConditions().GetDidExecute()) },
+}
+
+const annotationsPrefix = "annotations."
+
+// resolve returns the function resolving a field.
+func resolve(field string) (fieldFunc, error) {
+	if strings.HasPrefix(field, annotationsPrefix) {
+		key := strings.TrimPrefix(field, annotationsPrefix)
+		return func(s *v1.EngineStatus) value {
+			for _, a := range s.GetMetadata().GetAnnotations() {
+				if a.Key == key {
+					return value{kind: kindAnnotation, str: a.Value}
+				}
+			}
+			return value{kind: kindAnnotation, null: true}
+		}, nil
+	}
+	f, ok := fields[field]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown field %q", ErrBadQuery, field)
+	}
+	return f, nil
+}
+
+// Filter evaluates filter expressions against engine statuses. An engine
+// matches if it matches all expressions, and it matches an expression if
+// it matches any of its terms.
+type Filter struct {
+	exprs [][]filterTerm
+}
+
+type filterTerm struct {
+	field  fieldFunc
+	op     v1.FilterOp
+	value  string
+	negate bool
+}
+
+// NewFilter compiles filter expressions, which must only refer to known
+// fields and operations. Expressions without terms are ignored.
+func NewFilter(filter []*v1.FilterExpression) (*Filter, error) {
+	var res Filter
+	for _, expr := range filter {
+		var terms []filterTerm
+		for _, t := range expr.Terms {
+			switch t.Operation {
+			case v1.FilterOp_OP_EQUALS, v1.FilterOp_OP_STARTS_WITH, v1.FilterOp_OP_ENDS_WITH,
+				v1.FilterOp_OP_CONTAINS, v1.FilterOp_OP_EXISTS:
+			default:
+				return nil, fmt.Errorf("%w: unsupported filter operation %v", ErrBadQuery, t.Operation)
+			}
+			f, err := resolve(t.Field)
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, filterTerm{field: f, op: t.Operation, value: t.Value, negate: t.Negate})
+		}
+		if len(terms) > 0 {
+			res.exprs = append(res.exprs, terms)
+		}
+	}
+	return &res, nil
+}
+
+// Match returns true if the engine matches the filter. All engines match
+// an empty filter.
+func (f *Filter) Match(s *v1.EngineStatus) bool {
+	for _, terms := range f.exprs {
+		var match bool
+		for _, t := range terms {
+			if t.match(s) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+func (t filterTerm) match(s *v1.EngineStatus) bool {
+	v := t.field(s)
+	var res bool
+	switch {
+	case t.op == v1.FilterOp_OP_EXISTS:
+		res = v.exists()
+	case v.null:
+		// Missing annotations and unset timestamps match no value.
+	case t.op == v1.FilterOp_OP_EQUALS:
+		res = v.String() == t.value
+	case t.op == v1.FilterOp_OP_STARTS_WITH:
+		res = strings.HasPrefix(v.String(), t.value)
+	case t.op == v1.FilterOp_OP_ENDS_WITH:
+		res = strings.HasSuffix(v.String(), t.value)
+	case t.op == v1.FilterOp_OP_CONTAINS:
+		res = strings.Contains(v.String(), t.value)
+	}
+	return res != t.negate
+}
+
+// Order sorts engine statuses by order expressions. Null values come first
+// in ascending order and last in descending order. Engines are ordered by
+// name to break ties.
+type Order struct {
+	keys []orderKey
+}
+
+type orderKey struct {
+	field     fieldFunc
+	ascending bool
+}
+
+// DefaultOrder is used if no order expressions are given: newest first.
+var DefaultOrder = []*v1.OrderExpression{{Field: "metadata.created"}}
+
+// NewOrder compiles order expressions, which must only refer to known
+// fields. If there are none, DefaultOrder is used.
+func NewOrder(order []*v1.OrderExpression) (*Order, error) {
+	if len(order) == 0 {
+		order = DefaultOrder
+	}
+	var res Order
+	for _, o := range order {
+		f, err := resolve(o.Field)
+		if err != nil {
+			return nil, err
+		}
+		res.keys = append(res.keys, orderKey{field: f, ascending: o.Ascending})
+	}
+	res.keys = append(res.keys, orderKey{field: fields["name"], ascending: true})
+	return &res, nil
+}
+
+// Less returns true if a is ordered before b.
+func (o *Order) Less(a, b *v1.EngineStatus) bool {
+	for _, k := range o.keys {
+		c := k.field(a).compare(k.field(b))
+		if c == 0 {
+			continue
+		}
+		return (c < 0) == k.ascending
+	}
+	return false
+}
+
+// Sort sorts engine statuses in place.
+func (o *Order) Sort(slice []*v1.EngineStatus) {
+	sort.Slice(slice, func(i, j int) bool { return o.Less(slice[i], slice[j]) })
+}
+
+// find filters, orders and paginates engines, returning the total number
+// of engines matching the filter along with the page.
+func find(all []*v1.EngineStatus, filter []*v1.FilterExpression, order []*v1.OrderExpression, start, limit int) ([]*v1.EngineStatus, int, error) {
+	f, err := NewFilter(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	o, err := NewOrder(order)
+	if err != nil {
+		return nil, 0, err
+	}
+	var res []*v1.EngineStatus
+	for _, s := range all {
+		if f.Match(s) {
+			res = append(res, s)
+		}
+	}
+	o.Sort(res)
+	return paginate(res, start, limit), len(res), nil
+}
+
+// paginate returns the page of slice starting at start with at most limit
+// elements, or all remaining elements if limit is not positive.
+func paginate(slice []*v1.EngineStatus, start, limit int) []*v1.EngineStatus {
+	if start < 0 {
+		start = 0
+	}
+	if start > len(slice) {
+		start = len(slice)
+	}
+	slice = slice[start:]
+	if limit > 0 && limit < len(slice) {
+		slice = slice[:limit]
+	}
+	return slice
+}
@@ -0,0 +1,209 @@
+package store
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func filterStatus() *v1.EngineStatus {
+	return &v1.EngineStatus{
+		Name:  "crypto-main.3",
+		Phase: v1.EnginePhase_PHASE_RUNNING,
+		Metadata: &v1.EngineMetadata{
+			Owner:       "bhojpur",
+			Repository:  &v1.Repository{Host: "github.com", Owner: "bhojpur", Repo: "crypto", Ref: "refs/heads/main"},
+			Trigger:     v1.EngineTrigger_TRIGGER_MANUAL,
+			Created:     timestamppb.New(time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)),
+			Annotations: []*v1.Annotation{{Key: "ci", Value: ""}, {Key: "os", Value: "linux"}},
+		},
+		Conditions: &v1.EngineConditions{FailureCount: 2},
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	term := func(field string, op v1.FilterOp, value string) *v1.FilterTerm {
+		return &v1.FilterTerm{Field: field, Operation: op, Value: value}
+	}
+	expr := func(terms ...*v1.FilterTerm) *v1.FilterExpression {
+		return &v1.FilterExpression{Terms: terms}
+	}
+	negate := func(t *v1.FilterTerm) *v1.FilterTerm {
+		t.Negate = true
+		return t
+	}
+	tests := []struct {
+		Name   string
+		Filter []*v1.FilterExpression
+		Match  bool
+	}{
+		{"empty", nil, true},
+		{"empty expression", []*v1.FilterExpression{expr()}, true},
+		{"equals", []*v1.FilterExpression{expr(term("metadata.owner", v1.FilterOp_OP_EQUALS, "bhojpur"))}, true},
+		{"equals mismatch", []*v1.FilterExpression{expr(term("metadata.owner", v1.FilterOp_OP_EQUALS, "bhoj"))}, false},
+		{"phase", []*v1.FilterExpression{expr(term("phase", v1.FilterOp_OP_EQUALS, "running"))}, true},
+		{"trigger", []*v1.FilterExpression{expr(term("metadata.trigger", v1.FilterOp_OP_EQUALS, "manual"))}, true},
+		{"starts with", []*v1.FilterExpression{expr(term("metadata.repository.ref", v1.FilterOp_OP_STARTS_WITH, "refs/heads/"))}, true},
+		{"ends with", []*v1.FilterExpression{expr(term("name", v1.FilterOp_OP_ENDS_WITH, ".3"))}, true},
+		{"contains", []*v1.FilterExpression{expr(term("metadata.repository.repo", v1.FilterOp_OP_CONTAINS, "ryp"))}, true},
+		{"integer", []*v1.FilterExpression{expr(term("conditions.failure_count", v1.FilterOp_OP_EQUALS, "2"))}, true},
+		{"boolean", []*v1.FilterExpression{expr(term("conditions.success", v1.FilterOp_OP_EQUALS, "false"))}, true},
+		{"timestamp", []*v1.FilterExpression{expr(term("metadata.created", v1.FilterOp_OP_STARTS_WITH, "2021-03-04T"))}, true},
+		{"negate", []*v1.FilterExpression{expr(negate(term("phase", v1.FilterOp_OP_EQUALS, "done")))}, true},
+		{"or", []*v1.FilterExpression{expr(
+			term("phase", v1.FilterOp_OP_EQUALS, "done"),
+			term("phase", v1.FilterOp_OP_EQUALS, "running"),
+		)}, true},
+		{"and", []*v1.FilterExpression{
+			expr(term("phase", v1.FilterOp_OP_EQUALS, "running")),
+			expr(term("metadata.owner", v1.FilterOp_OP_EQUALS, "someone")),
+		}, false},
+		{"exists text", []*v1.FilterExpression{expr(term("metadata.repository.revision", v1.FilterOp_OP_EXISTS, ""))}, false},
+		{"exists integer", []*v1.FilterExpression{expr(term("conditions.failure_count", v1.FilterOp_OP_EXISTS, ""))}, true},
+		{"exists boolean", []*v1.FilterExpression{expr(term("conditions.can_replay", v1.FilterOp_OP_EXISTS, ""))}, false},
+		{"exists timestamp", []*v1.FilterExpression{expr(term("metadata.finished", v1.FilterOp_OP_EXISTS, ""))}, false},
+		{"exists empty annotation", []*v1.FilterExpression{expr(term("annotations.ci", v1.FilterOp_OP_EXISTS, ""))}, true},
+		{"missing annotation", []*v1.FilterExpression{expr(term("annotations.arch", v1.FilterOp_OP_EXISTS, ""))}, false},
+		{"annotation", []*v1.FilterExpression{expr(term("annotations.os", v1.FilterOp_OP_EQUALS, "linux"))}, true},
+		{"negated missing annotation", []*v1.FilterExpression{expr(negate(term("annotations.arch", v1.FilterOp_OP_EQUALS, "")))}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			f, err := NewFilter(test.Filter)
+			if err != nil {
+				t.Fatalf("NewFilter: %v", err)
+			}
+			if match := f.Match(filterStatus()); match != test.Match {
+				t.Fatalf("Match returned %v, expected %v", match, test.Match)
+			}
+		})
+	}
+}
+
+func TestBadQuery(t *testing.T) {
+	_, err := NewFilter([]*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "metadata"}}}})
+	if !errors.Is(err, ErrBadQuery) {
+		t.Fatalf("expected ErrBadQuery for unknown field, got %v", err)
+	}
+	_, err = NewFilter([]*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "name", Operation: 42}}}})
+	if !errors.Is(err, ErrBadQuery) {
+		t.Fatalf("expected ErrBadQuery for unknown operation, got %v", err)
+	}
+	_, err = NewOrder([]*v1.OrderExpression{{Field: "results"}})
+	if !errors.Is(err, ErrBadQuery) {
+		t.Fatalf("expected ErrBadQuery for unknown order field, got %v", err)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	now := time.Now()
+	engine := func(name string, created time.Time, failures int32, prio string) *v1.EngineStatus {
+		s := &v1.EngineStatus{Name: name, Metadata: &v1.EngineMetadata{}, Conditions: &v1.EngineConditions{FailureCount: failures}}
+		if !created.IsZero() {
+			s.Metadata.Created = timestamppb.New(created)
+		}
+		if prio != "" {
+			s.Metadata.Annotations = []*v1.Annotation{{Key: "prio", Value: prio}}
+		}
+		return s
+	}
+	engines := []*v1.EngineStatus{
+		engine("a", now, 10, "2"),
+		engine("b", time.Time{}, 2, ""),
+		engine("c", now.Add(time.Minute), 2, "1"),
+		engine("d", now, 1, ""),
+	}
+	tests := []struct {
+		Name     string
+		Order    []*v1.OrderExpression
+		Expected string
+	}{
+		{"default", nil, "cadb"},
+		{"ascending", []*v1.OrderExpression{{Field: "metadata.created", Ascending: true}}, "badc"},
+		{"numeric", []*v1.OrderExpression{{Field: "conditions.failure_count", Ascending: true}}, "dbca"},
+		{"annotation", []*v1.OrderExpression{{Field: "annotations.prio"}}, "acbd"},
+		{"several", []*v1.OrderExpression{
+			{Field: "conditions.failure_count"},
+			{Field: "name"},
+		}, "acbd"},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			o, err := NewOrder(test.Order)
+			if err != nil {
+				t.Fatalf("NewOrder: %v", err)
+			}
+			sorted := append([]*v1.EngineStatus(nil), engines...)
+			o.Sort(sorted)
+			var names string
+			for _, s := range sorted {
+				names += s.Name
+			}
+			if names != test.Expected {
+				t.Fatalf("unexpected order %s, expected %s", names, test.Expected)
+			}
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	now := time.Now()
+	for i, name := range []string{"a.1", "b.1", "c.1"} {
+		if err := s.Store(ctx, newStatus(name, now.Add(time.Duration(i)*time.Minute))); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+	if _, err := s.Get(ctx, "d.1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	done := newStatus("b.1", now)
+	done.Phase = v1.EnginePhase_PHASE_DONE
+	if err := s.Store(ctx, done); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	running := []*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "phase", Value: "running"}}}}
+	slice, total, err := s.Find(ctx, running, nil, 1, 1)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if total != 2 || len(slice) != 1 || slice[0].Name != "a.1" {
+		t.Fatalf("unexpected page: %v of %d", slice, total)
+	}
+
+	for _, expected := range []int{1, 2} {
+		if nr, err := s.Next("local"); err != nil || nr != expected {
+			t.Fatalf("Next: %d, %v, expected %d", nr, err, expected)
+		}
+	}
+}
+
+func TestMemoryStoreTransition(t *testing.T) {
+	testTransition(t, NewMemoryStore())
+}
@@ -0,0 +1,157 @@
+package store
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"io"
+	"sync"
+)
+
+// liveLogs tracks the logs that are being written, so that readers can
+// follow them.
+type liveLogs struct {
+	mu   sync.Mutex
+	logs map[string]*liveLog
+}
+
+// liveLog signals writes to the logs of one engine.
+type liveLog struct {
+	mu      sync.Mutex
+	changed chan struct{}
+	closed  bool
+}
+
+// open registers a new live log, or returns nil if the logs of the engine
+// are being written already.
+func (l *liveLogs) open(name string) *liveLog {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.logs == nil {
+		l.logs = map[string]*liveLog{}
+	}
+	if _, exists := l.logs[name]; exists {
+		return nil
+	}
+	log := &liveLog{changed: make(chan struct{})}
+	l.logs[name] = log
+	return log
+}
+
+// get returns the live log of an engine, or nil if its logs are complete.
+func (l *liveLogs) get(name string) *liveLog {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.logs[name]
+}
+
+// close marks the live log of an engine as complete.
+func (l *liveLogs) close(name string, log *liveLog) {
+	l.mu.Lock()
+	if l.logs[name] == log {
+		delete(l.logs, name)
+	}
+	l.mu.Unlock()
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.closed = true
+	close(log.changed)
+}
+
+// notify wakes up the readers waiting for more output.
+func (log *liveLog) notify() {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.closed {
+		return
+	}
+	close(log.changed)
+	log.changed = make(chan struct{})
+}
+
+func (log *liveLog) state() (changed <-chan struct{}, closed bool) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.changed, log.closed
+}
+
+// liveWriter notifies readers of a live log after each write.
+type liveWriter struct {
+	io.WriteCloser
+	name  string
+	log   *liveLog
+	owner *liveLogs
+	once  sync.Once
+}
+
+func (w *liveWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	if n > 0 {
+		w.log.notify()
+	}
+	return n, err
+}
+
+func (w *liveWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.once.Do(func() { w.owner.close(w.name, w.log) })
+	return err
+}
+
+// followReader reads logs, waiting for more output at the end of a live
+// log until it is closed.
+type followReader struct {
+	r    io.ReadCloser
+	log  *liveLog
+	done chan struct{}
+	once sync.Once
+}
+
+// newFollowReader returns a reader for r following log, which may be nil if
+// the logs are complete.
+func newFollowReader(r io.ReadCloser, log *liveLog) io.ReadCloser {
+	if log == nil {
+		return r
+	}
+	return &followReader{r: r, log: log, done: make(chan struct{})}
+}
+
+func (f *followReader) Read(p []byte) (int, error) {
+	for {
+		// Take the state before reading, so that a write between the read
+		// and the wait is not missed.
+		changed, closed := f.log.state()
+		n, err := f.r.Read(p)
+		if n > 0 || err != io.EOF || closed {
+			return n, err
+		}
+		select {
+		case <-changed:
+		case <-f.done:
+			return 0, io.ErrClosedPipe
+		}
+	}
+}
+
+func (f *followReader) Close() error {
+	f.once.Do(func() { close(f.done) })
+	return f.r.Close()
+}
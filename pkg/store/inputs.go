@@ -0,0 +1,92 @@
+package store
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/digest"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// inputsJSON is the JSON encoding of EngineInputs.
+type inputsJSON struct {
+	Metadata    json.RawMessage `json:"metadata,omitempty"`
+	EngineYAML  []byte          `json:"engineYaml,omitempty"`
+	ConfigYAML  []byte          `json:"configYaml,omitempty"`
+	NameSuffix  string          `json:"nameSuffix,omitempty"`
+	Application digest.Digest   `json:"application,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (in *EngineInputs) MarshalJSON() ([]byte, error) {
+	enc := inputsJSON{
+		EngineYAML:  in.EngineYAML,
+		ConfigYAML:  in.ConfigYAML,
+		NameSuffix:  in.NameSuffix,
+		Application: in.Application,
+	}
+	if in.Metadata != nil {
+		md, err := protojson.Marshal(in.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		enc.Metadata = md
+	}
+	return json.Marshal(enc)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (in *EngineInputs) UnmarshalJSON(data []byte) error {
+	var enc inputsJSON
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return err
+	}
+	*in = EngineInputs{
+		EngineYAML:  enc.EngineYAML,
+		ConfigYAML:  enc.ConfigYAML,
+		NameSuffix:  enc.NameSuffix,
+		Application: enc.Application,
+	}
+	if len(enc.Metadata) > 0 {
+		in.Metadata = &v1.EngineMetadata{}
+		if err := protojson.Unmarshal(enc.Metadata, in.Metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clone returns a deep copy of the inputs.
+func (in *EngineInputs) clone() *EngineInputs {
+	res := &EngineInputs{
+		EngineYAML:  append([]byte(nil), in.EngineYAML...),
+		ConfigYAML:  append([]byte(nil), in.ConfigYAML...),
+		NameSuffix:  in.NameSuffix,
+		Application: in.Application,
+	}
+	if in.Metadata != nil {
+		res.Metadata = proto.Clone(in.Metadata).(*v1.EngineMetadata)
+	}
+	return res
+}
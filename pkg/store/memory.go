@@ -0,0 +1,131 @@
+package store
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"sync"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// MemoryStore keeps engines, their inputs and number groups in memory. It is meant for
+// tests and for servers which need not remember engines across restarts.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	engines map[string]*v1.EngineStatus
+	inputs  map[string]*EngineInputs
+	numbers map[string]int
+}
+
+var (
+	_ Engines     = &MemoryStore{}
+	_ Inputs      = &MemoryStore{}
+	_ NumberGroup = &MemoryStore{}
+)
+
+// NewMemoryStore returns an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		engines: map[string]*v1.EngineStatus{},
+		inputs:  map[string]*EngineInputs{},
+		numbers: map[string]int{},
+	}
+}
+
+// Store implements Engines.
+func (s *MemoryStore) Store(ctx context.Context, status *v1.EngineStatus) error {
+	if err := ValidateName(status.Name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engines[status.Name] = proto.Clone(status).(*v1.EngineStatus)
+	return nil
+}
+
+// Transition implements Engines.
+func (s *MemoryStore) Transition(ctx context.Context, from v1.EnginePhase, status *v1.EngineStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.engines[status.Name]
+	if !ok {
+		return ErrNotFound
+	}
+	if old.Phase != from {
+		return ErrPhaseChanged
+	}
+	s.engines[status.Name] = proto.Clone(status).(*v1.EngineStatus)
+	return nil
+}
+
+// Get implements Engines.
+func (s *MemoryStore) Get(ctx context.Context, name string) (*v1.EngineStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status, ok := s.engines[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return proto.Clone(status).(*v1.EngineStatus), nil
+}
+
+// Find implements Engines.
+func (s *MemoryStore) Find(ctx context.Context, filter []*v1.FilterExpression, order []*v1.OrderExpression, start, limit int) ([]*v1.EngineStatus, int, error) {
+	s.mu.RLock()
+	all := make([]*v1.EngineStatus, 0, len(s.engines))
+	for _, status := range s.engines {
+		all = append(all, proto.Clone(status).(*v1.EngineStatus))
+	}
+	s.mu.RUnlock()
+	return find(all, filter, order, start, limit)
+}
+
+// StoreInputs implements Inputs.
+func (s *MemoryStore) StoreInputs(ctx context.Context, name string, inputs *EngineInputs) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inputs[name] = inputs.clone()
+	return nil
+}
+
+// GetInputs implements Inputs.
+func (s *MemoryStore) GetInputs(ctx context.Context, name string) (*EngineInputs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	inputs, ok := s.inputs[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return inputs.clone(), nil
+}
+
+// Next implements NumberGroup.
+func (s *MemoryStore) Next(group string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.numbers[group]++
+	return s.numbers[group], nil
+}
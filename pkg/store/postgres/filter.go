@@ -0,0 +1,219 @@
+package postgres
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/store"
+)
+
+// fieldKind is the SQL type of a field, which determines how it is compared
+// with filter values and what it means for it to exist.
+type fieldKind int
+
+const (
+	kindText fieldKind = iota
+	kindBool
+	kindInt
+	kindTime
+)
+
+type field struct {
+	expr string
+	kind fieldKind
+}
+
+// fields maps filter and order fields to columns of the engines (e),
+// engine_metadata (m) and engine_conditions (c) tables. Annotations are
+// handled separately.
+var fields = map[string]field{
+	"name":                         {"e.name", kindText},
+	"phase":                        {"e.phase", kindText},
+	"details":                      {"e.details", kindText},
+	"metadata.owner":               {"m.owner", kindText},
+	"metadata.repository.host":     {"m.repository_host", kindText},
+	"metadata.repository.owner":    {"m.repository_owner", kindText},
+	"metadata.repository.repo":     {"m.repository_repo", kindText},
+	"metadata.repository.ref":      {"m.repository_ref", kindText},
+	"metadata.repository.revision": {"m.repository_revision", kindText},
+	"metadata.trigger":             {"m.trigger", kindText},
+	"metadata.created":             {"m.created", kindTime},
+	"metadata.finished":            {"m.finished", kindTime},
+	"metadata.engine_spec_name":    {"m.engine_spec_name", kindText},
+	"conditions.success":           {"c.success", kindBool},
+	"conditions.failure_count":     {"c.failure_count", kindInt},
+	"conditions.can_replay":        {"c.can_replay", kindBool},
+	"conditions.wait_until":        {"c.wait_until", kindTime},
+	"conditions.did_execute":       {"c.did_execute", kindBool},
+}
+
+const annotationsPrefix = "annotations."
+
+// timeFormat is store.TimeFormat in the notation of to_char.
+const timeFormat = `YYYY-MM-DD"T"HH24:MI:SS"Z"`
+
+// query accumulates the arguments of a parameterized query.
+type query struct {
+	args []interface{}
+}
+
+// arg adds an argument and returns its placeholder.
+func (q *query) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// where translates filter expressions into a WHERE clause. All expressions
+// must match, and an expression matches if any of its terms matches.
+func (q *query) where(filter []*v1.FilterExpression) (string, error) {
+	var exprs []string
+	for _, f := range filter {
+		var terms []string
+		for _, t := range f.Terms {
+			term, err := q.term(t)
+			if err != nil {
+				return "", err
+			}
+			terms = append(terms, term)
+		}
+		if len(terms) > 0 {
+			exprs = append(exprs, "("+strings.Join(terms, " OR ")+")")
+		}
+	}
+	if len(exprs) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(exprs, " AND "), nil
+}
+
+// term translates a single filter term into a condition.
+func (q *query) term(t *v1.FilterTerm) (string, error) {
+	var cond string
+	if strings.HasPrefix(t.Field, annotationsPrefix) {
+		key := strings.TrimPrefix(t.Field, annotationsPrefix)
+		cond = "a.key = " + q.arg(key)
+		if t.Operation != v1.FilterOp_OP_EXISTS {
+			match, err := q.match("a.value", t)
+			if err != nil {
+				return "", err
+			}
+			cond += " AND " + match
+		}
+		cond = "EXISTS (SELECT 1 FROM engine_annotations a WHERE a.engine = e.name AND " + cond + ")"
+	} else {
+		f, ok := fields[t.Field]
+		if !ok {
+			return "", fmt.Errorf("%w: cannot filter by unknown field %q", store.ErrBadQuery, t.Field)
+		}
+		if t.Operation == v1.FilterOp_OP_EXISTS {
+			cond = exists(f)
+		} else {
+			expr := f.expr
+			switch f.kind {
+			case kindTime:
+				expr = "to_char(" + expr + " AT TIME ZONE 'UTC', '" + timeFormat + "')"
+			case kindBool, kindInt:
+				expr = "CAST(" + expr + " AS text)"
+			}
+			var err error
+			if cond, err = q.match(expr, t); err != nil {
+				return "", err
+			}
+		}
+	}
+	if t.Negate {
+		// Conditions on null timestamps are null, yet negating a term
+		// must match engines the term does not match.
+		cond = "NOT COALESCE(" + cond + ", false)"
+	}
+	return cond, nil
+}
+
+// exists returns the condition under which a field is considered set,
+// following proto3 semantics where the zero value means unset.
+func exists(f field) string {
+	switch f.kind {
+	case kindBool:
+		return f.expr
+	case kindInt:
+		return f.expr + " <> 0"
+	case kindTime:
+		return f.expr + " IS NOT NULL"
+	}
+	return f.expr + " <> ''"
+}
+
+// match returns the condition comparing a text expression with the value
+// of a filter term.
+func (q *query) match(expr string, t *v1.FilterTerm) (string, error) {
+	switch t.Operation {
+	case v1.FilterOp_OP_EQUALS:
+		return expr + " = " + q.arg(t.Value), nil
+	case v1.FilterOp_OP_STARTS_WITH:
+		return expr + " LIKE " + q.arg(escapeLike(t.Value)+"%"), nil
+	case v1.FilterOp_OP_ENDS_WITH:
+		return expr + " LIKE " + q.arg("%"+escapeLike(t.Value)), nil
+	case v1.FilterOp_OP_CONTAINS:
+		return expr + " LIKE " + q.arg("%"+escapeLike(t.Value)+"%"), nil
+	}
+	return "", fmt.Errorf("%w: unsupported filter operation %v", store.ErrBadQuery, t.Operation)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes the wildcards of a LIKE pattern, using the default
+// escape character.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// orderBy translates order expressions into an ORDER BY clause. Engines
+// are ordered newest first by default, and by name to break ties.
+func (q *query) orderBy(order []*v1.OrderExpression) (string, error) {
+	var exprs []string
+	for _, o := range order {
+		var expr string
+		if strings.HasPrefix(o.Field, annotationsPrefix) {
+			key := strings.TrimPrefix(o.Field, annotationsPrefix)
+			expr = "(SELECT a.value FROM engine_annotations a WHERE a.engine = e.name AND a.key = " + q.arg(key) + ")"
+		} else {
+			f, ok := fields[o.Field]
+			if !ok {
+				return "", fmt.Errorf("%w: cannot order by unknown field %q", store.ErrBadQuery, o.Field)
+			}
+			expr = f.expr
+		}
+		if o.Ascending {
+			expr += " ASC NULLS FIRST"
+		} else {
+			expr += " DESC NULLS LAST"
+		}
+		exprs = append(exprs, expr)
+	}
+	if len(exprs) == 0 {
+		exprs = append(exprs, "m.created DESC NULLS LAST")
+	}
+	exprs = append(exprs, "e.name ASC")
+	return "ORDER BY " + strings.Join(exprs, ", "), nil
+}
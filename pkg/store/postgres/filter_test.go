@@ -0,0 +1,164 @@
+package postgres
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/store"
+)
+
+func TestWhere(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Filter []*v1.FilterExpression
+		SQL    string
+		Args   []interface{}
+	}{
+		{
+			Name: "empty",
+		},
+		{
+			Name: "and of or",
+			Filter: []*v1.FilterExpression{
+				{Terms: []*v1.FilterTerm{
+					{Field: "phase", Value: "running"},
+					{Field: "phase", Value: "preparing"},
+				}},
+				{Terms: []*v1.FilterTerm{
+					{Field: "metadata.repository.ref", Value: "refs/heads/", Operation: v1.FilterOp_OP_STARTS_WITH},
+				}},
+			},
+			SQL:  `WHERE (e.phase = $1 OR e.phase = $2) AND (m.repository_ref LIKE $3)`,
+			Args: []interface{}{"running", "preparing", "refs/heads/%"},
+		},
+		{
+			Name: "escaped like",
+			Filter: []*v1.FilterExpression{{Terms: []*v1.FilterTerm{
+				{Field: "name", Value: `50%_a\b`, Operation: v1.FilterOp_OP_CONTAINS},
+				{Field: "name", Value: ".1", Operation: v1.FilterOp_OP_ENDS_WITH, Negate: true},
+			}}},
+			SQL:  `WHERE (e.name LIKE $1 OR NOT COALESCE(e.name LIKE $2, false))`,
+			Args: []interface{}{`%50\%\_a\\b%`, "%.1"},
+		},
+		{
+			Name: "non text",
+			Filter: []*v1.FilterExpression{{Terms: []*v1.FilterTerm{
+				{Field: "conditions.success", Value: "true"},
+				{Field: "conditions.failure_count", Operation: v1.FilterOp_OP_EXISTS},
+				{Field: "metadata.finished", Operation: v1.FilterOp_OP_EXISTS, Negate: true},
+			}}},
+			SQL:  `WHERE (CAST(c.success AS text) = $1 OR c.failure_count <> 0 OR NOT COALESCE(m.finished IS NOT NULL, false))`,
+			Args: []interface{}{"true"},
+		},
+		{
+			Name: "timestamp",
+			Filter: []*v1.FilterExpression{{Terms: []*v1.FilterTerm{
+				{Field: "metadata.finished", Value: "2021-03-04T", Operation: v1.FilterOp_OP_STARTS_WITH, Negate: true},
+			}}},
+			SQL:  `WHERE (NOT COALESCE(to_char(m.finished AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"') LIKE $1, false))`,
+			Args: []interface{}{"2021-03-04T%"},
+		},
+		{
+			Name: "annotations",
+			Filter: []*v1.FilterExpression{
+				{Terms: []*v1.FilterTerm{{Field: "annotations.ci", Operation: v1.FilterOp_OP_EXISTS}}},
+				{Terms: []*v1.FilterTerm{{Field: "annotations.os", Value: "linux"}}},
+			},
+			SQL: `WHERE (EXISTS (SELECT 1 FROM engine_annotations a WHERE a.engine = e.name AND a.key = $1)) AND ` +
+				`(EXISTS (SELECT 1 FROM engine_annotations a WHERE a.engine = e.name AND a.key = $2 AND a.value = $3))`,
+			Args: []interface{}{"ci", "os", "linux"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var q query
+			sql, err := q.where(test.Filter)
+			if err != nil {
+				t.Fatalf("where: %v", err)
+			}
+			if sql != test.SQL {
+				t.Fatalf("unexpected SQL: %s\nexpected: %s", sql, test.SQL)
+			}
+			if !reflect.DeepEqual(q.args, test.Args) {
+				t.Fatalf("unexpected args: %v, expected %v", q.args, test.Args)
+			}
+		})
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Order []*v1.OrderExpression
+		SQL   string
+		Args  []interface{}
+	}{
+		{
+			Name: "default",
+			SQL:  `ORDER BY m.created DESC NULLS LAST, e.name ASC`,
+		},
+		{
+			Name: "fields",
+			Order: []*v1.OrderExpression{
+				{Field: "phase", Ascending: true},
+				{Field: "annotations.prio"},
+			},
+			SQL: `ORDER BY e.phase ASC NULLS FIRST, ` +
+				`(SELECT a.value FROM engine_annotations a WHERE a.engine = e.name AND a.key = $1) DESC NULLS LAST, e.name ASC`,
+			Args: []interface{}{"prio"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var q query
+			sql, err := q.orderBy(test.Order)
+			if err != nil {
+				t.Fatalf("orderBy: %v", err)
+			}
+			if sql != test.SQL {
+				t.Fatalf("unexpected SQL: %s\nexpected: %s", sql, test.SQL)
+			}
+			if !reflect.DeepEqual(q.args, test.Args) {
+				t.Fatalf("unexpected args: %v, expected %v", q.args, test.Args)
+			}
+		})
+	}
+}
+
+func TestBadQuery(t *testing.T) {
+	var q query
+	_, err := q.where([]*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "metadata.nope"}}}})
+	if !errors.Is(err, store.ErrBadQuery) {
+		t.Fatalf("expected ErrBadQuery for unknown filter field, got %v", err)
+	}
+	_, err = q.where([]*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "name", Operation: 42}}}})
+	if !errors.Is(err, store.ErrBadQuery) {
+		t.Fatalf("expected ErrBadQuery for unknown operation, got %v", err)
+	}
+	_, err = q.orderBy([]*v1.OrderExpression{{Field: "results"}})
+	if !errors.Is(err, store.ErrBadQuery) {
+		t.Fatalf("expected ErrBadQuery for unknown order field, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package postgres
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrationLock is the advisory lock held while migrating, so that server
+// replicas starting at the same time do not apply migrations twice.
+const migrationLock = 0x63727970746f
+
+// migrations are applied in order. Applied migrations must never change,
+// new ones are appended.
+var migrations = []string{
+	`CREATE TABLE engines (
+		name    text PRIMARY KEY,
+		phase   text NOT NULL,
+		details text NOT NULL DEFAULT ''
+	);
+	CREATE TABLE engine_metadata (
+		engine              text PRIMARY KEY REFERENCES engines (name) ON DELETE CASCADE,
+		owner               text NOT NULL DEFAULT '',
+		repository_host     text NOT NULL DEFAULT '',
+		repository_owner    text NOT NULL DEFAULT '',
+		repository_repo     text NOT NULL DEFAULT '',
+		repository_ref      text NOT NULL DEFAULT '',
+		repository_revision text NOT NULL DEFAULT '',
+		trigger             text NOT NULL DEFAULT '',
+		created             timestamptz,
+		finished            timestamptz,
+		engine_spec_name    text NOT NULL DEFAULT ''
+	);
+	CREATE INDEX engine_metadata_created ON engine_metadata (created);
+	CREATE TABLE engine_annotations (
+		engine text NOT NULL REFERENCES engines (name) ON DELETE CASCADE,
+		key    text NOT NULL,
+		value  text NOT NULL DEFAULT '',
+		PRIMARY KEY (engine, key)
+	);
+	CREATE INDEX engine_annotations_key ON engine_annotations (key, value);
+	CREATE TABLE engine_conditions (
+		engine        text PRIMARY KEY REFERENCES engines (name) ON DELETE CASCADE,
+		success       boolean NOT NULL DEFAULT false,
+		failure_count integer NOT NULL DEFAULT 0,
+		can_replay    boolean NOT NULL DEFAULT false,
+		wait_until    timestamptz,
+		did_execute   boolean NOT NULL DEFAULT false
+	);
+	CREATE TABLE engine_results (
+		engine      text NOT NULL REFERENCES engines (name) ON DELETE CASCADE,
+		position    integer NOT NULL,
+		type        text NOT NULL DEFAULT '',
+		payload     text NOT NULL DEFAULT '',
+		description text NOT NULL DEFAULT '',
+		channels    text[] NOT NULL DEFAULT '{}',
+		PRIMARY KEY (engine, position)
+	);
+	CREATE TABLE number_groups (
+		name   text PRIMARY KEY,
+		number integer NOT NULL
+	);`,
+	// Inputs are stored before the first status of an engine, so they do
+	// not reference the engines table.
+	`CREATE TABLE engine_inputs (
+		engine text PRIMARY KEY,
+		inputs jsonb NOT NULL
+	);`,
+}
+
+// Migrate brings the schema of the database up to date.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		applied timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this server supports (%d)", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			return fmt.Errorf("cannot apply migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,117 @@
+package postgres
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// notifyChannel is the channel on which every stored engine status is
+// announced, so that server replicas sharing a database learn of each
+// other's updates. The payload is the ID of the announcing Store and the
+// name of the engine, separated by a space.
+const notifyChannel = "crypto_engines"
+
+// pingInterval is how often an idle relay checks its connection.
+const pingInterval = 90 * time.Second
+
+// newID returns a random ID for a Store.
+func newID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// parseNotification returns the store ID and engine name of a payload.
+func parseNotification(payload string) (id, name string, ok bool) {
+	i := strings.IndexByte(payload, ' ')
+	if i < 0 {
+		return "", "", false
+	}
+	return payload[:i], payload[i+1:], true
+}
+
+// Relay listens for the statuses stored by other Stores sharing the
+// database and passes them to publish, until ctx is done. Statuses stored
+// through s itself are skipped, as their publisher already knows of them.
+// dsn must describe the database of s.
+//
+// Notifications sent while the connection is lost are missed. Once it is
+// back, the statuses of all engines which are not done are passed to
+// publish again.
+func (s *Store) Relay(ctx context.Context, dsn string, publish func(*v1.EngineStatus)) error {
+	l := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.WithError(err).Warn("engine status relay connection problem")
+		}
+	})
+	defer l.Close()
+	if err := l.Listen(notifyChannel); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case n := <-l.Notify:
+			if n == nil {
+				s.resync(ctx, publish)
+				continue
+			}
+			id, name, ok := parseNotification(n.Extra)
+			if !ok || id == s.id {
+				continue
+			}
+			status, err := s.Get(ctx, name)
+			if err != nil {
+				log.WithError(err).WithField("name", name).Warn("cannot relay engine status")
+				continue
+			}
+			publish(status)
+		case <-time.After(pingInterval):
+			go l.Ping()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// resync publishes the statuses of all engines which are not done.
+func (s *Store) resync(ctx context.Context, publish func(*v1.EngineStatus)) {
+	unfinished := []*v1.FilterExpression{{Terms: []*v1.FilterTerm{{Field: "phase", Value: "done", Negate: true}}}}
+	engines, _, err := s.Find(ctx, unfinished, nil, 0, 0)
+	if err != nil {
+		log.WithError(err).Warn("cannot resync engine statuses after reconnecting")
+		return
+	}
+	for _, status := range engines {
+		publish(status)
+	}
+}
@@ -0,0 +1,414 @@
+package postgres
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package postgres stores the status and inputs of engines in a PostgreSQL
+// database.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/store"
+	"github.com/lib/pq"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Store implements store.Engines, store.Inputs and store.NumberGroup on a
+// database.
+type Store struct {
+	db *sql.DB
+	id string
+}
+
+var (
+	_ store.Engines     = &Store{}
+	_ store.Inputs      = &Store{}
+	_ store.NumberGroup = &Store{}
+)
+
+// Open connects to the database described by dsn and migrates its schema.
+func Open(ctx context.Context, dsn string) (*Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := Migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return New(db), nil
+}
+
+// New returns a Store on a database with an up to date schema.
+func New(db *sql.DB) *Store {
+	return &Store{db: db, id: newID()}
+}
+
+// DB returns the database of the store.
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Store implements store.Engines.
+func (s *Store) Store(ctx context.Context, status *v1.EngineStatus) error {
+	if err := store.ValidateName(status.Name); err != nil {
+		return err
+	}
+	return s.store(ctx, nil, status)
+}
+
+// Transition implements store.Engines.
+func (s *Store) Transition(ctx context.Context, from v1.EnginePhase, status *v1.EngineStatus) error {
+	return s.store(ctx, &from, status)
+}
+
+// store writes status in a transaction. If from is not nil, the stored
+// phase of the engine is checked first, locking its row until the
+// transaction ends.
+func (s *Store) store(ctx context.Context, from *v1.EnginePhase, status *v1.EngineStatus) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if from != nil {
+		var phase string
+		err := tx.QueryRowContext(ctx, `SELECT phase FROM engines WHERE name = $1 FOR UPDATE`, status.Name).Scan(&phase)
+		if err == sql.ErrNoRows {
+			return store.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		if phase != store.PhaseValue(*from) {
+			return store.ErrPhaseChanged
+		}
+	}
+
+	md, cond := status.GetMetadata(), status.GetConditions()
+	repo := md.GetRepository()
+	stmts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{
+			`INSERT INTO engines (name, phase, details) VALUES ($1, $2, $3)
+			ON CONFLICT (name) DO UPDATE SET phase = EXCLUDED.phase, details = EXCLUDED.details`,
+			[]interface{}{status.Name, store.PhaseValue(status.Phase), status.Details},
+		},
+		{
+			`INSERT INTO engine_metadata (engine, owner, repository_host, repository_owner, repository_repo,
+				repository_ref, repository_revision, trigger, created, finished, engine_spec_name)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			ON CONFLICT (engine) DO UPDATE SET owner = EXCLUDED.owner,
+				repository_host = EXCLUDED.repository_host, repository_owner = EXCLUDED.repository_owner,
+				repository_repo = EXCLUDED.repository_repo, repository_ref = EXCLUDED.repository_ref,
+				repository_revision = EXCLUDED.repository_revision, trigger = EXCLUDED.trigger,
+				created = EXCLUDED.created, finished = EXCLUDED.finished,
+				engine_spec_name = EXCLUDED.engine_spec_name`,
+			[]interface{}{status.Name, md.GetOwner(), repo.GetHost(), repo.GetOwner(), repo.GetRepo(),
+				repo.GetRef(), repo.GetRevision(), store.TriggerValue(md.GetTrigger()),
+				nullTime(md.GetCreated()), nullTime(md.GetFinished()), md.GetEngineSpecName()},
+		},
+		{
+			`INSERT INTO engine_conditions (engine, success, failure_count, can_replay, wait_until, did_execute)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (engine) DO UPDATE SET success = EXCLUDED.success,
+				failure_count = EXCLUDED.failure_count, can_replay = EXCLUDED.can_replay,
+				wait_until = EXCLUDED.wait_until, did_execute = EXCLUDED.did_execute`,
+			[]interface{}{status.Name, cond.GetSuccess(), cond.GetFailureCount(), cond.GetCanReplay(),
+				nullTime(cond.GetWaitUntil()), cond.GetDidExecute()},
+		},
+		{`DELETE FROM engine_annotations WHERE engine = $1`, []interface{}{status.Name}},
+		{`DELETE FROM engine_results WHERE engine = $1`, []interface{}{status.Name}},
+		// Notifications are delivered once the transaction commits.
+		{`SELECT pg_notify($1, $2)`, []interface{}{notifyChannel, s.id + " " + status.Name}},
+	}
+	for _, a := range md.GetAnnotations() {
+		stmts = append(stmts, struct {
+			query string
+			args  []interface{}
+		}{
+			`INSERT INTO engine_annotations (engine, key, value) VALUES ($1, $2, $3)
+			ON CONFLICT (engine, key) DO UPDATE SET value = EXCLUDED.value`,
+			[]interface{}{status.Name, a.Key, a.Value},
+		})
+	}
+	for i, r := range status.Results {
+		stmts = append(stmts, struct {
+			query string
+			args  []interface{}
+		}{
+			`INSERT INTO engine_results (engine, position, type, payload, description, channels)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			[]interface{}{status.Name, i, r.Type, r.Payload, r.Description, pq.Array(r.Channels)},
+		})
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// selectEngines selects the columns scanned by scanEngine.
+const selectEngines = `SELECT e.name, e.phase, e.details,
+	m.owner, m.repository_host, m.repository_owner, m.repository_repo, m.repository_ref,
+	m.repository_revision, m.trigger, m.created, m.finished, m.engine_spec_name,
+	c.success, c.failure_count, c.can_replay, c.wait_until, c.did_execute
+FROM engines e
+JOIN engine_metadata m ON m.engine = e.name
+JOIN engine_conditions c ON c.engine = e.name`
+
+// Get implements store.Engines.
+func (s *Store) Get(ctx context.Context, name string) (*v1.EngineStatus, error) {
+	res, err := s.query(ctx, s.db, selectEngines+` WHERE e.name = $1`, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, store.ErrNotFound
+	}
+	return res[0], nil
+}
+
+// Find implements store.Engines. The total is counted in the same
+// transaction as the page is read, so that they are consistent.
+func (s *Store) Find(ctx context.Context, filter []*v1.FilterExpression, order []*v1.OrderExpression, start, limit int) ([]*v1.EngineStatus, int, error) {
+	var q query
+	where, err := q.where(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	// The count only uses the arguments of the WHERE clause.
+	countArgs := len(q.args)
+	orderBy, err := q.orderBy(order)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	from := `FROM engines e
+		JOIN engine_metadata m ON m.engine = e.name
+		JOIN engine_conditions c ON c.engine = e.name ` + where
+	var total int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) `+from, q.args[:countArgs]...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	page := selectEngines + " " + where + " " + orderBy
+	if start > 0 {
+		page += " OFFSET " + q.arg(start)
+	}
+	if limit > 0 {
+		page += " LIMIT " + q.arg(limit)
+	}
+	res, err := s.query(ctx, tx, page, q.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return res, total, tx.Commit()
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// query reads the engines selected by a query built on selectEngines,
+// along with their annotations and results.
+func (s *Store) query(ctx context.Context, db querier, query string, args ...interface{}) ([]*v1.EngineStatus, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		res    []*v1.EngineStatus
+		byName = map[string]*v1.EngineStatus{}
+		names  []string
+	)
+	for rows.Next() {
+		status, err := scanEngine(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		res = append(res, status)
+		byName[status.Name] = status
+		names = append(names, status.Name)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT engine, key, value FROM engine_annotations
+		WHERE engine = ANY($1) ORDER BY engine, key`, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			name string
+			a    v1.Annotation
+		)
+		if err := rows.Scan(&name, &a.Key, &a.Value); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		md := byName[name].Metadata
+		md.Annotations = append(md.Annotations, &a)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT engine, type, payload, description, channels FROM engine_results
+		WHERE engine = ANY($1) ORDER BY engine, position`, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name string
+			r    v1.EngineResult
+		)
+		if err := rows.Scan(&name, &r.Type, &r.Payload, &r.Description, pq.Array(&r.Channels)); err != nil {
+			return nil, err
+		}
+		status := byName[name]
+		status.Results = append(status.Results, &r)
+	}
+	return res, rows.Err()
+}
+
+// scanEngine scans a row selected by selectEngines.
+func scanEngine(rows *sql.Rows) (*v1.EngineStatus, error) {
+	var (
+		status = &v1.EngineStatus{
+			Metadata:   &v1.EngineMetadata{},
+			Conditions: &v1.EngineConditions{},
+		}
+		repo                         v1.Repository
+		phase, trigger               string
+		created, finished, waitUntil sql.NullTime
+	)
+	md, cond := status.Metadata, status.Conditions
+	err := rows.Scan(&status.Name, &phase, &status.Details,
+		&md.Owner, &repo.Host, &repo.Owner, &repo.Repo, &repo.Ref,
+		&repo.Revision, &trigger, &created, &finished, &md.EngineSpecName,
+		&cond.Success, &cond.FailureCount, &cond.CanReplay, &waitUntil, &cond.DidExecute)
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	if status.Phase, ok = store.ParsePhase(phase); !ok {
+		return nil, fmt.Errorf("engine %s has unknown phase %q", status.Name, phase)
+	}
+	if md.Trigger, ok = store.ParseTrigger(trigger); !ok {
+		return nil, fmt.Errorf("engine %s has unknown trigger %q", status.Name, trigger)
+	}
+	if repo.Host != "" || repo.Owner != "" || repo.Repo != "" || repo.Ref != "" || repo.Revision != "" {
+		md.Repository = &repo
+	}
+	md.Created = timestamp(created)
+	md.Finished = timestamp(finished)
+	cond.WaitUntil = timestamp(waitUntil)
+	return status, nil
+}
+
+// StoreInputs implements store.Inputs.
+func (s *Store) StoreInputs(ctx context.Context, name string, inputs *store.EngineInputs) error {
+	data, err := json.Marshal(inputs)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO engine_inputs (engine, inputs) VALUES ($1, $2)
+		ON CONFLICT (engine) DO UPDATE SET inputs = EXCLUDED.inputs`, name, data)
+	return err
+}
+
+// GetInputs implements store.Inputs.
+func (s *Store) GetInputs(ctx context.Context, name string) (*store.EngineInputs, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT inputs FROM engine_inputs WHERE engine = $1`, name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var inputs store.EngineInputs
+	if err := json.Unmarshal(data, &inputs); err != nil {
+		return nil, fmt.Errorf("cannot load inputs of %s: %w", name, err)
+	}
+	return &inputs, nil
+}
+
+// Next implements store.NumberGroup.
+func (s *Store) Next(group string) (int, error) {
+	var nr int
+	err := s.db.QueryRow(`INSERT INTO number_groups (name, number) VALUES ($1, 1)
+		ON CONFLICT (name) DO UPDATE SET number = number_groups.number + 1
+		RETURNING number`, group).Scan(&nr)
+	return nr, err
+}
+
+func nullTime(ts *timestamppb.Timestamp) sql.NullTime {
+	if ts == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: ts.AsTime(), Valid: true}
+}
+
+func timestamp(t sql.NullTime) *timestamppb.Timestamp {
+	if !t.Valid {
+		return nil
+	}
+	return timestamppb.New(t.Time.In(time.UTC))
+}
@@ -0,0 +1,259 @@
+package postgres
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/store"
+	"github.com/bhojpur/crypto/pkg/store/storetest"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// openTestStore connects to the database named by CRYPTO_TEST_POSTGRES and
+// empties it. The test is skipped if the variable is not set.
+func openTestStore(t *testing.T) *Store {
+	dsn := os.Getenv("CRYPTO_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("CRYPTO_TEST_POSTGRES is not set")
+	}
+	ctx := context.Background()
+	s, err := Open(ctx, dsn)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if _, err := s.DB().ExecContext(ctx, `TRUNCATE engines, engine_inputs, number_groups CASCADE`); err != nil {
+		t.Fatalf("cannot empty database: %v", err)
+	}
+	// Migrating an up to date schema does nothing.
+	if err := Migrate(ctx, s.DB()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		phase := v1.EnginePhase_PHASE_RUNNING
+		if i%2 == 0 {
+			phase = v1.EnginePhase_PHASE_DONE
+		}
+		err := s.Store(ctx, &v1.EngineStatus{
+			Name:  fmt.Sprintf("crypto-main.%d", i),
+			Phase: phase,
+			Metadata: &v1.EngineMetadata{
+				Owner:      "bhojpur",
+				Repository: &v1.Repository{Host: "github.com", Owner: "bhojpur", Repo: "crypto", Ref: "refs/heads/main"},
+				Trigger:    v1.EngineTrigger_TRIGGER_PUSH,
+				Created:    timestamppb.New(created.Add(time.Duration(i) * time.Minute)),
+				Annotations: []*v1.Annotation{
+					{Key: "index", Value: fmt.Sprint(i)},
+				},
+			},
+			Conditions: &v1.EngineConditions{Success: i == 0, FailureCount: int32(i)},
+			Results: []*v1.EngineResult{
+				{Type: "url", Payload: "https://bhojpur.net", Channels: []string{"github"}},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+
+	if _, err := s.Get(ctx, "crypto-main.9"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	st, err := s.Get(ctx, "crypto-main.1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	st.Phase = v1.EnginePhase_PHASE_DONE
+	st.Metadata.Finished = timestamppb.New(created.Add(time.Hour))
+	st.Metadata.Annotations = append(st.Metadata.Annotations, &v1.Annotation{Key: "retried", Value: "true"})
+	if err := s.Store(ctx, st); err != nil {
+		t.Fatalf("Store update: %v", err)
+	}
+	updated, err := s.Get(ctx, "crypto-main.1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !proto.Equal(st, updated) {
+		t.Fatalf("status did not round trip: %v != %v", updated, st)
+	}
+
+	slice, total, err := s.Find(ctx, []*v1.FilterExpression{
+		{Terms: []*v1.FilterTerm{{Field: "phase", Value: "done"}}},
+		{Terms: []*v1.FilterTerm{{Field: "annotations.index", Value: "4", Negate: true}}},
+	}, []*v1.OrderExpression{{Field: "conditions.failure_count", Ascending: true}}, 1, 1)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if total != 3 || len(slice) != 1 || slice[0].Name != "crypto-main.1" {
+		t.Fatalf("unexpected page: %v of %d", slice, total)
+	}
+
+	slice, total, err = s.Find(ctx, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if total != 5 || len(slice) != 5 || slice[0].Name != "crypto-main.4" {
+		t.Fatalf("unexpected default order: %v of %d", slice, total)
+	}
+}
+
+func TestTransition(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+
+	st := &v1.EngineStatus{
+		Name:       "local.1",
+		Phase:      v1.EnginePhase_PHASE_WAITING,
+		Metadata:   &v1.EngineMetadata{Owner: "bhojpur"},
+		Conditions: &v1.EngineConditions{},
+	}
+	if err := s.Store(ctx, st); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	// Of several concurrent claims of a waiting engine, exactly one wins.
+	errs := make(chan error, 5)
+	for i := 0; i < cap(errs); i++ {
+		claim := proto.Clone(st).(*v1.EngineStatus)
+		claim.Phase = v1.EnginePhase_PHASE_PREPARING
+		go func() {
+			errs <- s.Transition(ctx, v1.EnginePhase_PHASE_WAITING, claim)
+		}()
+	}
+	var won int
+	for i := 0; i < cap(errs); i++ {
+		err := <-errs
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, store.ErrPhaseChanged):
+			t.Fatalf("Transition: %v", err)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("%d claims succeeded", won)
+	}
+	if got, err := s.Get(ctx, "local.1"); err != nil || got.Phase != v1.EnginePhase_PHASE_PREPARING {
+		t.Fatalf("unexpected status: %v, %v", got, err)
+	}
+	if err := s.Transition(ctx, v1.EnginePhase_PHASE_WAITING, &v1.EngineStatus{Name: "local.2"}); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	storetest.RunFilterTests(t, openTestStore(t))
+}
+
+func TestNumberGroup(t *testing.T) {
+	s := openTestStore(t)
+	for _, expected := range []int{1, 2, 3} {
+		if nr, err := s.Next("local"); err != nil || nr != expected {
+			t.Fatalf("Next: %d, %v, expected %d", nr, err, expected)
+		}
+	}
+	if nr, err := s.Next("other"); err != nil || nr != 1 {
+		t.Fatalf("Next of other group: %d, %v", nr, err)
+	}
+}
+
+func TestInputs(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+	if _, err := s.GetInputs(ctx, "a.1"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	inputs := &store.EngineInputs{
+		Metadata:    &v1.EngineMetadata{Owner: "bhojpur"},
+		EngineYAML:  []byte("steps: []"),
+		Application: "sha256:abc",
+	}
+	if err := s.StoreInputs(ctx, "a.1", inputs); err != nil {
+		t.Fatalf("StoreInputs: %v", err)
+	}
+	res, err := s.GetInputs(ctx, "a.1")
+	if err != nil {
+		t.Fatalf("GetInputs: %v", err)
+	}
+	if res.Metadata.GetOwner() != "bhojpur" || string(res.EngineYAML) != "steps: []" || res.Application != inputs.Application {
+		t.Fatalf("unexpected inputs: %+v", res)
+	}
+}
+
+func TestParseNotification(t *testing.T) {
+	id, name, ok := parseNotification("0123abcd crypto-main.1")
+	if !ok || id != "0123abcd" || name != "crypto-main.1" {
+		t.Fatalf("unexpected result: %q, %q, %v", id, name, ok)
+	}
+	if _, _, ok := parseNotification("garbage"); ok {
+		t.Fatalf("expected malformed payload to be rejected")
+	}
+}
+
+func TestRelay(t *testing.T) {
+	local := openTestStore(t)
+	remote, err := Open(context.Background(), os.Getenv("CRYPTO_TEST_POSTGRES"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer remote.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relayed := make(chan *v1.EngineStatus, 10)
+	go local.Relay(ctx, os.Getenv("CRYPTO_TEST_POSTGRES"), func(s *v1.EngineStatus) { relayed <- s })
+	// Give the relay time to start listening.
+	time.Sleep(100 * time.Millisecond)
+
+	status := func(name string) *v1.EngineStatus {
+		return &v1.EngineStatus{Name: name, Phase: v1.EnginePhase_PHASE_RUNNING, Metadata: &v1.EngineMetadata{}, Conditions: &v1.EngineConditions{}}
+	}
+	if err := local.Store(ctx, status("local.1")); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := remote.Store(ctx, status("remote.1")); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	select {
+	case s := <-relayed:
+		if s.Name != "remote.1" {
+			t.Fatalf("unexpected relayed status: %v", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("status was not relayed")
+	}
+}
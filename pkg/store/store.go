@@ -0,0 +1,112 @@
+package store
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package store persists the status and log output of engines.
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/digest"
+)
+
+var (
+	// ErrNotFound is returned when an engine does not exist in a store.
+	ErrNotFound = errors.New("not found")
+
+	// ErrAlreadyExists is returned when logs are opened for an engine a
+	// second time.
+	ErrAlreadyExists = errors.New("exists already")
+
+	// ErrBadQuery is wrapped by errors of Find caused by filter or order
+	// expressions a store cannot evaluate.
+	ErrBadQuery = errors.New("bad query")
+
+	// ErrPhaseChanged is returned by Transition when the stored phase of
+	// an engine is not the expected one.
+	ErrPhaseChanged = errors.New("phase changed")
+)
+
+// Engines stores the status of engines.
+type Engines interface {
+	// Store stores the status of an engine, replacing any previous status
+	// of an engine with the same name.
+	Store(ctx context.Context, status *v1.EngineStatus) error
+
+	// Transition stores the status of an engine like Store, but only if
+	// the stored status of the engine is in phase from. Otherwise it
+	// returns ErrPhaseChanged, or ErrNotFound if the engine does not
+	// exist. As the check and the update are atomic, servers sharing a
+	// store can use it to claim an engine, such as to start a waiting one.
+	Transition(ctx context.Context, from v1.EnginePhase, status *v1.EngineStatus) error
+
+	// Get returns the status of an engine, or ErrNotFound.
+	Get(ctx context.Context, name string) (*v1.EngineStatus, error)
+
+	// Find returns the engines matching the filter, ordered as requested,
+	// skipping start engines and returning at most limit engines if limit
+	// is positive. The total number of matching engines is returned along
+	// with them.
+	Find(ctx context.Context, filter []*v1.FilterExpression, order []*v1.OrderExpression, start, limit int) (slice []*v1.EngineStatus, total int, err error)
+}
+
+// Logs stores the log output of engines.
+type Logs interface {
+	// Open returns a writer for the logs of an engine. The logs are
+	// complete once the writer is closed.
+	Open(name string) (io.WriteCloser, error)
+
+	// Read returns a reader for the logs of an engine, or ErrNotFound.
+	// While the logs are still being written the reader follows them,
+	// blocking until more output is written or the writer is closed.
+	Read(name string) (io.ReadCloser, error)
+}
+
+// NumberGroup hands out increasing numbers for groups of names.
+type NumberGroup interface {
+	// Next returns the next number of the group, starting at 1.
+	Next(group string) (int, error)
+}
+
+// EngineInputs are what an engine was started with, so that it can be
+// replayed.
+type EngineInputs struct {
+	Metadata   *v1.EngineMetadata
+	EngineYAML []byte
+	ConfigYAML []byte
+	NameSuffix string
+
+	// Application is the digest of the application tar stream or sideload
+	// of the engine in the content store, if it had any.
+	Application digest.Digest
+}
+
+// Inputs stores the inputs of engines.
+type Inputs interface {
+	// StoreInputs stores the inputs of an engine.
+	StoreInputs(ctx context.Context, name string, inputs *EngineInputs) error
+
+	// GetInputs returns the inputs of an engine, or ErrNotFound.
+	GetInputs(ctx context.Context, name string) (*EngineInputs, error)
+}
@@ -0,0 +1,154 @@
+package storetest
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package storetest checks that implementations of the store interfaces
+// behave alike. It is meant for tests of those implementations only.
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+	"github.com/bhojpur/crypto/pkg/store"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func date(s string) *timestamppb.Timestamp {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		panic(err)
+	}
+	return timestamppb.New(t)
+}
+
+// engines are the engines filtered by RunFilterTests.
+var engines = []*v1.EngineStatus{
+	{
+		Name:  "a.1",
+		Phase: v1.EnginePhase_PHASE_RUNNING,
+		Metadata: &v1.EngineMetadata{
+			Owner:       "bhojpur",
+			Created:     date("2021-03-04T05:06:07.123456Z"),
+			Annotations: []*v1.Annotation{{Key: "ci", Value: ""}, {Key: "os", Value: "linux"}},
+		},
+		Conditions: &v1.EngineConditions{FailureCount: 2},
+	},
+	{
+		Name:  "b.1",
+		Phase: v1.EnginePhase_PHASE_DONE,
+		Metadata: &v1.EngineMetadata{
+			Owner:    "someone",
+			Created:  date("2021-03-05T00:00:00Z"),
+			Finished: date("2021-03-05T01:02:03Z"),
+		},
+		Conditions: &v1.EngineConditions{Success: true, CanReplay: true},
+	},
+	{
+		Name:  "c.1",
+		Phase: v1.EnginePhase_PHASE_WAITING,
+		Metadata: &v1.EngineMetadata{
+			Owner:   "bhojpur",
+			Created: date("2021-03-06T12:00:00Z"),
+		},
+		Conditions: &v1.EngineConditions{WaitUntil: date("2021-04-01T00:00:00Z")},
+	},
+}
+
+// FilterTest is a filter and the names of the engines matching it.
+type FilterTest struct {
+	Name     string
+	Filter   []*v1.FilterExpression
+	Expected string
+}
+
+func term(field string, op v1.FilterOp, value string) *v1.FilterTerm {
+	return &v1.FilterTerm{Field: field, Operation: op, Value: value}
+}
+
+func negate(t *v1.FilterTerm) *v1.FilterTerm {
+	t.Negate = true
+	return t
+}
+
+func expr(terms ...*v1.FilterTerm) []*v1.FilterExpression {
+	return []*v1.FilterExpression{{Terms: terms}}
+}
+
+// FilterTests are the filters every store must evaluate alike.
+var FilterTests = []FilterTest{
+	{"all", nil, "a.1 b.1 c.1"},
+	{"text", expr(term("metadata.owner", v1.FilterOp_OP_EQUALS, "bhojpur")), "a.1 c.1"},
+	{"phase", expr(term("phase", v1.FilterOp_OP_EQUALS, "waiting")), "c.1"},
+	{"boolean", expr(term("conditions.success", v1.FilterOp_OP_EQUALS, "true")), "b.1"},
+	{"negated boolean", expr(negate(term("conditions.success", v1.FilterOp_OP_EQUALS, "true"))), "a.1 c.1"},
+	{"integer", expr(term("conditions.failure_count", v1.FilterOp_OP_EQUALS, "2")), "a.1"},
+	{"timestamp", expr(term("metadata.created", v1.FilterOp_OP_EQUALS, "2021-03-04T05:06:07Z")), "a.1"},
+	{"timestamp fraction", expr(term("metadata.created", v1.FilterOp_OP_CONTAINS, ".")), ""},
+	{"timestamp prefix", expr(term("metadata.created", v1.FilterOp_OP_STARTS_WITH, "2021-03-0")), "a.1 b.1 c.1"},
+	{"timestamp suffix", expr(term("metadata.finished", v1.FilterOp_OP_ENDS_WITH, "03Z")), "b.1"},
+	{"timestamp contains", expr(term("metadata.created", v1.FilterOp_OP_CONTAINS, "T05:06")), "a.1"},
+	{"negated null timestamp", expr(negate(term("metadata.finished", v1.FilterOp_OP_STARTS_WITH, "2021-03-05"))), "a.1 c.1"},
+	{"negated wait", expr(negate(term("conditions.wait_until", v1.FilterOp_OP_EQUALS, "2021-04-01T00:00:00Z"))), "a.1 b.1"},
+	{"timestamp exists", expr(term("metadata.finished", v1.FilterOp_OP_EXISTS, "")), "b.1"},
+	{"negated timestamp exists", expr(negate(term("conditions.wait_until", v1.FilterOp_OP_EXISTS, ""))), "a.1 b.1"},
+	{"annotation", expr(term("annotations.os", v1.FilterOp_OP_EQUALS, "linux")), "a.1"},
+	{"negated annotation", expr(negate(term("annotations.os", v1.FilterOp_OP_EQUALS, "linux"))), "b.1 c.1"},
+	{"empty annotation exists", expr(term("annotations.ci", v1.FilterOp_OP_EXISTS, "")), "a.1"},
+	{"or", expr(
+		term("phase", v1.FilterOp_OP_EQUALS, "done"),
+		term("conditions.wait_until", v1.FilterOp_OP_STARTS_WITH, "2021-04"),
+	), "b.1 c.1"},
+	{"and", append(
+		expr(term("metadata.owner", v1.FilterOp_OP_EQUALS, "bhojpur")),
+		expr(negate(term("metadata.created", v1.FilterOp_OP_STARTS_WITH, "2021-03-04")))...,
+	), "c.1"},
+}
+
+// RunFilterTests stores a set of engines in s, which must be empty, and
+// checks that Find evaluates FilterTests as expected.
+func RunFilterTests(t *testing.T, s store.Engines) {
+	ctx := context.Background()
+	for _, st := range engines {
+		if err := s.Store(ctx, st); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+	for _, test := range FilterTests {
+		t.Run(test.Name, func(t *testing.T) {
+			slice, total, err := s.Find(ctx, test.Filter, nil, 0, 0)
+			if err != nil {
+				t.Fatalf("Find: %v", err)
+			}
+			names := make([]string, 0, len(slice))
+			for _, st := range slice {
+				names = append(names, st.Name)
+			}
+			sort.Strings(names)
+			if got := strings.Join(names, " "); got != test.Expected || total != len(names) {
+				t.Fatalf("found %q of %d, expected %q", got, total, test.Expected)
+			}
+		})
+	}
+}
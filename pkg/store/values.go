@@ -0,0 +1,57 @@
+package store
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"strings"
+
+	v1 "github.com/bhojpur/crypto/pkg/api/v1"
+)
+
+// Enum fields are stored and filtered by their name without the type
+// prefix, in lower case, such as "running" for PHASE_RUNNING.
+
+const (
+	phasePrefix   = "PHASE_"
+	triggerPrefix = "TRIGGER_"
+)
+
+// PhaseValue returns the value of a phase in filters and storage.
+func PhaseValue(p v1.EnginePhase) string {
+	return strings.ToLower(strings.TrimPrefix(p.String(), phasePrefix))
+}
+
+// ParsePhase parses a value returned by PhaseValue.
+func ParsePhase(s string) (v1.EnginePhase, bool) {
+	v, ok := v1.EnginePhase_value[phasePrefix+strings.ToUpper(s)]
+	return v1.EnginePhase(v), ok
+}
+
+// TriggerValue returns the value of a trigger in filters and storage.
+func TriggerValue(t v1.EngineTrigger) string {
+	return strings.ToLower(strings.TrimPrefix(t.String(), triggerPrefix))
+}
+
+// ParseTrigger parses a value returned by TriggerValue.
+func ParseTrigger(s string) (v1.EngineTrigger, bool) {
+	v, ok := v1.EngineTrigger_value[triggerPrefix+strings.ToUpper(s)]
+	return v1.EngineTrigger(v), ok
+}
@@ -41,6 +41,26 @@ func TestParseDigest(t *testing.T) {
 			Algorithm: "sha384",
 			Encoded:   "d3fc7881460b7e22e3d172954463dddd7866d17597e7248453c48b3e9d26d9596bf9c4a9cf8072c9d5bad76e19af801d",
 		},
+		{
+			Input:     "sha224:10e620eb28ca7fc9420903bff1cf1bb62dc4bdd5c3d0c3d90aa11fd2",
+			Algorithm: "sha224",
+			Encoded:   "10e620eb28ca7fc9420903bff1cf1bb62dc4bdd5c3d0c3d90aa11fd2",
+		},
+		{
+			Input:     "sha512_224:2432171b029bd9d32179e3249b899b273b308689ea189a95cf05b952",
+			Algorithm: "sha512_224",
+			Encoded:   "2432171b029bd9d32179e3249b899b273b308689ea189a95cf05b952",
+		},
+		{
+			Input:     "sha512_256:a9052e2f17d136b7e0d04f39ac7429e8c8b4f935ea7eac5cb2eedd69bd1384d3",
+			Algorithm: "sha512_256",
+			Encoded:   "a9052e2f17d136b7e0d04f39ac7429e8c8b4f935ea7eac5cb2eedd69bd1384d3",
+		},
+		{
+			// too short (sha512_256 is shorter than sha512)
+			Input: "sha512_256:a9052e2f17d136b7e0d04f39ac7429e8",
+			Err:   digest.ErrDigestInvalidLength,
+		},
 		{
 			// empty
 			Input: "",